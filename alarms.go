@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+/*
+alarmsOptions are the options of alarms mode
+*/
+type alarmsOptions struct {
+	AlarmFlaps      string        `long:"alarm-flaps" description:"Warning and critical thresholds for the times a memory or disk alarm of a node was set within --alarm-flap-window in alarms mode, e.g. 2,4. The alarms are followed across runs in --state-dir."`
+	AlarmFlapWindow time.Duration `long:"alarm-flap-window" default:"6h" description:"The history the alarms are counted over with --alarm-flaps."`
+}
+
+/*
+alarmHistory follows one alarm of a node across runs: whether it was set at the previous run and the times it
+was seen going off within --alarm-flap-window
+*/
+type alarmHistory struct {
+	Set    bool    `json:"set"`
+	Raised []int64 `json:"raised"`
+}
+
+/*
+runAlarms checks no node has a resource alarm. While one is set the whole cluster blocks its publishers, however
+short the queues are. The nodes report their alarms on every version, unlike the alarms health check of 3.8.10
+and later which does not tell which node raised one.
+*/
+func runAlarms(opt *options, hosts []string) {
+	flaps, err := optionalLimits(opt.AlarmFlaps)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	if flaps != nil && opt.AlarmFlapWindow <= 0 {
+		printUnknown("--alarm-flap-window must be positive")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		history := map[string]alarmHistory{}
+		if flaps != nil {
+			err = loadState(opt, "alarms", &history)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+		history = processAlarms(nodes, flaps, opt.AlarmFlapWindow, history)
+		if flaps != nil {
+			err = saveState(opt, "alarms", history)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the nodes from any host")
+}
+
+/*
+processAlarms prints a summary line followed by the alarms of every node and, with flaps, the alarms going off
+too often. An alarm set and cleared over and over shows a cluster sized right at its watermark: every run may
+find it clear while the publishers keep being blocked in between. Returns the history for the next run.
+*/
+func processAlarms(nodes []Node, flaps []int, window time.Duration, history map[string]alarmHistory) map[string]alarmHistory {
+	now := time.Now()
+	current := map[string]alarmHistory{}
+	checked, warnings, criticals := 0, 0, 0
+	lines := []string{}
+	for _, node := range nodes {
+		// a stopped node raises no alarm, node mode reports it
+		if node.Running == false {
+			continue
+		}
+		checked++
+
+		alarms := []string{}
+		if node.MemAlarm {
+			alarms = append(alarms, downgradeLine(node.Name, "CRITICAL node "+node.Name+" has a memory alarm, "+
+				formatBytes(int64(node.MemUsed))+" used of the "+formatBytes(int64(node.MemLimit))+" watermark"))
+		}
+		if node.DiskFreeAlarm {
+			alarms = append(alarms, downgradeLine(node.Name, "CRITICAL node "+node.Name+" has a disk alarm, "+
+				formatBytes(int64(node.DiskFree))+" free below the "+formatBytes(int64(node.DiskFreeLimit))+" limit"))
+		}
+		recordGauge("rabbitmq.node.alarms", float64(len(alarms)), "node", node.Name)
+
+		if flaps != nil {
+			for _, alarm := range []struct {
+				kind string
+				set  bool
+			}{{"memory", node.MemAlarm}, {"disk", node.DiskFreeAlarm}} {
+				key := node.Name + "/" + alarm.kind
+				followed := history[key]
+				raised := []int64{}
+				for _, at := range followed.Raised {
+					if now.Sub(time.Unix(at, 0)) <= window {
+						raised = append(raised, at)
+					}
+				}
+				if alarm.set && followed.Set == false {
+					raised = append(raised, now.Unix())
+				}
+				current[key] = alarmHistory{Set: alarm.set, Raised: raised}
+				recordPerf(node.Name+"_"+alarm.kind+"_alarms", float64(len(raised)), "", strconv.Itoa(flaps[0]), strconv.Itoa(flaps[1]))
+
+				message := "node " + node.Name + " " + alarm.kind + " alarm went off " + strconv.Itoa(len(raised)) +
+					" times in the last " + window.String()
+				line := ""
+				if len(raised) >= flaps[1] {
+					line = "CRITICAL " + message
+				} else if len(raised) >= flaps[0] {
+					line = "WARNING " + message
+				}
+				alarms = append(alarms, downgradeLine(node.Name, line))
+			}
+		}
+
+		kept := []string{}
+		for _, line := range alarms {
+			if line != "" {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) > 0 && worstState(kept) == "CRITICAL" {
+			criticals++
+		} else if len(kept) > 0 {
+			warnings++
+		}
+		lines = append(lines, kept...)
+	}
+
+	state := worstState(lines)
+	if len(lines) == 0 {
+		state = "OK"
+	}
+	recordPerf("alarmed_nodes", float64(warnings+criticals), "", "", "")
+	printLine(state + " " + summaryCounts("nodes", checked, warnings, criticals))
+	for _, line := range lines {
+		printLine(line)
+	}
+	return current
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/url"
+)
+
+/*
+alivenessResult is the answer of /api/aliveness-test/{vhost}
+*/
+type alivenessResult struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+/*
+runAliveness has every host publish and consume a test message through the --vhost with the aliveness test
+of the management api, covering the broker end to end rather than only the api answering
+*/
+func runAliveness(opt *options, hosts []string) {
+	if opt.Vhost == "" {
+		printUnknown("The aliveness mode requires --vhost")
+		return
+	}
+
+	checkHosts(hosts, func(host string) {
+		processAliveness(opt, host)
+	})
+}
+
+/*
+processAliveness runs the aliveness test on a host, anything but a status of ok is critical
+*/
+func processAliveness(opt *options, host string) {
+	result := alivenessResult{}
+	err := apiRequest(opt, host, "GET", "/api/aliveness-test/"+url.PathEscape(opt.Vhost), nil, &result)
+	if err != nil {
+		printLine("CRITICAL aliveness test of vhost " + opt.Vhost + " on " + host + " failed: " + err.Error())
+		return
+	}
+	if result.Status != "ok" {
+		message := "CRITICAL aliveness test of vhost " + opt.Vhost + " on " + host + " returned status " + result.Status
+		if result.Reason != "" {
+			message = message + ": " + result.Reason
+		}
+		printLine(message)
+		return
+	}
+	printLine("OK aliveness test of vhost " + opt.Vhost + " on " + host + " passed")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+amqpsOptions are the options of amqps mode
+*/
+type amqpsOptions struct {
+	AmqpsPort string `long:"amqps-port" default:"5671" description:"The port of the amqps listener checked in amqps mode."`
+}
+
+// tlsVersions maps the accepted --tls-min-version values to the crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+/*
+tlsVersionName returns the human readable name of a negotiated tls version
+*/
+func tlsVersionName(version uint16) string {
+	for name, value := range tlsVersions {
+		if value == version {
+			return "TLSv" + name
+		}
+	}
+	return "unknown TLS version " + strconv.Itoa(int(version))
+}
+
+/*
+runAmqps checks the amqps listener of every host
+*/
+func runAmqps(opt *options, hosts []string) {
+	expiry, err := limitMap(opt.CertExpiry)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	floor, ok := tlsVersions[opt.TLSMinVersion]
+	if ok == false {
+		printUnknown("Unknown tls version " + opt.TLSMinVersion)
+		return
+	}
+
+	checkHosts(hosts, func(host string) {
+		processAmqps(opt, host, expiry, floor)
+	})
+}
+
+/*
+processAmqps performs a tls handshake against the amqps port, validates the chain and checks expiry and protocol floor
+*/
+func processAmqps(opt *options, host string, expiry []int, floor uint16) {
+	address := net.JoinHostPort(host, opt.AmqpsPort)
+
+	// the chain is verified by hand below so the expiry can still be reported for an untrusted certificate
+	state, err := tlsHandshake(address, probeTLS(host))
+	if err != nil {
+		printLine("CRITICAL tls handshake with " + address + " failed: " + err.Error())
+		return
+	}
+
+	leaf := state.PeerCertificates[0]
+	err = verifyChain(host, state.PeerCertificates)
+	if err != nil {
+		printLine("CRITICAL certificate of " + address + " is not trusted: " + err.Error())
+	} else {
+		printLine("OK certificate of " + address + " is trusted")
+	}
+
+	days := int(time.Until(leaf.NotAfter).Hours() / 24)
+	remaining := strconv.Itoa(days) + " days (" + leaf.NotAfter.Format("2006-01-02") + ")"
+	if days <= expiry[1] {
+		printLine("CRITICAL certificate of " + address + " expires in " + remaining)
+	} else if days <= expiry[0] {
+		printLine("WARNING certificate of " + address + " expires in " + remaining)
+	} else {
+		printLine("OK certificate of " + address + " expires in " + remaining)
+	}
+
+	negotiated := tlsVersionName(state.Version)
+	if state.Version < floor {
+		printLine("CRITICAL " + address + " negotiated " + negotiated + " below the floor of TLSv" + opt.TLSMinVersion)
+		return
+	}
+
+	// offer only versions below the floor, a successful handshake means the listener still accepts them
+	if floor > tls.VersionTLS10 {
+		config := probeTLS(host)
+		config.MinVersion, config.MaxVersion = tls.VersionTLS10, floor-1
+		old, err := tlsHandshake(address, config)
+		if err == nil {
+			printLine("CRITICAL " + address + " accepts " + tlsVersionName(old.Version) + " below the floor of TLSv" + opt.TLSMinVersion)
+			return
+		}
+	}
+	printLine("OK " + address + " negotiated " + negotiated)
+}
+
+/*
+tlsHandshake dials the address and completes a tls handshake, returning the connection state
+*/
+func tlsHandshake(address string, config *tls.Config) (*tls.ConnectionState, error) {
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("No certificate presented by the server")
+	}
+	return &state, nil
+}
+
+/*
+verifyChain validates the presented certificates against the system roots, or those of --tls-ca-file, and the host name
+*/
+func verifyChain(host string, certificates []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+		Roots:         tlsClient.RootCAs,
+	})
+	return err
+}
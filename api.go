@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds the connection and the exchange of the probes speaking a protocol of their own, so a stuck
+// port or proxy can not hold the check past --timeout, set from it
+var probeTimeout = 10 * time.Second
+
+// httpClient is shared by every request of a run so connections and tls sessions are reused across endpoints and hosts
+var httpClient = &http.Client{Transport: newTransport()}
+
+// apiFailures are the requests of the current run that timed out or, with --strict, were answered in an unexpected
+// shape, reported by reportFailures
+var apiFailures []string
+
+var failureMutex sync.Mutex
+
+/*
+newTransport builds the pooled transport behind httpClient
+*/
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   probeTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          64,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   probeTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsClient,
+	}
+}
+
+/*
+apiURL builds the url of a management api path on the given host
+*/
+func apiURL(opt *options, host, path string) string {
+	prefix := "http"
+	if opt.Secure == true {
+		prefix = "https"
+	}
+	return prefix + "://" + net.JoinHostPort(host, opt.Port) + path
+}
+
+/*
+queuePath returns the api path of a queue in the configured vhost
+*/
+func queuePath(opt *options, name string) string {
+	return "/api/queues/" + url.PathEscape(opt.Vhost) + "/" + url.PathEscape(name)
+}
+
+/*
+statsQuery returns the msg_rates_age/lengths_age parameters asking the api to average rates and lengths
+over a window instead of returning the latest sample
+*/
+func statsQuery(opt *options) url.Values {
+	query := url.Values{}
+	if opt.MsgRatesAge > 0 {
+		query.Set("msg_rates_age", strconv.Itoa(opt.MsgRatesAge))
+		query.Set("msg_rates_incr", strconv.Itoa(opt.MsgRatesIncr))
+	}
+	if opt.LengthsAge > 0 {
+		query.Set("lengths_age", strconv.Itoa(opt.LengthsAge))
+		query.Set("lengths_incr", strconv.Itoa(opt.LengthsIncr))
+	}
+	return query
+}
+
+/*
+statsPath appends the statsQuery parameters to the path of a statistics endpoint
+*/
+func statsPath(opt *options, path string) string {
+	query := statsQuery(opt)
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+/*
+apiDo sends a request to the management api of the host, payload is encoded as the json request body when it is
+not nil. Error statuses are turned into errors, otherwise the response must be released with closeResponse.
+*/
+func apiDo(opt *options, host, method, path string, payload interface{}) (response *http.Response, err error) {
+	var reader io.Reader
+	if payload != nil {
+		reader, err = jsonReader(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequest(method, apiURL(opt, host, path), reader)
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(opt.Username, opt.Password)
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	status := ""
+	if tracer != nil {
+		spanID, traceparent := tracer.traceparent()
+		request.Header.Set("traceparent", traceparent)
+		start := time.Now()
+		defer func() {
+			tracer.recordSpan(spanID, method+" "+strings.SplitN(path, "?", 2)[0], start,
+				attributes("http.method", method, "http.url", request.URL.String(), "http.status_code", status, "net.peer.name", host), err)
+		}()
+	}
+
+	response, err = httpClient.Do(request)
+	if err != nil {
+		return nil, timeoutError(err, method, path, host)
+	}
+	status = strconv.Itoa(response.StatusCode)
+
+	if response.StatusCode >= 400 {
+		defer closeResponse(response)
+		message := method + " " + path + " returned " + response.Status
+
+		// the api explains most failures in a small json body
+		failure := struct {
+			Error  string `json:"error"`
+			Reason string `json:"reason"`
+		}{}
+		json.NewDecoder(io.LimitReader(response.Body, 64*1024)).Decode(&failure)
+		if failure.Reason != "" {
+			message = message + ": " + failure.Reason
+		} else if failure.Error != "" {
+			message = message + ": " + failure.Error
+		}
+		return nil, &statusError{status: response.StatusCode, message: message}
+	}
+	return response, nil
+}
+
+/*
+timeoutError replaces the error of a request that ran into --timeout, while waiting for the answer or reading
+it, with one telling so, and remembers it for reportFailures. Other errors are returned as they are.
+*/
+func timeoutError(err error, method, path, host string) error {
+	netErr, ok := err.(net.Error)
+	if ok == false || netErr.Timeout() == false {
+		return err
+	}
+
+	message := method + " " + strings.SplitN(path, "?", 2)[0] + " on " + host + " timed out after " + httpClient.Timeout.String()
+	rememberFailure(message)
+	return errors.New(message)
+}
+
+/*
+rememberFailure keeps the message of a failed request for reportFailures
+*/
+func rememberFailure(message string) {
+	failureMutex.Lock()
+	apiFailures = append(apiFailures, message)
+	failureMutex.Unlock()
+}
+
+/*
+reportFailures explains the unknown results of a run by the requests that timed out or were answered in an
+unexpected shape, which the checks falling back on the next host only logged. Failures already printed are left
+out, as are all of them when nothing is unknown.
+*/
+func reportFailures() {
+	failureMutex.Lock()
+	failures := apiFailures
+	apiFailures = nil
+	failureMutex.Unlock()
+
+	outputMutex.Lock()
+	unknown := false
+	printed := strings.Join(outputLines, "\n")
+	for _, line := range outputLines {
+		if lineState(line) == "UNKNOWN" {
+			unknown = true
+		}
+	}
+	outputMutex.Unlock()
+	if unknown == false {
+		return
+	}
+
+	reported := map[string]bool{}
+	for _, message := range failures {
+		if reported[message] == false && strings.Contains(printed, message) == false {
+			printUnknown(message)
+		}
+		reported[message] = true
+	}
+}
+
+/*
+statusError is the error of a request answered with an error status, for callers telling e.g. a missing
+endpoint from a failing one
+*/
+type statusError struct {
+	status  int
+	message string
+}
+
+func (err *statusError) Error() string {
+	return err.message
+}
+
+/*
+errorStatus returns the http status of an error returned by the api, 0 when the request did not get an answer
+*/
+func errorStatus(err error) int {
+	if failure, ok := err.(*statusError); ok {
+		return failure.status
+	}
+	return 0
+}
+
+/*
+jsonReader encodes a payload as a json request body
+*/
+func jsonReader(payload interface{}) (io.Reader, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(encoded), nil
+}
+
+/*
+closeResponse drains what the decoder left of the body so the connection can go back to the pool
+*/
+func closeResponse(response *http.Response) {
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+}
+
+/*
+apiRequest sends a request to the management api of the host and decodes the json response into result.
+payload is encoded as the json request body when it is not nil, result is ignored when nil.
+*/
+func apiRequest(opt *options, host, method, path string, payload interface{}, result interface{}) error {
+	response, err := apiDo(opt, host, method, path, payload)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(response)
+
+	if result == nil {
+		return nil
+	}
+
+	// a response decoded whole takes at least its own size in memory
+	var body io.Reader = response.Body
+	if maxMemory > 0 {
+		if response.ContentLength > maxMemory {
+			return errMemoryBudget
+		}
+		body = &budgetReader{reader: body, remaining: maxMemory}
+	}
+
+	// the cache only pays off across the cycles of a resident process, a single run reads every path once
+	if method == "GET" && opt.CacheTTL > 0 && (opt.Watch > 0 || opt.Listen != "") {
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			return timeoutError(err, method, path, host)
+		}
+		err = cachedDecode(host+path, raw, result, opt.CacheTTL)
+		if err != nil {
+			return err
+		}
+		return checkFields(method, path, raw, result, "")
+	}
+	if strictDecoding {
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			return timeoutError(err, method, path, host)
+		}
+		if len(raw) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(raw, result)
+		if err != nil {
+			return err
+		}
+		return checkFields(method, path, raw, result, "")
+	}
+
+	err = json.NewDecoder(body).Decode(result)
+	if err == io.EOF {
+		return nil
+	}
+	return timeoutError(err, method, path, host)
+}
+
+/*
+apiPages requests every page of a paginated management api listing, streaming the items of each page
+to each. query carries the filtering (name, use_regex) and columns parameters, the paging ones are added here.
+The first page tells the page count, the others are then fetched by --concurrency workers; each is called
+for one item at a time but the items of different pages interleave.
+*/
+func apiPages(opt *options, host, path string, query url.Values, each func(decoder *json.Decoder) error) (pageInfo, error) {
+	var mutex sync.Mutex
+	locked := func(decoder *json.Decoder) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return each(decoder)
+	}
+
+	fetchPage := func(page int) (pageInfo, error) {
+		pageQuery := url.Values{}
+		for key, value := range query {
+			pageQuery[key] = value
+		}
+		pageQuery.Set("page", strconv.Itoa(page))
+		pageQuery.Set("page_size", strconv.Itoa(opt.PageSize))
+		pagePath := path + "?" + pageQuery.Encode()
+
+		response, err := apiDo(opt, host, "GET", pagePath, nil)
+		if err != nil {
+			return pageInfo{}, err
+		}
+		defer closeResponse(response)
+
+		info, err := decodePage(json.NewDecoder(response.Body), pagePath, locked)
+		return info, timeoutError(err, "GET", pagePath, host)
+	}
+
+	info, err := fetchPage(1)
+	if err != nil || info.PageCount <= 1 {
+		return info, err
+	}
+
+	err = parallel(opt.Concurrency, info.PageCount-1, func(index int) error {
+		_, err := fetchPage(index + 2)
+		return err
+	})
+	return info, err
+}
+
+/*
+pageInfo carries the paging fields of a page: the number of pages, of objects matching the filter and of all objects
+*/
+type pageInfo struct {
+	PageCount     int `json:"page_count"`
+	FilteredCount int `json:"filtered_count"`
+	TotalCount    int `json:"total_count"`
+}
+
+/*
+decodePage walks a page object, streaming its items and returning its paging fields
+*/
+func decodePage(decoder *json.Decoder, path string, each func(decoder *json.Decoder) error) (pageInfo, error) {
+	info := pageInfo{}
+	token, err := decoder.Token()
+	if err != nil {
+		return info, err
+	}
+	if delim, ok := token.(json.Delim); ok == false || delim != '{' {
+		return info, errors.New("GET " + path + " did not return a page")
+	}
+
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return info, err
+		}
+
+		switch token {
+		case "items":
+			err = decodeArray(decoder, path, each)
+		case "page_count":
+			err = decoder.Decode(&info.PageCount)
+		case "filtered_count":
+			err = decoder.Decode(&info.FilteredCount)
+		case "total_count":
+			err = decoder.Decode(&info.TotalCount)
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return info, err
+		}
+	}
+
+	_, err = decoder.Token()
+	return info, err
+}
+
+/*
+decodeArray hands the decoder to each for every element of the json array it is positioned at
+*/
+func decodeArray(decoder *json.Decoder, path string, each func(decoder *json.Decoder) error) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); ok == false || delim != '[' {
+		return errors.New("GET " + path + " did not return a json array")
+	}
+
+	for decoder.More() {
+		err = each(decoder)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
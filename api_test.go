@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+/*
+testHosts serves the api responses of every host on its loopback address, all on the same port like the nodes
+of a cluster share --port, and returns the port. The paths are matched escaped, a path missing from the responses of a host answers 404.
+*/
+func testHosts(t *testing.T, hosts map[string]map[string]string) string {
+	port := "0"
+	for _, address := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		responses, ok := hosts[address]
+		if ok == false {
+			continue
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+		server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, ok := responses[request.URL.EscapedPath()]
+			if ok == false {
+				http.NotFound(writer, request)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(body))
+		})}
+		go server.Serve(listener)
+		t.Cleanup(func() { server.Close() })
+	}
+	return port
+}
+
+/*
+runCheck runs the check of the arguments and returns the lines it printed, held so the test output stays clean
+*/
+func runCheck(t *testing.T, arguments ...string) []string {
+	opt, args, _, err := parseOptions(arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, args, err := applyOptions(opt, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resetOutput()
+	holdOutput()
+	runChecks(opt, hosts, args)
+	return releaseOutput()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+benchOptions are the options of bench mode
+*/
+type benchOptions struct {
+	Messages  int    `long:"messages" default:"1000" description:"The number of messages published and consumed in bench mode."`
+	BenchRate string `long:"bench-rate" default:"200,50" description:"Warning and critical low watermarks in msgs/sec for bench mode."`
+	BenchP99  string `long:"bench-p99" default:"100,500" description:"Warning and critical thresholds in milliseconds for the p99 confirm latency in bench mode."`
+}
+
+/*
+runBench runs the throughput micro-benchmark against every host
+*/
+func runBench(opt *options, hosts []string) {
+	if opt.Messages <= 0 {
+		printUnknown("The bench mode requires a positive --messages")
+		return
+	}
+
+	rateLimits, err := limitMap(opt.BenchRate)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	p99Limits, err := limitMap(opt.BenchP99)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	guardProbe(opt, func() {
+		checkHosts(hosts, func(host string) {
+			sweepHost(opt, host)
+			processBench(opt, host, rateLimits, p99Limits)
+		})
+	})
+}
+
+/*
+processBench publishes a batch of messages to a probe queue, consumes them back and thresholds
+the achieved rate (a low watermark) and the 99th percentile of the publisher confirm latency
+*/
+func processBench(opt *options, host string, rateLimits, p99Limits []int) {
+	queue, err := declareProbeQueue(opt, host)
+	if err != nil {
+		printLine("CRITICAL declaring bench queue on " + host + " failed: " + err.Error())
+		return
+	}
+	defer runCleanup(host + " " + queue)
+
+	latencies := make([]time.Duration, 0, opt.Messages)
+	start := time.Now()
+	for i := 0; i < opt.Messages; i++ {
+		latency, err := publishConfirmed(opt, host, queue, "nagios bench "+strconv.Itoa(i))
+		if err != nil {
+			printLine("CRITICAL publishing bench message " + strconv.Itoa(i) + " on " + host + " failed: " + err.Error())
+			return
+		}
+		latencies = append(latencies, latency)
+	}
+
+	consumed := 0
+	request := getRequest{Count: canaryBatch, Ackmode: "ack_requeue_false", Encoding: "auto", Truncate: 64}
+	for consumed < opt.Messages {
+		messages := []getMessage{}
+		err := apiRequest(opt, host, "POST", queuePath(opt, queue)+"/get", request, &messages)
+		if err != nil {
+			printLine("CRITICAL consuming bench messages on " + host + " failed: " + err.Error())
+			return
+		}
+		if len(messages) == 0 {
+			break
+		}
+		consumed = consumed + len(messages)
+	}
+	elapsed := time.Since(start)
+
+	if consumed < opt.Messages {
+		printLine("CRITICAL only " + strconv.Itoa(consumed) + " of " + strconv.Itoa(opt.Messages) + " bench messages were consumed on " + host)
+		return
+	}
+
+	rate := int(float64(consumed) / elapsed.Seconds())
+	message := strconv.Itoa(rate) + " msgs/sec on " + host + " (" + strconv.Itoa(consumed) + " messages in " + elapsed.String() + ")"
+	if rate <= rateLimits[1] {
+		printLine("CRITICAL " + message)
+	} else if rate <= rateLimits[0] {
+		printLine("WARNING " + message)
+	} else {
+		printLine("OK " + message)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p99 := int(latencies[(len(latencies)*99+99)/100-1] / time.Millisecond)
+	if p99 >= p99Limits[1] {
+		printLine("CRITICAL p99 confirm latency " + strconv.Itoa(p99) + "ms on " + host)
+	} else if p99 >= p99Limits[0] {
+		printLine("WARNING p99 confirm latency " + strconv.Itoa(p99) + "ms on " + host)
+	} else {
+		printLine("OK p99 confirm latency " + strconv.Itoa(p99) + "ms on " + host)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+/*
+topicBindingOptions are the options of topic-bindings mode
+*/
+type topicBindingOptions struct {
+	TopicBindings string `long:"topic-bindings" default:"1000,10000" description:"Warning and critical thresholds for the bindings of a single topic exchange in topic-bindings mode."`
+}
+
+/*
+Binding representation from /api/bindings
+*/
+type Binding struct {
+	Source          string `json:"source"`
+	Vhost           string `json:"vhost"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"`
+	RoutingKey      string `json:"routing_key"`
+}
+
+/*
+fetchBindings returns the bindings of the --vhost, or of the whole cluster when it is empty. The api does not
+paginate bindings.
+*/
+func fetchBindings(opt *options, host string) ([]Binding, error) {
+	path := "/api/bindings"
+	if opt.Vhost != "" {
+		path = path + "/" + url.PathEscape(opt.Vhost)
+	}
+
+	bindings := []Binding{}
+	err := apiRequest(opt, host, "GET", path+"?columns=source,vhost,destination,destination_type", nil, &bindings)
+	if err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+/*
+runTopicBindings thresholds the number of bindings of every topic exchange. Binding a queue per user or
+session makes them grow without bound, and since every publish is matched against them routing slows down
+for the whole cluster.
+*/
+func runTopicBindings(opt *options, hosts []string) {
+	limits, err := limitMap(opt.TopicBindings)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		counts, err := topicBindingCounts(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processTopicBindings(counts, limits)
+		return
+	}
+	printLine("UNKNOWN could not read the bindings from any host")
+}
+
+/*
+topicBindingCounts counts the bindings of every topic exchange, keyed by vhost/exchange
+*/
+func topicBindingCounts(opt *options, host string) (map[string]int, error) {
+	counts := map[string]int{}
+	err := listExchanges(opt, host, func(exchange Exchange) error {
+		if exchange.Type == "topic" && (opt.Vhost == "" || exchange.Vhost == opt.Vhost) {
+			counts[exchange.Vhost+"/"+exchange.Name] = 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := fetchBindings(opt, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, binding := range bindings {
+		key := binding.Vhost + "/" + binding.Source
+		if _, ok := counts[key]; ok {
+			counts[key]++
+		}
+	}
+	return counts, nil
+}
+
+/*
+processTopicBindings prints a summary line followed by the exchanges beyond the limits
+*/
+func processTopicBindings(counts map[string]int, limits []int) {
+	names := []string{}
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	warnings, criticals := 0, 0
+	breaches := []string{}
+	for _, name := range names {
+		count := counts[name]
+		recordGauge("rabbitmq.exchange.bindings", float64(count), "exchange", name)
+
+		message := "topic exchange " + name + " has " + strconv.Itoa(count) + " bindings"
+		line := ""
+		if count >= limits[1] {
+			line = "CRITICAL " + message
+		} else if count >= limits[0] {
+			line = "WARNING " + message
+		}
+		if line = downgradeLine(name, line); line == "" {
+			continue
+		}
+
+		if lineState(line) == "CRITICAL" {
+			criticals++
+		} else {
+			warnings++
+		}
+		breaches = append(breaches, line)
+	}
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("topic exchanges", len(names), warnings, criticals))
+	for _, line := range breaches {
+		printLine(line)
+	}
+}
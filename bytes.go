@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+MessageBytes are the sizes of the message bodies held in queues, in total, ready and unacknowledged
+*/
+type MessageBytes struct {
+	Total int64
+	Ready int64
+	Unack int64
+}
+
+// bytesPerfNames label the message bytes in the perfdata
+var bytesPerfNames = []string{"message_bytes", "message_bytes_ready", "message_bytes_unacknowledged"}
+
+/*
+byteLimits parses a --bytes-warning or --bytes-critical value: the total, ready and unacknowledged sizes
+such as 1G,800M,200M. An empty value disables the byte thresholds and returns nil.
+*/
+func byteLimits(str string) ([]int64, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	values := strings.Split(str, ",")
+	if len(values) != 3 {
+		return nil, errors.New("A list of three sizes, total, ready and unacknowledged, is required for byte limits.")
+	}
+	limits := []int64{}
+	for _, value := range values {
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, size)
+	}
+	return limits, nil
+}
+
+/*
+bytesOptions parses --bytes-warning and --bytes-critical, which have to be given together
+*/
+func bytesOptions(opt *options) ([]int64, []int64, error) {
+	warning, err := byteLimits(opt.BytesWarning)
+	if err != nil {
+		return nil, nil, err
+	}
+	critical, err := byteLimits(opt.BytesCritical)
+	if err != nil {
+		return nil, nil, err
+	}
+	if (warning == nil) != (critical == nil) {
+		return nil, nil, errors.New("--bytes-warning and --bytes-critical have to be given together")
+	}
+	return warning, critical, nil
+}
+
+/*
+formatBytes renders a size with a binary unit, e.g. 1.5 GiB
+*/
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value = value / 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[unit]
+}
+
+/*
+evaluateBytes applies the byte limits to the sizes, capped by --max-severity, returning a line per size prefixed
+with its state. subject prefixes the lines, e.g. the name of the queue.
+*/
+func evaluateBytes(subject string, sizes MessageBytes, warning, critical []int64) []string {
+	lines := []string{}
+	values := []int64{sizes.Total, sizes.Ready, sizes.Unack}
+	kinds := []string{"message bytes", "message bytes ready", "message bytes unacknowledged"}
+	metrics := []string{"bytes", "bytes-ready", "bytes-unacked"}
+	for index, value := range values {
+		message := subject + formatBytes(value) + " " + kinds[index]
+		if value >= critical[index] {
+			lines = append(lines, capSeverity(metrics[index], "CRITICAL "+message))
+		} else if value >= warning[index] {
+			lines = append(lines, "WARNING "+message)
+		} else {
+			lines = append(lines, "OK "+message)
+		}
+	}
+	return lines
+}
+
+/*
+overviewBytes returns the message bytes of the whole cluster. The overview does not carry them, so they
+are read from the prometheus totals with --source=prometheus and summed over the queues otherwise.
+*/
+func overviewBytes(opt *options, host string) (MessageBytes, error) {
+	sizes := MessageBytes{}
+	if opt.Source == "prometheus" {
+		metrics, err := scrapePrometheus(opt, host)
+		if err != nil {
+			return sizes, err
+		}
+		names := []string{"rabbitmq_queue_messages_bytes", "rabbitmq_queue_messages_ready_bytes", "rabbitmq_queue_messages_unacked_bytes"}
+		for _, name := range names {
+			if _, ok := metrics[name]; ok == false {
+				return sizes, errors.New(name + " missing from the prometheus metrics of " + host)
+			}
+		}
+		sizes.Total = int64(metrics[names[0]])
+		sizes.Ready = int64(metrics[names[1]])
+		sizes.Unack = int64(metrics[names[2]])
+		return sizes, nil
+	}
+
+	query := url.Values{}
+	query.Set("columns", "message_bytes,message_bytes_ready,message_bytes_unacknowledged")
+	_, err := apiPages(opt, host, "/api/queues", query, func(decoder *json.Decoder) error {
+		queue := Queue{}
+		err := decodeItem(decoder, "/api/queues", query.Get("columns"), &queue)
+		if err != nil {
+			return err
+		}
+		sizes.Total = sizes.Total + queue.MessageBytes
+		sizes.Ready = sizes.Ready + queue.MessageBytesReady
+		sizes.Unack = sizes.Unack + queue.MessageBytesUnack
+		return nil
+	})
+	return sizes, err
+}
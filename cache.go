@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+cacheEntry is a parsed response together with the hash of the payload it was parsed from
+*/
+type cacheEntry struct {
+	hash    [sha256.Size]byte
+	value   interface{}
+	expires time.Time
+}
+
+// responseCache holds the parsed responses keyed by host and path
+var responseCache = map[string]cacheEntry{}
+
+var cacheMutex sync.Mutex
+
+/*
+cachedDecode decodes the json payload into result, reusing the value parsed earlier for the same key when the
+payload hashes the same and the entry has not expired. Slow changing endpoints such as definitions then skip
+the parse on every cycle of a long running process. The cache keeps a copy of its own and hands out copies, so
+callers are free to sort or change what they get.
+*/
+func cachedDecode(key string, payload []byte, result interface{}, ttl time.Duration) error {
+	hash := sha256.Sum256(payload)
+	target := reflect.ValueOf(result)
+	now := time.Now()
+
+	cacheMutex.Lock()
+	entry, ok := responseCache[key]
+	cacheMutex.Unlock()
+	if ok && entry.hash == hash && now.Before(entry.expires) && reflect.TypeOf(entry.value) == target.Type() {
+		target.Elem().Set(deepCopy(reflect.ValueOf(entry.value)).Elem())
+		return nil
+	}
+
+	if len(payload) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(payload, result)
+	if err != nil {
+		return err
+	}
+
+	cacheMutex.Lock()
+	for stale, cached := range responseCache {
+		if now.Before(cached.expires) == false {
+			delete(responseCache, stale)
+		}
+	}
+	responseCache[key] = cacheEntry{hash: hash, value: deepCopy(target).Interface(), expires: now.Add(ttl)}
+	cacheMutex.Unlock()
+	return nil
+}
+
+/*
+deepCopy returns a copy of the value sharing no pointer, slice or map with it. Unexported struct fields, which
+decoding leaves alone, are copied as they are.
+*/
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Elem().Type())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for index := 0; index < value.Len(); index++ {
+			copied.Index(index).Set(deepCopy(value.Index(index)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			copied.SetMapIndex(key, deepCopy(value.MapIndex(key)))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for index := 0; index < value.NumField(); index++ {
+			if copied.Field(index).CanSet() {
+				copied.Field(index).Set(deepCopy(value.Field(index)))
+			}
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+/*
+canaryOptions are the options of canary mode
+*/
+type canaryOptions struct {
+	CanaryQueue   string `long:"canary-queue" description:"The durable queue fed by a known producer that is drained in canary mode, the newest message is kept in the --state-dir between runs."`
+	CanaryAge     string `long:"canary-age" default:"300,900" description:"Warning and critical thresholds in seconds for the age of the newest canary message."`
+	CanaryLatency string `long:"canary-latency" default:"1000,5000" description:"Warning and critical thresholds in milliseconds for consuming from the canary queue."`
+}
+
+// canaryBatch is the number of messages fetched per get request while draining the canary queue
+const canaryBatch = 100
+
+// canaryMaxBatches bounds the drain so a runaway producer can not keep the check busy forever
+const canaryMaxBatches = 50
+
+/*
+getRequest is the body of a POST to /api/queues/{vhost}/{name}/get
+*/
+type getRequest struct {
+	Count    int    `json:"count"`
+	Ackmode  string `json:"ackmode"`
+	Encoding string `json:"encoding"`
+	Truncate int    `json:"truncate"`
+}
+
+/*
+getMessage is a single message returned by the get endpoint
+*/
+type getMessage struct {
+	MessageCount int             `json:"message_count"`
+	Properties   json.RawMessage `json:"properties"`
+	Payload      string          `json:"payload"`
+}
+
+/*
+messageProperties are the amqp properties of a message, only the ones used for timing
+*/
+type messageProperties struct {
+	Timestamp int64                  `json:"timestamp"`
+	Headers   map[string]interface{} `json:"headers"`
+}
+
+/*
+publishedAt returns the time the producer stamped on the message, either the timestamp property
+or the timestamp_in_ms header added by the message timestamp plugin
+*/
+func (message getMessage) publishedAt() (time.Time, bool) {
+	// older brokers send an empty list instead of an object when there are no properties
+	if len(message.Properties) == 0 || message.Properties[0] != '{' {
+		return time.Time{}, false
+	}
+
+	properties := messageProperties{}
+	err := json.Unmarshal(message.Properties, &properties)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if millis, ok := properties.Headers["timestamp_in_ms"].(float64); ok {
+		return time.Unix(0, int64(millis)*int64(time.Millisecond)), true
+	}
+	if properties.Timestamp > 0 {
+		return time.Unix(properties.Timestamp, 0), true
+	}
+	return time.Time{}, false
+}
+
+/*
+canaryState is the state of canary mode kept between runs
+*/
+type canaryState struct {
+	Newest time.Time `json:"newest"`
+}
+
+/*
+runCanary checks the canary queue on every host
+*/
+func runCanary(opt *options, hosts []string) {
+	if opt.CanaryQueue == "" {
+		printUnknown("The canary mode requires --canary-queue")
+		return
+	}
+
+	ageLimits, err := limitMap(opt.CanaryAge)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	latencyLimits, err := limitMap(opt.CanaryLatency)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough. Draining the queue from every
+	// host would leave the later ones an empty queue.
+	for _, value := range hosts {
+		err := processCanary(opt, value, ageLimits, latencyLimits)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not consume from the canary queue " + opt.CanaryQueue + " on any host")
+}
+
+/*
+processCanary drains the canary queue, measuring the consume latency and the age of the newest message. The
+newest message is kept between runs, so a producer publishing less often than the check runs is not taken for
+stalled when a run finds the queue empty, its age keeps growing against --canary-age instead. An error is
+returned when the first batch cannot be consumed, the messages of later batches are already taken.
+*/
+func processCanary(opt *options, host string, ageLimits, latencyLimits []int) error {
+	path := queuePath(opt, opt.CanaryQueue) + "/get"
+	request := getRequest{Count: canaryBatch, Ackmode: "ack_requeue_false", Encoding: "auto", Truncate: 1024}
+
+	var latency time.Duration
+	var newest time.Time
+	consumed, left := 0, 0
+	for i := 0; i < canaryMaxBatches; i++ {
+		start := time.Now()
+		messages := []getMessage{}
+		err := apiRequest(opt, host, "POST", path, request, &messages)
+		if err != nil && i == 0 {
+			return err
+		}
+		if err != nil {
+			log.Println(err.Error())
+			break
+		}
+		if i == 0 {
+			latency = time.Since(start)
+		}
+
+		consumed = consumed + len(messages)
+		for _, message := range messages {
+			published, ok := message.publishedAt()
+			if ok && published.After(newest) {
+				newest = published
+			}
+		}
+		left = 0
+		if len(messages) > 0 {
+			left = messages[len(messages)-1].MessageCount
+		}
+		if left == 0 {
+			break
+		}
+	}
+
+	millis := int(latency / time.Millisecond)
+	if millis >= latencyLimits[1] {
+		printLine("CRITICAL consume latency " + strconv.Itoa(millis) + "ms on " + host)
+	} else if millis >= latencyLimits[0] {
+		printLine("WARNING consume latency " + strconv.Itoa(millis) + "ms on " + host)
+	} else {
+		printLine("OK consume latency " + strconv.Itoa(millis) + "ms on " + host)
+	}
+
+	if consumed > 0 && newest.IsZero() {
+		printLine("CRITICAL " + strconv.Itoa(consumed) + " canary messages on " + host + " carry no timestamp")
+		return nil
+	}
+
+	// the newest message of an earlier run still counts when the producer did not publish since
+	state := canaryState{}
+	err := loadState(opt, "canary", &state)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	if newest.After(state.Newest) {
+		state.Newest = newest
+		err = saveState(opt, "canary", state)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	// the drain stopped at canaryMaxBatches, the newest messages are still queued behind the consumed ones
+	if left > 0 {
+		printLine("OK canary queue " + opt.CanaryQueue + " on " + host + " still holds " + strconv.Itoa(left) +
+			" messages after " + strconv.Itoa(consumed) + " consumed, the newest are read by the next runs")
+		return nil
+	}
+	if state.Newest.IsZero() {
+		printLine("CRITICAL canary queue " + opt.CanaryQueue + " on " + host + " is empty, the producer pipeline has stalled")
+		return nil
+	}
+
+	age := int(time.Since(state.Newest) / time.Second)
+	message := "newest canary message on " + host + " is " + strconv.Itoa(age) + "s old (" + strconv.Itoa(consumed) + " consumed)"
+	if age >= ageLimits[1] {
+		printLine("CRITICAL " + message)
+	} else if age >= ageLimits[0] {
+		printLine("WARNING " + message)
+	} else {
+		printLine("OK " + message)
+	}
+	return nil
+}
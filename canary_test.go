@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanaryKeepsTheNewestMessage(t *testing.T) {
+	get := "/api/queues/%2F/canary/get"
+	published := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	responses := map[string]string{get: `[{"message_count": 0, "properties": {"timestamp": ` + published + `}}]`}
+	port := testHosts(t, map[string]map[string]string{"127.0.0.1": responses})
+	arguments := []string{"canary", "-h", "127.0.0.1", "--port", port, "--vhost", "/", "--canary-queue", "canary", "--state-dir", t.TempDir()}
+
+	lines := runCheck(t, arguments...)
+	if len(lines) != 2 || strings.HasPrefix(lines[1], "OK newest canary message on 127.0.0.1") == false {
+		t.Errorf("the first run reads %q", lines)
+	}
+
+	// the producer did not publish since, the queue is empty but the pipeline is not stalled yet
+	responses[get] = `[]`
+	lines = runCheck(t, arguments...)
+	if len(lines) != 2 || strings.HasPrefix(lines[1], "OK newest canary message") == false || strings.HasSuffix(lines[1], "(0 consumed)") == false {
+		t.Errorf("the run after reads %q", lines)
+	}
+
+	lines = runCheck(t, append(arguments, "--canary-age", "10,30")...)
+	if len(lines) != 2 || strings.HasPrefix(lines[1], "CRITICAL newest canary message") == false {
+		t.Errorf("a producer silent past --canary-age reads %q", lines)
+	}
+
+	lines = runCheck(t, append(arguments, "--state-dir", t.TempDir())...)
+	if len(lines) != 2 || strings.HasSuffix(lines[1], "is empty, the producer pipeline has stalled") == false {
+		t.Errorf("an empty queue never seen fed reads %q", lines)
+	}
+}
+
+func TestCanaryBacklog(t *testing.T) {
+	get := "/api/queues/%2F/canary/get"
+	published := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	port := testHosts(t, map[string]map[string]string{"127.0.0.1": {
+		get: `[{"message_count": 7, "properties": {"timestamp": ` + published + `}}]`,
+	}})
+
+	lines := runCheck(t, "canary", "-h", "127.0.0.1", "--port", port, "--vhost", "/", "--canary-queue", "canary", "--state-dir", t.TempDir())
+	if len(lines) != 2 || strings.HasPrefix(lines[1], "OK canary queue canary on 127.0.0.1 still holds 7 messages after 50 consumed") == false {
+		t.Errorf("a backlog deeper than the drain reads %q", lines)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+/*
+capacityOptions are the options of node-capacity mode
+*/
+type capacityOptions struct {
+	NodeLeaders     string `long:"node-leaders" description:"Warning and critical thresholds for the queue leaders hosted by a single node in node-capacity mode, e.g. 2000,4000."`
+	NodeReplicas    string `long:"node-replicas" description:"Warning and critical thresholds for the queue replicas, leaders included, hosted by a single node in node-capacity mode."`
+	NodeConnections string `long:"node-connections" description:"Warning and critical thresholds for the connections to a single node in node-capacity mode."`
+}
+
+/*
+nodeLoad is what a single node hosts
+*/
+type nodeLoad struct {
+	leaders     int
+	replicas    int
+	connections int
+}
+
+/*
+optionalLimits parses a warning,critical pair like limitMap, an empty value disables the limits and returns nil
+*/
+func optionalLimits(str string) ([]int, error) {
+	if str == "" {
+		return nil, nil
+	}
+	return limitMap(str)
+}
+
+/*
+replicaNodes returns the nodes holding a copy of the queue, the leader included
+*/
+func replicaNodes(queue Queue) []string {
+	if len(queue.Members) > 0 {
+		return queue.Members
+	}
+	return append([]string{queue.Node}, queue.SlaveNodes...)
+}
+
+/*
+runNodeCapacity counts the queue leaders, queue replicas and connections every node hosts and thresholds
+them per node, which catches the placement skew left behind by node replacements that cluster totals hide
+*/
+func runNodeCapacity(opt *options, hosts []string) {
+	limits := [][]int{}
+	for _, value := range []string{opt.NodeLeaders, opt.NodeReplicas, opt.NodeConnections} {
+		parsed, err := optionalLimits(value)
+		if err != nil {
+			printUnknown(err.Error())
+			return
+		}
+		limits = append(limits, parsed)
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		loads, err := nodeLoads(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processNodeCapacity(loads, limits)
+		return
+	}
+	printLine("UNKNOWN could not read the node placement from any host")
+}
+
+/*
+nodeLoads counts what every node hosts over all vhosts
+*/
+func nodeLoads(opt *options, host string) (map[string]*nodeLoad, error) {
+	loads := map[string]*nodeLoad{}
+	// objects without a node, such as queues whose leader is down, are not counted anywhere
+	load := func(node string) *nodeLoad {
+		if node == "" {
+			return &nodeLoad{}
+		}
+		if loads[node] == nil {
+			loads[node] = &nodeLoad{}
+		}
+		return loads[node]
+	}
+
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		load(node.Name)
+	}
+
+	all := vhostScope(opt, "")
+	_, err = listQueues(all, host, "", "name,vhost,node,members,slave_nodes", func(queue Queue) error {
+		load(queue.Node).leaders++
+		for _, node := range replicaNodes(queue) {
+			load(node).replicas++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = listConnections(all, host, func(connection Connection) error {
+		load(connection.Node).connections++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loads, nil
+}
+
+/*
+processNodeCapacity prints the breaches followed by a line per node within its limits
+*/
+func processNodeCapacity(loads map[string]*nodeLoad, limits [][]int) {
+	names := []string{}
+	for name := range loads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	breaches := []string{}
+	for _, name := range names {
+		load := loads[name]
+		counts := []int{load.leaders, load.replicas, load.connections}
+		kinds := []string{"queue leaders", "queue replicas", "connections"}
+		gauges := []string{"queue_leaders", "queue_replicas", "connections"}
+		nodeBreaches := []string{}
+		for index, count := range counts {
+			recordGauge("rabbitmq.node."+gauges[index], float64(count), "node", name)
+			recordPerf(name+"_"+gauges[index], float64(count), "", perfLimit(limits[index], 0), perfLimit(limits[index], 1))
+			if limits[index] == nil {
+				continue
+			}
+			message := "node " + name + " hosts " + strconv.Itoa(count) + " " + kinds[index]
+			if count >= limits[index][1] {
+				nodeBreaches = append(nodeBreaches, "CRITICAL "+message)
+			} else if count >= limits[index][0] {
+				nodeBreaches = append(nodeBreaches, "WARNING "+message)
+			}
+		}
+		nodeBreaches, _, _ = downgradeLines(name, nodeBreaches)
+		breaches = append(breaches, nodeBreaches...)
+		if len(nodeBreaches) == 0 {
+			lines = append(lines, "OK node "+name+" hosts "+strconv.Itoa(load.leaders)+" queue leaders, "+
+				strconv.Itoa(load.replicas)+" queue replicas and "+strconv.Itoa(load.connections)+" connections")
+		}
+	}
+
+	for _, line := range breaches {
+		printLine(line)
+	}
+	for _, line := range lines {
+		printLine(line)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+/*
+channelOptions are the options of channels mode
+*/
+type channelOptions struct {
+	ChannelWarning  string `long:"channel-warning" description:"Warning ranges per channel in channels mode as counter=range pairs over unacked, uncommitted and prefetch, e.g. unacked=1000,prefetch=1:1000."`
+	ChannelCritical string `long:"channel-critical" description:"Critical ranges per channel in channels mode."`
+}
+
+// channelCounters are the per channel values thresholded in channels mode
+var channelCounters = []string{"unacked", "uncommitted", "prefetch"}
+
+/*
+Channel representation from /api/channels
+*/
+type Channel struct {
+	Name                   string `json:"name"`
+	Vhost                  string `json:"vhost"`
+	User                   string `json:"user"`
+	MessagesUnacknowledged int    `json:"messages_unacknowledged"`
+	MessagesUncommitted    int    `json:"messages_uncommitted"`
+	PrefetchCount          int    `json:"prefetch_count"`
+	GlobalPrefetchCount    int    `json:"global_prefetch_count"`
+	ConsumerCount          int    `json:"consumer_count"`
+	Transactional          bool   `json:"transactional"`
+	Confirm                bool   `json:"confirm"`
+}
+
+/*
+counters returns the values keyed like channelCounters
+*/
+func (channel Channel) counters() map[string]int {
+	return map[string]int{
+		"unacked":     channel.MessagesUnacknowledged,
+		"uncommitted": channel.MessagesUncommitted,
+		"prefetch":    channel.PrefetchCount,
+	}
+}
+
+/*
+listChannels pages through the channels of the configured vhost (all vhosts when it is empty) and hands every
+channel to each
+*/
+func listChannels(opt *options, host string, each func(channel Channel) error) (pageInfo, error) {
+	path := "/api/channels"
+	if opt.Vhost != "" {
+		path = "/api/vhosts/" + url.PathEscape(opt.Vhost) + "/channels"
+	}
+
+	query := url.Values{}
+	query.Set("columns", "name,vhost,user,messages_unacknowledged,messages_uncommitted,prefetch_count,global_prefetch_count,consumer_count,transactional,confirm")
+	return apiPages(opt, host, path, query, func(decoder *json.Decoder) error {
+		channel := Channel{}
+		err := decodeItem(decoder, path, query.Get("columns"), &channel)
+		if err != nil {
+			return err
+		}
+		return each(channel)
+	})
+}
+
+/*
+runChannels thresholds the unacknowledged and uncommitted messages and the prefetch count of every channel,
+finding the single channel hoarding messages while the queue level numbers look tolerable
+*/
+func runChannels(opt *options, hosts []string) {
+	warning, err := parseRanges(opt.ChannelWarning, channelCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := parseRanges(opt.ChannelCritical, channelCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err = processChannels(opt, value, warning, critical)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the channels from any host")
+}
+
+/*
+processChannels prints a summary line followed by the breaching channels of every vhost
+*/
+func processChannels(opt *options, host string, warning, critical map[string]nagiosRange) error {
+	checked, warnings, criticals := 0, 0, 0
+	details := []queueBreach{}
+	_, err := listChannels(vhostScope(opt, ""), host, func(channel Channel) error {
+		checked++
+		counters := channel.counters()
+		breaches := []string{}
+		for _, name := range channelCounters {
+			state := rangeState(float64(counters[name]), warning, critical, name)
+			if state != "OK" {
+				breaches = append(breaches, state+" channel "+channel.Name+" of "+channel.User+" has "+name+" "+strconv.Itoa(counters[name]))
+			}
+		}
+
+		breaches, channelWarnings, channelCriticals := downgradeLines(channel.Vhost+"/"+channel.Name, breaches)
+		if channelCriticals > 0 {
+			criticals++
+		} else if channelWarnings > 0 {
+			warnings++
+		}
+		for _, line := range breaches {
+			details = append(details, queueBreach{vhost: channel.Vhost, name: channel.Name, line: line})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// pages arrive in any order, sort so successive runs print the same output
+	sort.SliceStable(details, func(i, j int) bool {
+		if details[i].vhost != details[j].vhost {
+			return details[i].vhost < details[j].vhost
+		}
+		return details[i].name < details[j].name
+	})
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("channels", checked, warnings, criticals))
+	for _, detail := range details {
+		printLine(detail.line)
+	}
+	return nil
+}
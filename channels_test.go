@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChannelsOfEveryVhost(t *testing.T) {
+	port := testHosts(t, map[string]map[string]string{"127.0.0.1": {
+		"/api/channels": `{"items": [
+			{"name": "a", "vhost": "/", "user": "guest", "messages_unacknowledged": 10},
+			{"name": "b", "vhost": "orders", "user": "guest", "messages_unacknowledged": 5000}
+		], "page_count": 1}`,
+	}})
+
+	lines := runCheck(t, "channels", "-h", "127.0.0.1", "--port", port, "--channel-critical", "unacked=1000")
+	if len(lines) != 2 || strings.HasPrefix(lines[0], "CRITICAL") == false || strings.Contains(lines[1], "channel b of guest") == false {
+		t.Errorf("the channels of vhost orders were not checked: %q", lines)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cleanups holds the pending removals of entities created by the probes, keyed by a description of the entity
+var cleanups = map[string]func(){}
+
+var cleanupMutex sync.Mutex
+
+// interrupted is set once SIGINT or SIGTERM stopped a probe, the resident modes stop after the round
+var interrupted bool
+
+/*
+registerCleanup remembers how to remove an entity so it is removed even when the check is interrupted
+*/
+func registerCleanup(key string, cleanup func()) {
+	cleanupMutex.Lock()
+	defer cleanupMutex.Unlock()
+	cleanups[key] = cleanup
+}
+
+/*
+runCleanup removes a single registered entity, it is a no-op if the entity was already removed
+*/
+func runCleanup(key string) {
+	cleanupMutex.Lock()
+	cleanup, ok := cleanups[key]
+	delete(cleanups, key)
+	cleanupMutex.Unlock()
+
+	if ok {
+		cleanup()
+	}
+}
+
+/*
+runCleanups removes all the entities still registered
+*/
+func runCleanups() {
+	cleanupMutex.Lock()
+	keys := []string{}
+	for key := range cleanups {
+		keys = append(keys, key)
+	}
+	cleanupMutex.Unlock()
+
+	for _, key := range keys {
+		runCleanup(key)
+	}
+}
+
+/*
+guardCleanups removes the registered entities when the process receives SIGINT/SIGTERM or when the deadline
+passes, so a probe killed by nagios or stuck on a hung broker does not leave queues behind; the requests of the
+probe then fail and it returns. The returned function stops the guard once the probe returned, which matters
+when the check runs again in watch mode, and tells why the guard fired, "" when it did not. Reporting it is left
+to the calling path, nothing is printed aside while the probe may still be printing.
+*/
+func guardCleanups(deadline time.Duration) func() string {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	fired := make(chan string, 1)
+
+	go func() {
+		reason := ""
+		select {
+		case sig := <-signals:
+			reason = "probe interrupted by " + sig.String()
+			interrupted = true
+		case <-time.After(deadline):
+			reason = "probe timed out after " + deadline.String()
+		case <-done:
+		}
+		if reason != "" {
+			runCleanups()
+		}
+		fired <- reason
+	}()
+
+	return func() string {
+		signal.Stop(signals)
+		close(done)
+		return <-fired
+	}
+}
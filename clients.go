@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+clientOptions are the options of clients mode
+*/
+type clientOptions struct {
+	ClientMinVersions []string `long:"client-min-version" description:"The lowest version of a client library allowed to connect in clients mode as product:version, the product as the client announces it, e.g. pika:1.3.0. Repeat for every library."`
+}
+
+/*
+ClientProperties are the properties an amqp client announces when connecting
+*/
+type ClientProperties struct {
+	Product  string `json:"product"`
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+}
+
+/*
+clientFloor is the lowest version of a client library still allowed to connect, from --client-min-version
+*/
+type clientFloor struct {
+	product string
+	version string
+}
+
+/*
+parseClientFloors parses the --client-min-version values, each a product:version pair such as pika:1.3.0
+*/
+func parseClientFloors(values []string) ([]clientFloor, error) {
+	floors := []clientFloor{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("Expected product:version in --client-min-version " + value)
+		}
+		floors = append(floors, clientFloor{product: parts[0], version: parts[1]})
+	}
+	return floors, nil
+}
+
+/*
+compareVersions compares two dotted versions numerically, part by part, returning -1, 0 or 1. Anything after
+the digits of a part, such as -rc1, is ignored and missing parts count as 0.
+*/
+func compareVersions(a, b string) int {
+	left, right := strings.Split(a, "."), strings.Split(b, ".")
+	for index := 0; index < len(left) || index < len(right); index++ {
+		x, y := versionPart(left, index), versionPart(right, index)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+/*
+versionPart returns the leading number of a part of a version, 0 when there is none
+*/
+func versionPart(parts []string, index int) int {
+	if index >= len(parts) {
+		return 0
+	}
+	digits := strings.TrimLeft(parts[index], "vV")
+	end := 0
+	for end < len(digits) && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+	number, _ := strconv.Atoi(digits[:end])
+	return number
+}
+
+/*
+runClients takes the inventory of the client libraries connected to the cluster and warns about the ones below
+their --client-min-version, naming who still runs them, so a client upgrade campaign can be followed
+from the monitoring
+*/
+func runClients(opt *options, hosts []string) {
+	floors, err := parseClientFloors(opt.ClientMinVersions)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	all := vhostScope(opt, "")
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		connections := []Connection{}
+		_, err := listConnections(all, value, func(connection Connection) error {
+			connections = append(connections, connection)
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processClients(connections, floors)
+		return
+	}
+	printLine("UNKNOWN could not read the connections from any host")
+}
+
+/*
+processClients prints a summary line, a warning per outdated library version with its users and then the
+inventory, a line per product, version and platform
+*/
+func processClients(connections []Connection, floors []clientFloor) {
+	inventory := map[string]int{}
+	outdated := map[string]map[string]bool{}
+	outdatedConnections := 0
+	for _, connection := range connections {
+		properties := connection.ClientProperties
+		product := properties.Product
+		if product == "" {
+			product = "unknown"
+		}
+		key := strings.TrimSpace(product + " " + properties.Version)
+		if properties.Platform != "" {
+			key = key + " (" + properties.Platform + ")"
+		}
+		inventory[key]++
+
+		for _, floor := range floors {
+			if strings.EqualFold(floor.product, product) && compareVersions(properties.Version, floor.version) < 0 {
+				if outdated[key] == nil {
+					outdated[key] = map[string]bool{}
+				}
+				outdated[key][connection.User+"@"+connection.PeerHost] = true
+				outdatedConnections++
+				break
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range inventory {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	breaches := []string{}
+	for _, key := range keys {
+		if outdated[key] == nil {
+			continue
+		}
+		clients := []string{}
+		for client := range outdated[key] {
+			clients = append(clients, client)
+		}
+		sort.Strings(clients)
+		line := downgradeLine(key, "WARNING "+key+" is below the minimum version, still used by "+strings.Join(clients, ", "))
+		if line != "" {
+			breaches = append(breaches, line)
+		}
+	}
+
+	recordPerf("outdated_client_connections", float64(outdatedConnections), "", "0", "")
+	state := "OK"
+	if len(breaches) > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + strconv.Itoa(len(connections)) + " connections from " + strconv.Itoa(len(keys)) + " client library versions, " +
+		strconv.Itoa(outdatedConnections) + " below the minimum version")
+	for _, line := range breaches {
+		printLine(line)
+	}
+	for _, key := range keys {
+		recordGauge("rabbitmq.client_connections", float64(inventory[key]), "client", key)
+		printLine("OK " + key + ": " + strconv.Itoa(inventory[key]) + " connections")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+/*
+clusterLinkOptions are the options of cluster-links mode
+*/
+type clusterLinkOptions struct {
+	LinkSendPend string        `long:"link-send-pend" default:"1048576,8388608" description:"Warning and critical thresholds in bytes pending in the send buffer of a cluster link."`
+	LinkIdle     time.Duration `long:"link-idle" default:"1m" description:"How long a cluster link may go without receiving a byte before it is critical, followed across runs in --state-dir. The nodes tick each other every 15s with the default net_ticktime, so a silent link drops the traffic."`
+}
+
+/*
+linkCounters are the byte counters of a cluster link at the run they last moved, kept in the state
+*/
+type linkCounters struct {
+	Time int64 `json:"time"`
+	Send int   `json:"send"`
+	Recv int   `json:"recv"`
+}
+
+/*
+runClusterLinks checks the traffic and send buffers of the distribution links between the nodes, and the links
+closed or silent since the previous runs
+*/
+func runClusterLinks(opt *options, hosts []string) {
+	pendLimits, err := limitMap(opt.LinkSendPend)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processClusterLinks(nodes)
+		processLinkTraffic(nodes, pendLimits)
+
+		previous := map[string]linkCounters{}
+		err = loadState(opt, "cluster-links", &previous)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		err = saveState(opt, "cluster-links", processLinkDrops(nodes, previous, opt.LinkIdle))
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the cluster links from any host")
+}
+
+/*
+processLinkTraffic thresholds the bytes waiting in the send buffer of every link, which grow when the
+network between two members can not keep up, and reports the traffic rates of the link
+*/
+func processLinkTraffic(nodes []Node, pendLimits []int) {
+	for _, node := range nodes {
+		for _, link := range node.ClusterLinks {
+			stats := link.Stats
+			message := "link " + node.Name + " -> " + link.Name + " has " + strconv.Itoa(stats.SendPend) + " bytes pending" +
+				" (send " + strconv.FormatFloat(stats.SendBytesDetails.value(), 'f', 0, 64) + " B/s," +
+				" recv " + strconv.FormatFloat(stats.RecvBytesDetails.value(), 'f', 0, 64) + " B/s)"
+
+			line := "OK " + message
+			if stats.SendPend >= pendLimits[1] {
+				line = "CRITICAL " + message
+			} else if stats.SendPend >= pendLimits[0] {
+				line = "WARNING " + message
+			}
+			if line = downgradeLine(node.Name, line); line != "" {
+				printLine(line)
+			}
+		}
+	}
+}
+
+/*
+processLinkDrops compares the byte counters of every link between running nodes with the previous runs: a link
+receiving nothing for longer than idle drops the traffic of its peer, and counters going back show the link was
+closed and opened again in between. Returns the counters for the next run.
+*/
+func processLinkDrops(nodes []Node, previous map[string]linkCounters, idle time.Duration) map[string]linkCounters {
+	now := time.Now()
+	running := map[string]bool{}
+	for _, node := range nodes {
+		running[node.Name] = node.Running
+	}
+
+	current := map[string]linkCounters{}
+	for _, node := range nodes {
+		if node.Running == false {
+			continue
+		}
+		for _, link := range node.ClusterLinks {
+			if running[link.Name] == false {
+				continue
+			}
+			key := node.Name + " -> " + link.Name
+			counters := linkCounters{Time: now.Unix(), Send: link.Stats.SendBytes, Recv: link.Stats.RecvBytes}
+			last, ok := previous[key]
+			line := ""
+			switch {
+			case ok == false:
+			case counters.Send < last.Send || counters.Recv < last.Recv:
+				line = "WARNING link " + key + " was closed and opened again since the previous run"
+			case counters.Recv == last.Recv:
+				// the counters are kept from the run they last moved, so the silence adds up across runs
+				counters = last
+				silent := now.Sub(time.Unix(last.Time, 0))
+				if silent >= idle {
+					line = "CRITICAL link " + key + " received nothing for " + silent.Round(time.Second).String() + ", the traffic is dropped"
+				}
+			}
+			current[key] = counters
+			if line = downgradeLine(node.Name, line); line != "" {
+				printLine(line)
+			}
+		}
+	}
+	return current
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+)
+
+// clusterSection prefixes the config sections holding the options of a cluster, as in [cluster:prod]
+const clusterSection = "cluster:"
+
+// currentCluster is the cluster being checked when the config lists several, prefixing the perfdata labels and
+// labelling the collected values
+var currentCluster string
+
+/*
+runClusters checks every cluster of the config file in turn, each with the options of its section. The output
+starts with a summary over the clusters and holds a section per cluster, headed by a line with its state, so
+nagios still reads the worst state first.
+*/
+func runClusters(opt *options, args []string) {
+	sections := [][]string{}
+	allHosts := []string{}
+	for _, name := range opt.clusters {
+		holdOutput()
+		var hosts []string
+		clusterOpt, clusterArgs, _, err := parseClusterOptions(os.Args[1:], name)
+		if err == nil {
+			hosts, clusterArgs, err = applyOptions(clusterOpt, clusterArgs)
+		}
+		if err != nil {
+			printUnknown(err.Error())
+		} else {
+			// the mode may be picked by the run rather than the command line, as the exporter does
+			clusterOpt.Mode = opt.Mode
+			currentCluster = name
+			runMode(clusterOpt, hosts, clusterArgs)
+			currentCluster = ""
+			allHosts = append(allHosts, hosts...)
+		}
+		sections = append(sections, releaseOutput())
+	}
+
+	// the options of the run, not of the last cluster, are in effect for the hooks and exports
+	applyOptions(opt, args)
+	reportHosts = allHosts
+
+	printSections("clusters", "cluster", opt.clusters, sections)
+}
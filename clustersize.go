@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+clusterSizeOptions are the options of cluster-size mode
+*/
+type clusterSizeOptions struct {
+	ExpectedNodes int `long:"expected-nodes" description:"The nodes the cluster is made of in cluster-size mode, warning with any of them down and critical once the running ones are no majority."`
+}
+
+/*
+runClusterSize compares the running nodes with --expected-nodes. A node removed from the cluster, e.g. by a
+reset after a failed upgrade, leaves /api/nodes altogether, so the nodes are counted against the expected size
+rather than among the listed ones only.
+*/
+func runClusterSize(opt *options, hosts []string) {
+	if opt.ExpectedNodes <= 0 {
+		printUnknown("The cluster-size mode requires a positive --expected-nodes")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processClusterSize(nodes, opt.ExpectedNodes)
+		return
+	}
+	printLine("UNKNOWN could not read the nodes from any host")
+}
+
+/*
+processClusterSize prints the running nodes against the expected ones followed by the names of the nodes down.
+Quorum queues and the pause_minority partition handling both stop once the running nodes are no majority.
+*/
+func processClusterSize(nodes []Node, expected int) {
+	running := 0
+	down := []string{}
+	for _, node := range nodes {
+		if node.Running {
+			running++
+		} else {
+			down = append(down, node.Name)
+		}
+	}
+	recordGauge("rabbitmq.cluster.running_nodes", float64(running))
+	recordPerf("running_nodes", float64(running), "", strconv.Itoa(expected)+":", strconv.Itoa(expected/2+1)+":")
+
+	message := strconv.Itoa(running) + " of " + strconv.Itoa(expected) + " nodes running"
+	if len(down) > 0 {
+		message = message + ", down: " + strings.Join(down, ", ")
+	}
+	if missing := expected - len(nodes); missing > 0 {
+		message = message + ", " + strconv.Itoa(missing) + " missing from the cluster"
+	}
+
+	switch {
+	case running*2 <= expected:
+		printLine("CRITICAL " + message + ", no majority left")
+	case running < expected || len(down) > 0:
+		printLine("WARNING " + message)
+	case running > expected:
+		printLine("WARNING " + message + ", more than expected")
+	default:
+		printLine("OK " + message)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	Listen    string        `short:"l" long:"listen" default:":15672" description:"The address the mock management api listens on."`
+	Username  string        `short:"u" long:"username" default:"guest" description:"The username accepted by the mock."`
+	Password  string        `short:"p" long:"password" default:"guest" description:"The password accepted by the mock."`
+	Responses string        `short:"r" long:"responses" description:"A json file mapping api paths to canned responses, replacing the built in ones for the paths it lists."`
+	AuthFail  bool          `long:"auth-fail" description:"Answer every request with 401 Unauthorized."`
+	Delay     time.Duration `long:"delay" description:"Wait this long before every response, e.g. to trigger the check timeout."`
+	Truncate  int           `long:"truncate" description:"Cut every response body after this many bytes, producing invalid json."`
+	Status    int           `long:"status" description:"Answer every request with this status code instead of the canned one."`
+}
+
+/*
+response is a canned answer for a path. A response with a sequence cycles through it, one entry per request,
+which scripts transitions such as a queue growing or a node going down between two runs.
+*/
+type response struct {
+	Status   int             `json:"status"`
+	Body     json.RawMessage `json:"body"`
+	Delay    string          `json:"delay"`
+	Truncate int             `json:"truncate"`
+	Sequence []response      `json:"sequence"`
+}
+
+/*
+mock serves the canned responses, counting requests per path to step through sequences
+*/
+type mock struct {
+	opt       *options
+	responses map[string]response
+	mutex     sync.Mutex
+	served    map[string]int
+}
+
+// defaults are the built in responses, enough for the overview, queue, node and upgrade-ready checks to run
+var defaults = map[string]string{
+	"/api/overview": `{"rabbitmq_version":"3.12.0","management_version":"3.12.0","cluster_name":"rabbit@mock",
+		"statistics_db_event_queue":12,"statistics_db_node":"rabbit@mock",
+		"queue_totals":{"messages":120,"messages_ready":100,"messages_unacknowledged":20},
+		"object_totals":{"connections":4,"channels":8,"exchanges":14,"queues":3,"consumers":5},
+		"message_stats":{"publish":1000,"publish_details":{"rate":10.0},"deliver_get":990,"deliver_get_details":{"rate":9.5},"ack":990,"ack_details":{"rate":9.5}}}`,
+	"/api/whoami": `{"name":"guest","tags":["administrator"]}`,
+	"/api/nodes": `[{"name":"rabbit@mock","running":true,"mem_used":104857600,"mem_limit":1677721600,"mem_alarm":false,
+		"disk_free":10737418240,"disk_free_limit":50000000,"disk_free_alarm":false,"fd_used":40,"fd_total":1048576,
+		"sockets_used":4,"sockets_total":943626,"proc_used":500,"proc_total":1048576,"run_queue":0,"partitions":[],"cluster_links":[],
+		"metrics_gc_queue_length":{"connection_closed":0,"channel_closed":2,"consumer_deleted":0,"exchange_deleted":0,"queue_deleted":0}}]`,
+	"/api/queues": `[{"name":"orders","vhost":"/","messages":100,"messages_ready":90,"messages_unacknowledged":10,"consumers":2,
+		"message_bytes":104857600,"message_bytes_ready":94371840,"message_bytes_unacknowledged":10485760,
+		"message_stats":{"publish":1000,"publish_details":{"rate":10.0},"deliver_get":990,"deliver_get_details":{"rate":10.05},
+			"ack":990,"ack_details":{"rate":10.05}},"arguments":{"x-monitoring-hot":true}},
+		{"name":"invoices","vhost":"/","messages":15,"messages_ready":10,"messages_unacknowledged":5,"consumers":1,
+		"message_bytes":15360,"message_bytes_ready":10240,"message_bytes_unacknowledged":5120},
+		{"name":"audit","vhost":"/","messages":5,"messages_ready":0,"messages_unacknowledged":5,"consumers":2,
+		"message_bytes":5120,"message_bytes_ready":0,"message_bytes_unacknowledged":5120}]`,
+	"/api/connections": `[{"name":"10.0.0.5:41234 -> 10.0.0.1:5672","user":"app","vhost":"/","protocol":"AMQP 0-9-1","state":"running","channels":2,"peer_host":"10.0.0.5",
+			"type":"network","timeout":60,"frame_max":131072,
+			"client_properties":{"product":"RabbitMQ","version":"5.16.0","platform":"Java"}},
+		{"name":"10.0.0.6:52011 -> 10.0.0.1:5672","user":"app","vhost":"/","protocol":"AMQP 0-9-1","state":"running","channels":6,"peer_host":"10.0.0.6",
+			"type":"network","timeout":0,"frame_max":131072,
+			"client_properties":{"product":"pika","version":"0.13.1","platform":"Python 3.8.10"}},
+		{"name":"10.0.1.20:50122 -> 10.0.0.1:1883","user":"device","vhost":"/","protocol":"MQTT 3.1.1","state":"running","channels":1,"peer_host":"10.0.1.20"}]`,
+	"/api/policies": `[{"name":"ha","vhost":"/","pattern":"^orders$","apply-to":"queues","priority":0,"definition":{"max-length":100000}}]`,
+	"/api/vhosts": `[{"name":"/","messages":120,"messages_ready":100,"messages_unacknowledged":20,
+		"message_stats":{"publish":1000,"publish_details":{"rate":10.0},"deliver_get":990,"deliver_get_details":{"rate":9.5}},
+		"cluster_state":{"rabbit@mock":"running"}},
+		{"name":"tenant-a","messages":0,"messages_ready":0,"messages_unacknowledged":0,"cluster_state":{"rabbit@mock":"running"}}]`,
+	"/api/exchanges": `[{"name":"","vhost":"/","type":"direct","durable":true},{"name":"amq.topic","vhost":"/","type":"topic","durable":true},
+		{"name":"orders","vhost":"/","type":"topic","durable":true},{"name":"events","vhost":"/","type":"fanout","durable":true}]`,
+	"/api/bindings": `[{"source":"orders","vhost":"/","destination":"orders","destination_type":"queue","routing_key":"order.#"},
+		{"source":"orders","vhost":"/","destination":"audit","destination_type":"queue","routing_key":"#"},
+		{"source":"events","vhost":"/","destination":"audit","destination_type":"queue","routing_key":""}]`,
+	"/api/consumers": `[{"consumer_tag":"billing-worker-1","prefetch_count":10,"channel_details":{"name":"10.0.0.5:41234 -> 10.0.0.1:5672 (1)","connection_name":"10.0.0.5:41234 -> 10.0.0.1:5672","user":"app"},"queue":{"name":"invoices","vhost":"/"}},
+		{"consumer_tag":"amq.ctag-x1","prefetch_count":0,"channel_details":{"name":"10.0.0.6:52011 -> 10.0.0.1:5672 (2)","connection_name":"10.0.0.6:52011 -> 10.0.0.1:5672","user":"app"},"queue":{"name":"orders","vhost":"/"}}]`,
+	"/api/channels": `[{"name":"10.0.0.5:41234 -> 10.0.0.1:5672 (1)","vhost":"/","user":"app","messages_unacknowledged":5,"messages_uncommitted":0,"prefetch_count":10,"global_prefetch_count":0,"consumer_count":1,"transactional":false,"confirm":true},
+		{"name":"10.0.0.6:52011 -> 10.0.0.1:5672 (2)","vhost":"/","user":"app","messages_unacknowledged":2500,"messages_uncommitted":0,"prefetch_count":0,"global_prefetch_count":0,"consumer_count":1,"transactional":true,"confirm":false}]`,
+	"/api/nodes/rabbit@mock/memory": `{"memory":{"connection_readers":1048576,"connection_writers":262144,"connection_channels":2097152,
+		"connection_other":4194304,"queue_procs":20971520,"quorum_queue_procs":0,"plugins":8388608,"other_proc":16777216,
+		"metrics":1048576,"mgmt_db":3145728,"other_ets":3145728,"binary":52428800,"msg_index":131072,"code":33554432,
+		"atom":1572864,"other_system":12582912,"allocated_unused":10485760,"reserved_unallocated":0,
+		"strategy":"rss","total":{"erlang":150000000,"rss":160000000,"allocated":160485760}}}`,
+	"/api/queues/%2F/orders": `{"name":"orders","vhost":"/","messages":100,"messages_ready":90,"messages_unacknowledged":10,"consumers":2,
+		"message_bytes":104857600,"message_bytes_ready":94371840,"message_bytes_unacknowledged":10485760,
+		"message_stats":{"publish":1000,"publish_details":{"rate":10.0},"deliver_get":990,"deliver_get_details":{"rate":10.05},
+			"ack":990,"ack_details":{"rate":10.05}},"arguments":{"x-monitoring-hot":true}}`,
+	"/api/queues/%2F/invoices": `{"name":"invoices","vhost":"/","messages":15,"messages_ready":10,"messages_unacknowledged":5,"consumers":1,
+		"message_bytes":15360,"message_bytes_ready":10240,"message_bytes_unacknowledged":5120}`,
+	"/api/queues/%2F/audit": `{"name":"audit","vhost":"/","messages":5,"messages_ready":0,"messages_unacknowledged":5,"consumers":2,
+		"message_bytes":5120,"message_bytes_ready":0,"message_bytes_unacknowledged":5120}`,
+	"/api/feature-flags": `[{"name":"quorum_queue","state":"enabled","stability":"required"},
+		{"name":"stream_queue","state":"enabled","stability":"stable"},
+		{"name":"classic_queue_type_delivery_support","state":"enabled","stability":"stable"}]`,
+	"/api/aliveness-test/": `{"status":"ok"}`,
+	"/api/health/checks/":  `{"status":"ok"}`,
+}
+
+/*
+lookup finds the response of a path, trying the exact path first and then the longest prefix ending in a slash
+*/
+func (m *mock) lookup(path string) (response, bool) {
+	if canned, ok := m.responses[path]; ok {
+		return canned, true
+	}
+
+	best := ""
+	for prefix := range m.responses {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		// listings scoped to a vhost fall back to the cluster wide listing
+		segments := strings.Split(path, "/")
+		if len(segments) == 5 && segments[2] == "vhosts" {
+			if canned, ok := m.responses["/api/"+segments[4]]; ok {
+				return canned, true
+			}
+		}
+		for prefix := range m.responses {
+			if strings.HasPrefix(path, prefix+"/") && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+	}
+	canned, ok := m.responses[best]
+	return canned, ok && best != ""
+}
+
+/*
+ServeHTTP answers a request with its canned response, applying the configured faults
+*/
+func (m *mock) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	log.Println(request.Method + " " + request.URL.String())
+
+	username, password, ok := request.BasicAuth()
+	if m.opt.AuthFail || ok == false || username != m.opt.Username || password != m.opt.Password {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="RabbitMQ Management"`)
+		writeBody(writer, http.StatusUnauthorized, []byte(`{"error":"not_authorized","reason":"Login failed"}`), 0)
+		return
+	}
+
+	canned, ok := m.lookup(request.URL.EscapedPath())
+	if ok == false {
+		writeBody(writer, http.StatusNotFound, []byte(`{"error":"Object Not Found","reason":"Not Found"}`), 0)
+		return
+	}
+
+	if len(canned.Sequence) > 0 {
+		m.mutex.Lock()
+		step := m.served[request.URL.Path]
+		m.served[request.URL.Path]++
+		m.mutex.Unlock()
+		canned = canned.Sequence[step%len(canned.Sequence)]
+	}
+
+	delay := m.opt.Delay
+	if canned.Delay != "" {
+		parsed, err := time.ParseDuration(canned.Delay)
+		if err == nil {
+			delay = parsed
+		}
+	}
+	time.Sleep(delay)
+
+	status := canned.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if m.opt.Status != 0 {
+		status = m.opt.Status
+	}
+	truncate := canned.Truncate
+	if m.opt.Truncate > 0 {
+		truncate = m.opt.Truncate
+	}
+
+	body := []byte(canned.Body)
+	if request.URL.Query().Get("page") != "" {
+		body = paginate(body, request)
+	}
+	writeBody(writer, status, body, truncate)
+}
+
+/*
+paginate wraps a canned array into the page object returned by the api when a page is requested,
+applying the name filter like the api does
+*/
+func paginate(body []byte, request *http.Request) []byte {
+	all := []json.RawMessage{}
+	if json.Unmarshal(body, &all) != nil {
+		return body
+	}
+
+	query := request.URL.Query()
+	items := all
+	if filter := query.Get("name"); filter != "" {
+		pattern, err := regexp.Compile(regexp.QuoteMeta(filter))
+		if query.Get("use_regex") == "true" {
+			pattern, err = regexp.Compile(filter)
+		}
+		if err != nil {
+			return body
+		}
+
+		items = []json.RawMessage{}
+		for _, item := range all {
+			named := struct {
+				Name string `json:"name"`
+			}{}
+			json.Unmarshal(item, &named)
+			if pattern.MatchString(named.Name) {
+				items = append(items, item)
+			}
+		}
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	size, _ := strconv.Atoi(query.Get("page_size"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = 100
+	}
+
+	start, end := (page-1)*size, page*size
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	paged, _ := json.Marshal(map[string]interface{}{
+		"items":          items[start:end],
+		"page":           page,
+		"page_size":      size,
+		"page_count":     (len(items) + size - 1) / size,
+		"item_count":     end - start,
+		"filtered_count": len(items),
+		"total_count":    len(all),
+	})
+	return paged
+}
+
+/*
+writeBody writes a json body, cut after truncate bytes when truncate is positive
+*/
+func writeBody(writer http.ResponseWriter, status int, body []byte, truncate int) {
+	if truncate > 0 && truncate < len(body) {
+		body = body[:truncate]
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(body)
+}
+
+/*
+loadResponses returns the built in responses overridden by the ones of the responses file
+*/
+func loadResponses(file string) (map[string]response, error) {
+	responses := map[string]response{}
+	for path, body := range defaults {
+		responses[path] = response{Body: json.RawMessage(body)}
+	}
+	if file == "" {
+		return responses, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	custom := map[string]response{}
+	err = json.Unmarshal(content, &custom)
+	if err != nil {
+		return nil, err
+	}
+	for path, canned := range custom {
+		responses[path] = canned
+	}
+
+	return responses, nil
+}
+
+func main() {
+	opt := &options{}
+	_, err := flags.Parse(opt)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	responses, err := loadResponses(opt.Responses)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	log.Println("Mock management api listening on " + opt.Listen)
+	err = http.ListenAndServe(opt.Listen, &mock{opt: opt, responses: responses, served: map[string]int{}})
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+/*
+checkCommand is a mode of the check registered as a command of the parser. Options holds the options only
+that mode reads, nil when it reads the shared ones alone, example a command line shown in the help of the mode.
+*/
+type checkCommand struct {
+	name        string
+	description string
+	options     interface{}
+	example     string
+}
+
+/*
+checkCommands returns the commands of every mode, in the order of the help. Adding a check means adding its
+command here, its case to runMode and, when it takes options of its own, a struct of them embedded in options.
+*/
+func checkCommands(opt *options) []checkCommand {
+	return []checkCommand{
+		{"overview", "Check the ready and unacknowledged messages of the cluster (default)", nil, "check_rabbitmq overview --host rabbit1,rabbit2 -w 10000,1000 -c 50000,5000"},
+		{"queues", "Check the messages of every queue matching --queue-pattern, --include-queues and --exclude-queues", nil, "check_rabbitmq queues --queue-pattern '^orders' -w 1000,100 -c 10000,1000"},
+		{"queue", "Check the messages and consumers of a single queue", &opt.queueOptions, "check_rabbitmq queue --queue orders --queue-warning messages=100000,consumers=2:"},
+		{"object-totals", "Check the totals of connections, channels, exchanges, queues and consumers", &opt.totalsOptions, "check_rabbitmq object-totals --totals-warning connections=5000,consumers=1:"},
+		{"protocols", "Check the connections per protocol", &opt.protocolOptions, "check_rabbitmq protocols --protocol-warning mqtt=5000 --protocol-critical mqtt=10000"},
+		{"connection-tuning", "Check the heartbeat and frame_max negotiated by the clients", &opt.tuningOptions, "check_rabbitmq connection-tuning --heartbeat-range 10:120"},
+		{"clients", "Check the versions of the client libraries connected", &opt.clientOptions, "check_rabbitmq clients --client-min-version pika:1.3.0"},
+		{"rates", "Check the message rates of the cluster and of exchanges", &opt.rateOptions, "check_rabbitmq rates --rates-warning publish=10: --rates-exchange events"},
+		{"vhost", "Check the messages and rates of every vhost", &opt.vhostOptions, "check_rabbitmq vhost --vhost-limits tenant-a:1000,1000:5000,5000"},
+		{"exchanges", "Check the exchanges of a definitions export exist", &opt.exchangeOptions, "check_rabbitmq exchanges --definitions /etc/rabbitmq/definitions.json"},
+		{"exchange-wiring", "Check the dead letter and alternate exchanges exist and are bound to a queue", &opt.wiringOptions, "check_rabbitmq exchange-wiring --wiring-queue 'glob:orders/*'"},
+		{"topic-bindings", "Check the bindings of every topic exchange", &opt.topicBindingOptions, "check_rabbitmq topic-bindings --topic-bindings 1000,10000"},
+		{"consumers", "Check the consumers of every application", &opt.consumerOptions, "check_rabbitmq consumers --consumer-floor billing:3:tag:^billing-worker"},
+		{"channels", "Check the unacknowledged, uncommitted and prefetch of every channel", &opt.channelOptions, "check_rabbitmq channels --channel-warning unacked=1000,prefetch=1:1000"},
+		{"transactions", "Check no channel of the high throughput vhosts uses transactions", &opt.transactionOptions, "check_rabbitmq transactions --tx-vhost payments"},
+		{"prefetch", "Check the consumers of the designated vhosts neither use a global nor an unlimited prefetch", &opt.prefetchOptions, "check_rabbitmq prefetch --prefetch-vhost orders"},
+		{"queue-types", "Check the vhosts meant for quorum queues only hold quorum queues", &opt.queueTypeOptions, "check_rabbitmq queue-types --quorum-vhost orders"},
+		{"ttl-audit", "Check the queues of the durable vhosts neither expire nor drop messages early", &opt.ttlOptions, "check_rabbitmq ttl-audit --durable-vhost '^payments' --message-ttl 86400,3600"},
+		{"drain-time", "Check how long the backlog of every queue takes to clear", &opt.drainOptions, "check_rabbitmq drain-time --drain-time 3600,21600 --drain-min-messages 1000"},
+		{"throughput", "Check the deliver rate of the hot queues against their baseline", &opt.throughputOptions, "check_rabbitmq throughput --hot-queue '^/orders$' --throughput-drop 50,80"},
+		{"node", "Check the memory, disk, file descriptors, sockets and erlang processes of every node", &opt.nodeOptions, "check_rabbitmq node --node-warning mem=80,disk=80,run_queue=10 --node-critical mem=90,disk=95,run_queue=50"},
+		{"federation", "Check every federation link is running", &opt.federationOptions, "check_rabbitmq federation --vhost '' --federation-upstream dc2"},
+		{"cluster-size", "Check the expected nodes are running, listing the ones down", &opt.clusterSizeOptions, "check_rabbitmq cluster-size --expected-nodes 3"},
+		{"partitions", "Check the cluster is not partitioned", nil, "check_rabbitmq partitions --host rabbit1,rabbit2,rabbit3"},
+		{"stats-db", "Check the management database keeps up with the stats events", &opt.statsDbOptions, "check_rabbitmq stats-db --stats-event-queue 500,5000 --stats-db-memory 512M,1G"},
+		{"alarms", "Check no node has a memory or disk alarm blocking the publishers, nor one going off too often", &opt.alarmsOptions, "check_rabbitmq alarms --host rabbit1,rabbit2,rabbit3 --alarm-flaps 2,4"},
+		{"mirror-sync", "Check the mirrored classic queues have the synchronised mirrors their policy asks for", nil, "check_rabbitmq mirror-sync --queue-pattern '^orders'"},
+		{"quorum", "Check every quorum queue has a leader and all its members online", &opt.quorumOptions, "check_rabbitmq quorum --quorum-open-files 50,100"},
+		{"store-growth", "Check the growth of the message stores against the time left until the disk alarm", &opt.storeOptions, "check_rabbitmq store-growth --store-window 2h --store-horizon 24,6"},
+		{"node-capacity", "Check the queue leaders, replicas and connections per node", &opt.capacityOptions, "check_rabbitmq node-capacity --node-leaders 2000,4000"},
+		{"node-memory", "Check the memory breakdown of every node", &opt.nodeMemoryOptions, "check_rabbitmq node-memory --memory-warning binary=2G,atom=64M --memory-growth 50,100"},
+		{"api-latency", "Check the time the management api takes to answer", &opt.latencyOptions, "check_rabbitmq api-latency --api-latency 500,2000"},
+		{"aliveness", "Check a message can be published and consumed in the vhost", nil, "check_rabbitmq aliveness --vhost orders"},
+		{"metadata-store", "Check the metadata store is initialized", nil, "check_rabbitmq metadata-store --host rabbit1,rabbit2,rabbit3"},
+		{"websocket", "Check the web-stomp or web-mqtt listener", &opt.websocketOptions, "check_rabbitmq websocket --ws-protocol mqtt --ws-port 15675"},
+		{"amqps", "Check the certificate and tls versions of the amqps listener", &opt.amqpsOptions, "check_rabbitmq amqps --amqps-port 5671 --cert-expiry 30,7"},
+		{"canary", "Check the age of the newest message of a canary queue", &opt.canaryOptions, "check_rabbitmq canary --canary-queue canary --canary-age 300,900"},
+		{"probe", "Check a message round trips through a temporary queue", &opt.probeOptions, "check_rabbitmq probe --probe-queue-type quorum --confirm-latency 250,1000 --probe-batch 20"},
+		{"bench", "Check the throughput and confirm latency of a short benchmark", &opt.benchOptions, "check_rabbitmq bench --messages 1000 --bench-rate 200,50"},
+		{"distribution", "Check the erlang distribution port answers", &opt.distributionOptions, "check_rabbitmq distribution --dist-port 25672"},
+		{"epmd", "Check epmd answers and has the node registered", &opt.epmdOptions, "check_rabbitmq epmd --epmd-node rabbit"},
+		{"cluster-links", "Check the pending bytes and the dropped traffic of the links between nodes", &opt.clusterLinkOptions, "check_rabbitmq cluster-links --link-send-pend 1048576,8388608 --link-idle 2m"},
+		{"health-all", "Run every health check of the api", nil, "check_rabbitmq health-all"},
+		{"upgrade-ready", "Check the cluster is ready for a rolling upgrade", &opt.upgradeOptions, "check_rabbitmq upgrade-ready --host rabbit1,rabbit2,rabbit3 --upgrade-disk-headroom 5G"},
+		{"doctor", "Diagnose the connection to the api", nil, "check_rabbitmq doctor --host rabbit1"},
+		{"list", "List queues, nodes, connections or policies", nil, "check_rabbitmq list queues"},
+	}
+}
+
+/*
+optionGroup is a group of the options shared by every check, shown under its heading in the help
+*/
+type optionGroup struct {
+	heading string
+	options interface{}
+}
+
+/*
+sharedGroups returns the groups of the shared options, in the order of the help
+*/
+func sharedGroups(opt *options) []optionGroup {
+	return []optionGroup{
+		{"Connection Options", &opt.connectionOptions},
+		{"Threshold Options", &opt.thresholdOptions},
+		{"Collection Options", &opt.collectionOptions},
+		{"Output Options", &opt.outputOptions},
+		{"Integration Options", &opt.integrationOptions},
+		{"Resident Options", &opt.residentOptions},
+	}
+}
+
+/*
+addCheckCommands registers the groups of the shared options and the command of every mode. A run without a
+command checks the overview, so the commands are optional.
+*/
+func addCheckCommands(parser *flags.Parser, opt *options) error {
+	parser.SubcommandsOptional = true
+	parser.Usage = "[OPTIONS]"
+	parser.LongDescription = "Checks a rabbitmq cluster through its management api for nagios. Run check_rabbitmq <command> --help " +
+		"for the options and an example of a check."
+	for _, group := range sharedGroups(opt) {
+		_, err := parser.AddGroup(group.heading, "", group.options)
+		if err != nil {
+			return err
+		}
+	}
+	for _, command := range checkCommands(opt) {
+		data := command.options
+		if data == nil {
+			data = &struct{}{}
+		}
+		_, err := parser.AddCommand(command.name, command.description, command.description+".\n\nExample:\n"+command.example, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+commandFirst moves the mode given with --mode or -m to the front of the arguments as its command. The options
+of a command are only accepted after it, so this keeps the command lines written before the commands working.
+*/
+func commandFirst(arguments []string, commands []checkCommand) []string {
+	known := map[string]bool{}
+	for _, command := range commands {
+		known[command.name] = true
+	}
+
+	for index := 0; index < len(arguments); index++ {
+		argument := arguments[index]
+		if argument == "--" {
+			break
+		}
+
+		mode, width := "", 1
+		if (argument == "--mode" || argument == "-m") && index+1 < len(arguments) {
+			mode, width = arguments[index+1], 2
+		} else if strings.HasPrefix(argument, "--mode=") {
+			mode = strings.TrimPrefix(argument, "--mode=")
+		} else if strings.HasPrefix(argument, "-m") && argument != "-m" && strings.HasPrefix(argument, "--") == false {
+			mode = strings.TrimPrefix(strings.TrimPrefix(argument, "-m"), "=")
+		}
+
+		// an unknown mode is left to be reported by runMode
+		if known[mode] == false {
+			continue
+		}
+		rest := append(append([]string{}, arguments[:index]...), arguments[index+width:]...)
+		return append([]string{mode}, rest...)
+	}
+	return arguments
+}
+
+/*
+commandSections moves the keys of a config file given outside any section into the section of the command
+reading them. go-flags only looks up the shared options for those keys, while the config files written before
+the commands list every option without a section, or under the application options section.
+*/
+func commandSections(parser *flags.Parser, ini string) string {
+	owners := map[string]string{}
+	for _, command := range parser.Commands() {
+		for _, option := range command.Options() {
+			owners[strings.ToLower(option.LongName)] = command.Name
+		}
+	}
+
+	global := []string{}
+	sections := map[string][]string{}
+	names := []string{}
+	inSection := false
+	for _, line := range strings.Split(ini, "\n") {
+		trimmed := strings.TrimSpace(line)
+		// the shared options moved out of the application options into their groups, keep the section reaching them
+		if strings.ToLower(trimmed) == "[application options]" {
+			inSection = false
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			inSection = true
+		}
+		owner := ""
+		if inSection == false {
+			owner = owners[strings.ToLower(strings.TrimSpace(strings.SplitN(trimmed, "=", 2)[0]))]
+		}
+		if owner == "" {
+			global = append(global, line)
+			continue
+		}
+		if _, ok := sections[owner]; ok == false {
+			names = append(names, owner)
+		}
+		sections[owner] = append(sections[owner], line)
+	}
+
+	for _, name := range names {
+		global = append(global, "["+name+"]")
+		global = append(global, sections[name]...)
+	}
+	return strings.Join(global, "\n") + "\n"
+}
+
+/*
+helpError returns the help of the command given, or of the whole check without one, as the error go-flags
+returns for --help. The help flag of go-flags is not used as its -h is taken by --host.
+*/
+func helpError(parser *flags.Parser) error {
+	var help bytes.Buffer
+	parser.WriteHelp(&help)
+	return &flags.Error{Type: flags.ErrHelp, Message: strings.TrimSuffix(help.String(), "\n")}
+}
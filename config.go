@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// profileSection prefixes the config sections holding the options of a profile picked with --profile
+const profileSection = "profile:"
+
+/*
+parseOptions parses the command line. With --config the file is read first, see readConfig, and the command
+line is applied over it so flags override the file. The mode is the command given, or a leading argument that
+is none so runMode reports it. The parser is returned so usage can be printed on errors.
+*/
+func parseOptions(arguments []string) (*options, []string, *flags.Parser, error) {
+	return parseClusterOptions(arguments, "")
+}
+
+/*
+parseClusterOptions parses the command line like parseOptions, with the section of the cluster of the config
+file applied over the rest of the file
+*/
+func parseClusterOptions(arguments []string, cluster string) (*options, []string, *flags.Parser, error) {
+	opt, parser, err := newParser()
+	if err != nil {
+		return opt, nil, parser, err
+	}
+	arguments = commandFirst(arguments, checkCommands(opt))
+	args, err := parser.ParseArgs(arguments)
+	if err == nil && opt.Help == true {
+		return opt, args, parser, helpError(parser)
+	}
+	if err == nil && opt.Profile != "" && opt.Config == "" {
+		err = errors.New("--profile requires a --config file holding the profile")
+	}
+	if err == nil && opt.Config != "" {
+		config, profile := opt.Config, opt.Profile
+		opt, parser, err = newParser()
+		if err != nil {
+			return opt, nil, parser, err
+		}
+		var clusters []string
+		clusters, err = readConfig(parser, config, profile, cluster)
+		if err != nil {
+			return opt, nil, parser, err
+		}
+		args, err = parser.ParseArgs(arguments)
+		opt.clusters, opt.cluster = clusters, cluster
+	}
+	if err != nil {
+		return opt, args, parser, err
+	}
+
+	if parser.Active != nil {
+		opt.Mode = parser.Active.Name
+	} else if len(args) > 0 {
+		opt.Mode, args = args[0], args[1:]
+	}
+	return opt, args, parser, nil
+}
+
+/*
+newParser returns a parser of the options with the command of every mode
+*/
+func newParser() (*options, *flags.Parser, error) {
+	opt := &options{}
+	parser := flags.NewParser(opt, flags.Default&^flags.PrintErrors&^flags.HelpFlag)
+	err := addCheckCommands(parser, opt)
+	return opt, parser, err
+}
+
+/*
+readConfig parses a config file into the parser and returns the names of the clusters it lists. Files ending
+in .yaml or .yml hold a flat yaml mapping of the long option names, a list giving an option repeated; any other
+file is in the ini format of go-flags, keyed by the long option names under the section of their group or
+command, or under no section at all. The section of the profile and then the one of the cluster, when given,
+are applied over the rest.
+*/
+func readConfig(parser *flags.Parser, path string, profile, cluster string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the point of a config file is keeping the password off the command line, not handing it to every user
+	info, err := os.Stat(path)
+	if err == nil && info.Mode().Perm()&0004 != 0 && strings.Contains(strings.ToLower(string(content)), "password") {
+		log.Println("The config file " + path + " holds a password and is readable by every user, restrict it with chmod o-r")
+	}
+
+	ini := string(content)
+	extension := strings.ToLower(filepath.Ext(path))
+	if extension == ".yaml" || extension == ".yml" {
+		ini, err = yamlToIni(ini)
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	}
+
+	base, names, clusters := splitSections(ini, clusterSection)
+	base, profileNames, profiles := splitSections(base, profileSection)
+	layers := []string{base}
+	if profile != "" {
+		section, ok := profiles[profile]
+		if ok == false {
+			return names, errors.New("Unknown profile " + profile + " in " + path + ", expected one of " + strings.Join(profileNames, ", "))
+		}
+		layers = append(layers, section)
+	}
+	if cluster != "" {
+		section, ok := clusters[cluster]
+		if ok == false {
+			return names, errors.New("Unknown cluster " + cluster + " in " + path)
+		}
+		layers = append(layers, section)
+	}
+
+	for _, layer := range layers {
+		err = flags.NewIniParser(parser).Parse(strings.NewReader(commandSections(parser, layer)))
+		if err != nil {
+			return names, err
+		}
+	}
+	return names, nil
+}
+
+/*
+splitSections cuts the sections named after the prefix out of an ini config, returning the rest of it, the
+names following the prefix in the order of the file and their sections
+*/
+func splitSections(ini, prefix string) (string, []string, map[string]string) {
+	base := []string{}
+	names := []string{}
+	sections := map[string]string{}
+	section := ""
+	for _, line := range strings.Split(ini, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = ""
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if strings.HasPrefix(name, prefix) {
+				section = strings.TrimPrefix(name, prefix)
+				if _, ok := sections[section]; ok == false {
+					names = append(names, section)
+					sections[section] = ""
+				}
+				continue
+			}
+		}
+		if section == "" {
+			base = append(base, line)
+		} else {
+			sections[section] = sections[section] + line + "\n"
+		}
+	}
+	return strings.Join(base, "\n"), names, sections
+}
+
+/*
+yamlToIni turns the flat yaml mapping of a config file into ini lines. Only what a list of options needs is
+understood: key: value pairs, lists as "- item" lines or [a, b] and full line comments, plus the profiles and
+clusters mappings of yamlSections.
+*/
+func yamlToIni(content string) (string, error) {
+	content, profiles, err := yamlSections(content, "profiles", profileSection)
+	if err != nil {
+		return "", err
+	}
+	content, clusters, err := yamlSections(content, "clusters", clusterSection)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+	key := ""
+	for index, line := range strings.Split(content, "\n") {
+		number := strconv.Itoa(index + 1)
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if key == "" {
+				return "", errors.New("line " + number + ": list item without an option")
+			}
+			lines = append(lines, key+" = "+strconv.Quote(yamlScalar(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+		if trimmed != strings.TrimLeft(line, " \t") || line != strings.TrimLeft(line, " \t") {
+			return "", errors.New("line " + number + ": nested mappings are not supported")
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 {
+			return "", errors.New("line " + number + ": expected option: value")
+		}
+		key = strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+				if strings.TrimSpace(item) != "" {
+					lines = append(lines, key+" = "+strconv.Quote(yamlScalar(item)))
+				}
+			}
+		} else if value != "" {
+			lines = append(lines, key+" = "+strconv.Quote(yamlScalar(value)))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n" + profiles + clusters, nil
+}
+
+/*
+yamlSections cuts the mapping under key out of a yaml config, a flat mapping of options per name, and returns
+the rest of the config along with an ini section per name, named after the prefix and the name
+*/
+func yamlSections(content, key, prefix string) (string, string, error) {
+	rest := []string{}
+	names := []string{}
+	bodies := map[string][]string{}
+	inMapping, nameIndent, name := false, -1, ""
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if trimmed != "" && strings.HasPrefix(trimmed, "#") == false && indent == 0 {
+			inMapping = trimmed == key+":"
+		}
+		if inMapping == false {
+			rest = append(rest, line)
+			continue
+		}
+
+		// blank lines keep the line numbers of the errors about the rest of the config
+		rest = append(rest, "")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || indent == 0 {
+			continue
+		}
+
+		// the names are the least indented keys of the mapping
+		if nameIndent < 0 || indent <= nameIndent {
+			nameIndent = indent
+			name = strings.TrimSuffix(trimmed, ":")
+			names = append(names, name)
+			continue
+		}
+		bodies[name] = append(bodies[name], line)
+	}
+
+	sections := ""
+	for _, name := range names {
+		body := bodies[name]
+		indent := ""
+		if len(body) > 0 {
+			indent = body[0][:len(body[0])-len(strings.TrimLeft(body[0], " \t"))]
+		}
+		for index := range body {
+			body[index] = strings.TrimPrefix(body[index], indent)
+		}
+		ini, err := yamlToIni(strings.Join(body, "\n"))
+		if err != nil {
+			return "", "", errors.New(key + " " + name + ": " + err.Error())
+		}
+		sections = sections + "[" + prefix + name + "]\n" + ini
+	}
+	return strings.Join(rest, "\n"), sections, nil
+}
+
+/*
+yamlScalar returns the value of a yaml scalar, removing its quotes
+*/
+func yamlScalar(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		unquoted, err := strconv.Unquote(value)
+		if err == nil {
+			return unquoted
+		}
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1)
+	}
+	return value
+}
+
+/*
+readPasswordFile replaces the password with the content of --password-file, when given, without the line break
+editors and secret stores end it with
+*/
+func readPasswordFile(opt *options) error {
+	if opt.PasswordFile == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(opt.PasswordFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(opt.PasswordFile)
+	if err == nil && info.Mode().Perm()&0004 != 0 {
+		log.Println("The password file " + opt.PasswordFile + " is readable by every user, restrict it with chmod o-r")
+	}
+	opt.Password = strings.TrimRight(string(content), "\r\n")
+	return nil
+}
+
+/*
+configWatcher tells a long running process when to reload its options: on SIGHUP or when the
+modification time of the config file changed
+*/
+type configWatcher struct {
+	path     string
+	modified time.Time
+	hangups  chan os.Signal
+}
+
+/*
+newConfigWatcher starts listening for SIGHUP and remembers the current state of the config file
+*/
+func newConfigWatcher(path string) *configWatcher {
+	watcher := &configWatcher{path: path, hangups: make(chan os.Signal, 1)}
+	signal.Notify(watcher.hangups, syscall.SIGHUP)
+	watcher.changed()
+	return watcher
+}
+
+/*
+changed returns true when a reload is due since the previous call
+*/
+func (watcher *configWatcher) changed() bool {
+	hangup := false
+	select {
+	case <-watcher.hangups:
+		hangup = true
+	default:
+	}
+
+	if watcher.path == "" {
+		return hangup
+	}
+	info, err := os.Stat(watcher.path)
+	if err != nil {
+		return hangup
+	}
+	modified := info.ModTime()
+	if modified.Equal(watcher.modified) {
+		return hangup
+	}
+	watcher.modified = modified
+	return true
+}
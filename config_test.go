@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYamlToIni(t *testing.T) {
+	content := "# the production cluster\n" +
+		"host: [rabbit1, rabbit2]\n" +
+		"warning: 10,20\n" +
+		"exclude-queues:\n" +
+		"  - '^amq\\.'\n" +
+		"profiles:\n" +
+		"  prod:\n" +
+		"    vhost: orders\n"
+	ini, err := yamlToIni(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "host = \"rabbit1\"\n" +
+		"host = \"rabbit2\"\n" +
+		"warning = \"10,20\"\n" +
+		"exclude-queues = \"^amq\\\\.\"\n" +
+		"[profile:prod]\n" +
+		"vhost = \"orders\"\n"
+	if ini != expected {
+		t.Errorf("yamlToIni = %q, expected %q", ini, expected)
+	}
+
+	invalid := map[string]string{
+		"- orphan\n":       "list item without an option",
+		"vhost:\n  a: b\n": "nested mappings are not supported",
+		"just words\n":     "expected option: value",
+	}
+	for content, message := range invalid {
+		_, err := yamlToIni(content)
+		if err == nil || strings.HasSuffix(err.Error(), message) == false {
+			t.Errorf("yamlToIni(%q) = %v, expected %q", content, err, message)
+		}
+	}
+}
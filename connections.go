@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+/*
+Connection representation from /api/connections
+*/
+type Connection struct {
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Vhost    string `json:"vhost"`
+	Protocol string `json:"protocol"`
+	State    string `json:"state"`
+	Channels int    `json:"channels"`
+	Node     string `json:"node"`
+	PeerHost string `json:"peer_host"`
+
+	// Type is network for clients and direct for connections inside the broker, such as shovels. Timeout is
+	// the negotiated heartbeat in seconds.
+	Type     string `json:"type"`
+	Timeout  int    `json:"timeout"`
+	FrameMax int    `json:"frame_max"`
+
+	ClientProperties ClientProperties `json:"client_properties"`
+}
+
+/*
+listConnections pages through the connections of the configured vhost (all vhosts when it is empty) and hands
+every connection to each
+*/
+func listConnections(opt *options, host string, each func(connection Connection) error) (pageInfo, error) {
+	path := "/api/connections"
+	if opt.Vhost != "" {
+		path = "/api/vhosts/" + url.PathEscape(opt.Vhost) + "/connections"
+	}
+
+	return apiPages(opt, host, path, url.Values{}, func(decoder *json.Decoder) error {
+		connection := Connection{}
+		err := decodeItem(decoder, path, "", &connection)
+		if err != nil {
+			return err
+		}
+		return each(connection)
+	})
+}
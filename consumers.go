@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+consumerOptions are the options of consumers mode
+*/
+type consumerOptions struct {
+	ConsumerFloor []string `long:"consumer-floor" description:"The minimum consumers of an application in consumers mode as application:minimum:field:pattern, field being tag or connection, e.g. billing:3:tag:^billing-worker. Repeat for every application."`
+}
+
+/*
+Consumer representation from /api/consumers
+*/
+type Consumer struct {
+	ConsumerTag    string `json:"consumer_tag"`
+	PrefetchCount  int    `json:"prefetch_count"`
+	ChannelDetails struct {
+		Name           string `json:"name"`
+		ConnectionName string `json:"connection_name"`
+		User           string `json:"user"`
+	} `json:"channel_details"`
+	Queue struct {
+		Name  string `json:"name"`
+		Vhost string `json:"vhost"`
+	} `json:"queue"`
+}
+
+/*
+consumerFloor is the minimum number of consumers an application keeps, the consumers being recognised by
+a pattern on their tag or on the name of their connection
+*/
+type consumerFloor struct {
+	application string
+	minimum     int
+	field       string
+	pattern     *regexp.Regexp
+}
+
+/*
+parseConsumerFloors parses the --consumer-floor entries, application:minimum:field:pattern with field being
+tag or connection. The pattern comes last so it may contain colons.
+*/
+func parseConsumerFloors(entries []string) ([]consumerFloor, error) {
+	floors := []consumerFloor{}
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, ":", 4)
+		if len(fields) != 4 {
+			return nil, errors.New("Expected application:minimum:field:pattern in " + entry)
+		}
+		minimum, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if fields[2] != "tag" && fields[2] != "connection" {
+			return nil, errors.New("Unknown field " + fields[2] + " in " + entry + ", expected tag or connection")
+		}
+		pattern, err := regexp.Compile(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		floors = append(floors, consumerFloor{application: fields[0], minimum: minimum, field: fields[2], pattern: pattern})
+	}
+	return floors, nil
+}
+
+/*
+matches tells whether the consumer belongs to the application of the floor
+*/
+func (floor consumerFloor) matches(consumer Consumer) bool {
+	if floor.field == "tag" {
+		return floor.pattern.MatchString(consumer.ConsumerTag)
+	}
+	return floor.pattern.MatchString(consumer.ChannelDetails.ConnectionName)
+}
+
+/*
+fetchConsumers returns the consumers of the configured vhost, of all vhosts when it is empty
+*/
+func fetchConsumers(opt *options, host string) ([]Consumer, error) {
+	path := "/api/consumers"
+	if opt.Vhost != "" {
+		path = path + "/" + url.PathEscape(opt.Vhost)
+	}
+
+	consumers := []Consumer{}
+	err := apiRequest(opt, host, "GET", path, nil, &consumers)
+	if err != nil {
+		return nil, err
+	}
+	return consumers, nil
+}
+
+/*
+runConsumers counts the consumers of every application given with --consumer-floor and alerts, naming the
+application, when its fleet shrank below its floor
+*/
+func runConsumers(opt *options, hosts []string) {
+	floors, err := parseConsumerFloors(opt.ConsumerFloor)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	if len(floors) == 0 {
+		printUnknown("The consumers mode requires at least one --consumer-floor")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		consumers, err := fetchConsumers(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processConsumers(consumers, floors)
+		return
+	}
+	printLine("UNKNOWN could not list the consumers from any host")
+}
+
+/*
+processConsumers prints a summary line followed by a line per application
+*/
+func processConsumers(consumers []Consumer, floors []consumerFloor) {
+	lines := []string{}
+	criticals := 0
+	for _, floor := range floors {
+		count := 0
+		for _, consumer := range consumers {
+			if floor.matches(consumer) {
+				count++
+			}
+		}
+		recordGauge("rabbitmq.consumers", float64(count), "application", floor.application)
+		recordPerf(floor.application+"_consumers", float64(count), "", "", strconv.Itoa(floor.minimum)+":")
+
+		message := floor.application + " has " + strconv.Itoa(count) + " consumers"
+		if count < floor.minimum {
+			lines = append(lines, "CRITICAL "+message+", below its floor of "+strconv.Itoa(floor.minimum))
+			criticals++
+		} else {
+			lines = append(lines, "OK "+message)
+		}
+	}
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	}
+	printLine(state + " " + summaryCounts("applications", len(floors), 0, criticals))
+	for _, line := range lines {
+		printLine(line)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+/*
+queuePage builds a page of the queue listing as returned by the api, with count queues
+*/
+func queuePage(count int) []byte {
+	items := []Queue{}
+	for i := 0; i < count; i++ {
+		items = append(items, Queue{
+			Name:          "queue-" + strconv.Itoa(i),
+			Vhost:         "/",
+			Messages:      i * 3,
+			MessagesReady: i * 2,
+			MessagesUnack: i,
+			Consumers:     i % 5,
+		})
+	}
+
+	page, _ := json.Marshal(map[string]interface{}{
+		"items":      items,
+		"page":       1,
+		"page_count": 1,
+		"page_size":  count,
+	})
+	return page
+}
+
+func BenchmarkDecodePage(b *testing.B) {
+	page := queuePage(10000)
+	b.SetBytes(int64(len(page)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		decoder := json.NewDecoder(bytes.NewReader(page))
+		_, err := decodePage(decoder, "/api/queues", func(decoder *json.Decoder) error {
+			queue := Queue{}
+			return decoder.Decode(&queue)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluateQueue(b *testing.B) {
+	warning, critical := []int{10000, 10000}, []int{50000, 50000}
+	queues := []Queue{}
+	for i := 0; i < 10000; i++ {
+		queues = append(queues, Queue{Name: "queue-" + strconv.Itoa(i), Vhost: "/", MessagesReady: i * 7, MessagesUnack: i * 3})
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, queue := range queues {
+			evaluateQueue(queue, warning, critical)
+		}
+	}
+}
+
+func BenchmarkParsePrometheus(b *testing.B) {
+	metrics := bytes.Buffer{}
+	for i := 0; i < 10000; i++ {
+		metrics.WriteString("# TYPE rabbitmq_queue_messages_ready gauge\n")
+		metrics.WriteString("rabbitmq_queue_messages_ready{vhost=\"/\",queue=\"queue-" + strconv.Itoa(i) + "\"} " + strconv.Itoa(i) + "\n")
+	}
+	payload := metrics.Bytes()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := parsePrometheus(bytes.NewReader(payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
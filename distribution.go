@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+distributionOptions are the options of distribution mode
+*/
+type distributionOptions struct {
+	DistPort string `long:"dist-port" default:"25672" description:"The erlang distribution port, or a min-max range as in inet_dist_listen_min/max, checked in distribution mode."`
+}
+
+/*
+distributionPorts expands the --dist-port value, either a single port or a min-max range
+*/
+func distributionPorts(str string) ([]int, error) {
+	bounds := strings.SplitN(str, "-", 2)
+	low, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	high := low
+	if len(bounds) == 2 {
+		high, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if high < low || high-low > 100 {
+		return nil, errors.New("Invalid distribution port range " + str)
+	}
+
+	ports := []int{}
+	for port := low; port <= high; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+/*
+runDistribution checks the distribution port of every host and the cluster links between the nodes
+*/
+func runDistribution(opt *options, hosts []string) {
+	ports, err := distributionPorts(opt.DistPort)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	for _, value := range hosts {
+		processDistribution(value, ports)
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processClusterLinks(nodes)
+		return
+	}
+	printLine("UNKNOWN could not read the cluster links from any host")
+}
+
+/*
+processDistribution checks that the distribution port, or one port of the range, accepts connections from here.
+A node listens on a single port of its range, so one reachable port is enough.
+*/
+func processDistribution(host string, ports []int) {
+	var lastErr error
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), probeTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn.Close()
+		printLine("OK distribution port " + strconv.Itoa(port) + " on " + host + " is reachable")
+		return
+	}
+
+	printLine("CRITICAL no distribution port reachable on " + host + ": " + lastErr.Error())
+}
+
+/*
+processClusterLinks checks that every running node has a distribution link to every other running node
+*/
+func processClusterLinks(nodes []Node) {
+	missing := 0
+	for _, node := range nodes {
+		if node.Running == false {
+			continue
+		}
+
+		linked := map[string]bool{}
+		for _, link := range node.ClusterLinks {
+			linked[link.Name] = true
+		}
+		for _, peer := range nodes {
+			if peer.Running == false || peer.Name == node.Name || linked[peer.Name] {
+				continue
+			}
+			line := downgradeLine(node.Name, "CRITICAL node "+node.Name+" has no cluster link to "+peer.Name)
+			if line != "" {
+				printLine(line)
+				missing++
+			}
+		}
+	}
+
+	if missing == 0 {
+		printLine("OK all " + strconv.Itoa(len(nodes)) + " nodes are linked to each other")
+	}
+}
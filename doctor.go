@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+runDoctor diagnoses the connection to every host step by step. Each step only runs when the ones
+before it passed, so the first failing line points at the actual problem.
+*/
+func runDoctor(opt *options, hosts []string) {
+	for _, value := range hosts {
+		diagnoseHost(opt, value)
+	}
+}
+
+/*
+diagnoseHost walks through dns resolution, tcp connect, tls handshake, authentication and api permissions
+*/
+func diagnoseHost(opt *options, host string) {
+	addresses, err := net.LookupHost(host)
+	if err != nil {
+		printLine("CRITICAL dns: " + host + " does not resolve: " + err.Error() + " - check the --host value and the resolver of this machine")
+		return
+	}
+	printLine("OK dns: " + host + " resolves to " + strings.Join(addresses, ", "))
+
+	address := net.JoinHostPort(host, opt.Port)
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		printLine("CRITICAL tcp: connecting to " + address + " failed: " + err.Error() + " - " + connectHint(err))
+		return
+	}
+	conn.Close()
+	printLine("OK tcp: " + address + " accepts connections")
+
+	if opt.Secure == true {
+		state, err := tlsHandshake(address, probeTLS(host))
+		if err != nil {
+			printLine("CRITICAL tls: handshake with " + address + " failed: " + err.Error() + " - " + tlsHint(err))
+			return
+		}
+		err = verifyChain(host, state.PeerCertificates)
+		if err != nil {
+			printLine("CRITICAL tls: certificate of " + address + " is not trusted: " + err.Error() + " - install the issuing ca on this machine or fix the certificate subject")
+			return
+		}
+		printLine("OK tls: " + address + " negotiated " + tlsVersionName(state.Version) + " with a trusted certificate")
+	} else {
+		printLine("OK tls: skipped, --secure is not set")
+	}
+
+	status, body, err := doctorGet(opt, host, "/api/whoami")
+	if err != nil {
+		printLine("CRITICAL http: requesting " + apiURL(opt, host, "/api/whoami") + " failed: " + err.Error() + " - " + httpHint(opt, err))
+		return
+	}
+	if status == http.StatusUnauthorized {
+		hint := "check --username and --password"
+		if opt.Username == "guest" {
+			hint = hint + ", the guest user can only log in from localhost unless loopback_users is changed"
+		}
+		printLine("CRITICAL auth: " + opt.Username + " was rejected - " + hint)
+		return
+	}
+	if status != http.StatusOK {
+		printLine("CRITICAL http: " + apiURL(opt, host, "/api/whoami") + " returned " + strconv.Itoa(status) + " - is this port the management api?")
+		return
+	}
+
+	whoami := struct {
+		Name string          `json:"name"`
+		Tags json.RawMessage `json:"tags"`
+	}{}
+	err = json.Unmarshal(body, &whoami)
+	if err != nil {
+		printLine("CRITICAL http: /api/whoami did not answer json: " + err.Error() + " - is this port the management api?")
+		return
+	}
+	tags := userTags(whoami.Tags)
+	printLine("OK auth: logged in as " + whoami.Name + " with tags [" + strings.Join(tags, ", ") + "]")
+
+	version, err := hostVersion(opt, host)
+	if err != nil {
+		printLine("WARNING version: " + err.Error() + " - the checks assume a recent rabbitmq")
+	} else {
+		printLine("OK version: " + host + " runs rabbitmq " + version.String())
+	}
+
+	diagnosePermissions(opt, host, tags)
+}
+
+/*
+diagnosePermissions requests the endpoints the checks depend on and explains which tag or vhost permission is missing
+*/
+func diagnosePermissions(opt *options, host string, tags []string) {
+	tagged := map[string]bool{}
+	for _, tag := range tags {
+		tagged[tag] = true
+	}
+	if tagged["monitoring"] == false && tagged["administrator"] == false {
+		printLine("WARNING permissions: " + opt.Username + " lacks the monitoring tag, node and cluster wide checks will fail or see only its own objects")
+	}
+
+	endpoints := []struct {
+		path string
+		hint string
+	}{
+		{"/api/overview", "the user needs the management or monitoring tag"},
+		{"/api/nodes", "the user needs the monitoring tag"},
+		{"/api/queues/" + url.PathEscape(opt.Vhost) + "?page=1&page_size=1", "the user needs permissions on the vhost " + opt.Vhost + ", see rabbitmqctl set_permissions"},
+	}
+	for _, endpoint := range endpoints {
+		status, _, err := doctorGet(opt, host, endpoint.path)
+		if err != nil {
+			printLine("CRITICAL permissions: GET " + endpoint.path + " failed: " + err.Error())
+		} else if status == http.StatusOK {
+			printLine("OK permissions: GET " + endpoint.path + " is allowed")
+		} else if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			printLine("CRITICAL permissions: GET " + endpoint.path + " was refused - " + endpoint.hint)
+		} else if status == http.StatusNotFound && strings.HasPrefix(endpoint.path, "/api/queues/") {
+			printLine("CRITICAL permissions: the vhost " + opt.Vhost + " does not exist - check --vhost")
+		} else {
+			printLine("WARNING permissions: GET " + endpoint.path + " returned " + strconv.Itoa(status))
+		}
+	}
+}
+
+/*
+doctorGet requests a path without turning error statuses into errors, the doctor explains them itself
+*/
+func doctorGet(opt *options, host, path string) (int, []byte, error) {
+	request, err := http.NewRequest("GET", apiURL(opt, host, path), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	request.SetBasicAuth(opt.Username, opt.Password)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	return response.StatusCode, body, err
+}
+
+/*
+userTags reads the tags of /api/whoami, a comma separated string before 3.9 and a list since
+*/
+func userTags(raw json.RawMessage) []string {
+	tags := []string{}
+	if json.Unmarshal(raw, &tags) == nil {
+		return tags
+	}
+
+	joined := ""
+	json.Unmarshal(raw, &joined)
+	for _, tag := range strings.Split(joined, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+/*
+connectHint suggests the usual cause of a failed tcp connect
+*/
+func connectHint(err error) string {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "nothing listens on that port, is the rabbitmq_management plugin enabled and --port right?"
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "the connection timed out, a firewall is probably dropping the packets"
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return "there is no route to the host, check the network configuration"
+	}
+	return "check that the host is up and reachable from here"
+}
+
+/*
+tlsHint suggests the usual cause of a failed tls handshake
+*/
+func tlsHint(err error) string {
+	if strings.Contains(err.Error(), "first record does not look like a TLS handshake") {
+		return "the port speaks plain http, drop --secure or use the https listener port"
+	}
+	return "check the tls listener configuration of the management plugin"
+}
+
+/*
+httpHint suggests the usual cause of a failed http request once the tcp connect worked
+*/
+func httpHint(opt *options, err error) string {
+	if opt.Secure == false && strings.Contains(err.Error(), "malformed HTTP response") {
+		return "the port expects tls, add --secure"
+	}
+	return "the port accepts connections but does not answer http, is it the management api port?"
+}
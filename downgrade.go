@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+downgradeEntry caps the breaches of the objects matching pattern at WARNING, or drops them when suppress is
+set, until the end of the expiry day
+*/
+type downgradeEntry struct {
+	pattern  *regexp.Regexp
+	suppress bool
+	expires  time.Time
+}
+
+// downgrades are the entries of --downgrade-file
+var downgrades []downgradeEntry
+
+/*
+loadDowngrades reads the downgrade file. Every line holds a regular expression matched against the object
+name (vhost/queue for queues, the node name for nodes), the action warning or suppress and the expiry date
+as 2006-01-02, optionally followed by a reason. Empty lines and lines starting with # are skipped.
+*/
+func loadDowngrades(path string) error {
+	downgrades = nil
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		where := path + ":" + strconv.Itoa(number)
+		if len(fields) < 3 {
+			return errors.New(where + ": expected a pattern, an action and an expiry date")
+		}
+		pattern, err := regexp.Compile(fields[0])
+		if err != nil {
+			return errors.New(where + ": " + err.Error())
+		}
+		if fields[1] != "warning" && fields[1] != "suppress" {
+			return errors.New(where + ": unknown action " + fields[1] + ", expected warning or suppress")
+		}
+		expires, err := time.ParseInLocation("2006-01-02", fields[2], time.Local)
+		if err != nil {
+			return errors.New(where + ": " + err.Error())
+		}
+
+		downgrades = append(downgrades, downgradeEntry{
+			pattern:  pattern,
+			suppress: fields[1] == "suppress",
+			expires:  expires.AddDate(0, 0, 1),
+		})
+	}
+	return scanner.Err()
+}
+
+/*
+downgradeLine applies the first unexpired entry matching the object to a breach line, returning "" when the
+breach is suppressed. Lines other than CRITICAL and WARNING ones are returned as they are.
+*/
+func downgradeLine(object, line string) string {
+	state := lineState(line)
+	if state != "CRITICAL" && state != "WARNING" {
+		return line
+	}
+
+	now := time.Now()
+	for _, entry := range downgrades {
+		if now.After(entry.expires) || entry.pattern.MatchString(object) == false {
+			continue
+		}
+		if entry.suppress {
+			return ""
+		}
+		if state == "CRITICAL" {
+			return "WARNING" + strings.TrimPrefix(line, "CRITICAL") + " (downgraded)"
+		}
+		return line
+	}
+	return line
+}
+
+/*
+downgradeLines applies downgradeLine to the breaches of an object, returning the remaining lines with the
+number of warning and critical ones among them
+*/
+func downgradeLines(object string, lines []string) ([]string, int, int) {
+	kept := []string{}
+	warnings, criticals := 0, 0
+	for _, line := range lines {
+		line = downgradeLine(object, line)
+		switch lineState(line) {
+		case "":
+			continue
+		case "CRITICAL":
+			criticals++
+		case "WARNING":
+			warnings++
+		}
+		kept = append(kept, line)
+	}
+	return kept, warnings, criticals
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+drainOptions are the options of drain-time mode
+*/
+type drainOptions struct {
+	DrainTime        string `long:"drain-time" default:"3600,21600" description:"Warning and critical thresholds in seconds for the backlog of a queue to clear at its current publish and ack rates in drain-time mode."`
+	DrainMinMessages int    `long:"drain-min-messages" default:"1000" description:"Queues holding fewer messages are left out of drain-time mode, their backlog is not worth a forecast."`
+}
+
+/*
+drainForecast is the time a queue needs to clear its backlog at its current rates, with the rates it was
+computed from. A queue published to at least as fast as it is acked never drains.
+*/
+type drainForecast struct {
+	name     string
+	messages int
+	publish  float64
+	ack      float64
+	seconds  float64
+	draining bool
+}
+
+/*
+forecastDrain computes the drain forecast of a queue from its depth and its publish and ack rates
+*/
+func forecastDrain(queue Queue) drainForecast {
+	forecast := drainForecast{
+		name:     queue.Vhost + "/" + queue.Name,
+		messages: queue.Messages,
+		publish:  queue.MessageStats.PublishDetails.value(),
+		ack:      queue.MessageStats.AckDetails.value(),
+	}
+	net := forecast.ack - forecast.publish
+	if net > 0 {
+		forecast.draining = true
+		forecast.seconds = float64(forecast.messages) / net
+	}
+	return forecast
+}
+
+/*
+runDrainTime forecasts how long the backlog of every queue matching --queue-pattern takes to clear at the
+current publish and ack rates, and alerts on the queues whose forecast is beyond --drain-time. Queues below
+--drain-min-messages are in their steady state and left out.
+*/
+func runDrainTime(opt *options, hosts []string) {
+	limits, err := limitMap(opt.DrainTime)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		forecasts := []drainForecast{}
+		_, err := listQueues(queueScope(opt), value, opt.QueuePattern, queueColumns+",message_stats", func(queue Queue) error {
+			if queue.Messages >= opt.DrainMinMessages {
+				forecasts = append(forecasts, forecastDrain(queue))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processDrainTime(forecasts, limits, queueScopeName(opt))
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+processDrainTime prints a summary line followed by the queues whose backlog does not clear in time
+*/
+func processDrainTime(forecasts []drainForecast, limits []int, scope string) {
+	// pages arrive in any order, sort so successive runs print the same output
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].name < forecasts[j].name
+	})
+
+	warnings, criticals := 0, 0
+	breaches := []string{}
+	for _, forecast := range forecasts {
+		rates := "publish " + strconv.FormatFloat(forecast.publish, 'f', 1, 64) + " msgs/sec, ack " +
+			strconv.FormatFloat(forecast.ack, 'f', 1, 64) + " msgs/sec"
+		line := ""
+		if forecast.draining == false {
+			line = "CRITICAL " + forecast.name + " backlog of " + strconv.Itoa(forecast.messages) +
+				" messages is not draining at the current rates, " + rates
+		} else {
+			recordGauge("rabbitmq.queue.drain_seconds", forecast.seconds, "queue", forecast.name)
+			recordPerf(forecast.name+"_drain", forecast.seconds, "s", perfLimit(limits, 0), perfLimit(limits, 1))
+
+			message := forecast.name + " backlog of " + strconv.Itoa(forecast.messages) + " messages clears in " +
+				(time.Duration(forecast.seconds) * time.Second).String() + " at the current rates, " + rates
+			if forecast.seconds >= float64(limits[1]) {
+				line = "CRITICAL " + message
+			} else if forecast.seconds >= float64(limits[0]) {
+				line = "WARNING " + message
+			}
+		}
+		if line = downgradeLine(forecast.name, line); line == "" {
+			continue
+		}
+
+		if lineState(line) == "CRITICAL" {
+			criticals++
+		} else {
+			warnings++
+		}
+		breaches = append(breaches, line)
+	}
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("queues with a backlog in "+scope, len(forecasts), warnings, criticals))
+	for _, line := range breaches {
+		printLine(line)
+	}
+}
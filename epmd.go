@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+epmdOptions are the options of epmd mode
+*/
+type epmdOptions struct {
+	EpmdPort string `long:"epmd-port" default:"4369" description:"The port epmd listens on, checked in epmd mode."`
+	EpmdNode string `long:"epmd-node" default:"rabbit" description:"The node name, without the host part, expected to be registered with epmd."`
+}
+
+// epmdNamesReq is the NAMES_REQ request of the epmd protocol, a two byte length followed by the request code
+var epmdNamesReq = []byte{0, 1, 110}
+
+/*
+epmdNames queries epmd for the registered node names, mapping each name to its distribution port
+*/
+func epmdNames(host, port string) (map[string]string, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), probeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	if _, err = conn.Write(epmdNamesReq); err != nil {
+		return nil, err
+	}
+
+	// the reply starts with the port epmd listens on, followed by one "name X at port Y" line per node
+	reader := bufio.NewReader(conn)
+	if _, err = io.ReadFull(reader, make([]byte, 4)); err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 5 && fields[0] == "name" && fields[2] == "at" && fields[3] == "port" {
+			names[fields[1]] = fields[4]
+		}
+	}
+	return names, nil
+}
+
+/*
+processEpmd checks that the expected node is registered with epmd on the host
+*/
+func processEpmd(opt *options, host string) {
+	names, err := epmdNames(host, opt.EpmdPort)
+	if err != nil {
+		printLine("CRITICAL querying epmd on " + host + " failed: " + err.Error())
+		return
+	}
+
+	port, ok := names[opt.EpmdNode]
+	if ok == false {
+		registered := []string{}
+		for name := range names {
+			registered = append(registered, name)
+		}
+		sort.Strings(registered)
+		printLine("CRITICAL node " + opt.EpmdNode + " is not registered with epmd on " + host + " (registered: " + strings.Join(registered, ", ") + ")")
+		return
+	}
+
+	printLine("OK node " + opt.EpmdNode + " is registered with epmd on " + host + " at port " + port)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+exchangeOptions are the options of exchanges mode
+*/
+type exchangeOptions struct {
+	Definitions string `long:"definitions" description:"A definitions export (rabbitmqctl export_definitions) holding the expected exchanges of its vhosts, checked in exchanges mode."`
+}
+
+/*
+Exchange representation from /api/exchanges and from exported definitions
+*/
+type Exchange struct {
+	Name      string                 `json:"name"`
+	Vhost     string                 `json:"vhost"`
+	Type      string                 `json:"type"`
+	Durable   bool                   `json:"durable"`
+	Arguments map[string]interface{} `json:"arguments"`
+
+	MessageStats MessageStats `json:"message_stats"`
+}
+
+/*
+Definitions is the part of a definitions export (rabbitmqctl export_definitions or GET /api/definitions)
+used as the expected inventory
+*/
+type Definitions struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+/*
+loadDefinitions reads a definitions export
+*/
+func loadDefinitions(path string) (*Definitions, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	definitions := &Definitions{}
+	err = json.Unmarshal(content, definitions)
+	if err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
+
+/*
+builtinExchange tells the exchanges every vhost gets on creation, which exports leave out
+*/
+func builtinExchange(name string) bool {
+	return name == "" || strings.HasPrefix(name, "amq.")
+}
+
+/*
+listExchanges pages through the exchanges of the cluster and hands every exchange to each
+*/
+func listExchanges(opt *options, host string, each func(exchange Exchange) error) error {
+	query := url.Values{}
+	query.Set("columns", "name,vhost,type,durable,arguments")
+	_, err := apiPages(opt, host, "/api/exchanges", query, func(decoder *json.Decoder) error {
+		exchange := Exchange{}
+		err := decodeItem(decoder, "/api/exchanges", query.Get("columns"), &exchange)
+		if err != nil {
+			return err
+		}
+		return each(exchange)
+	})
+	return err
+}
+
+/*
+runExchanges compares the exchanges of the vhosts listed in the --definitions file with the file. A missing
+exchange or one of another type breaks publishers and is critical, an unexpected one is a warning.
+*/
+func runExchanges(opt *options, hosts []string) {
+	if opt.Definitions == "" {
+		printUnknown("The exchanges mode requires --definitions")
+		return
+	}
+	definitions, err := loadDefinitions(opt.Definitions)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	expected := map[string]Exchange{}
+	vhosts := map[string]bool{}
+	for _, exchange := range definitions.Exchanges {
+		expected[exchange.Vhost+"/"+exchange.Name] = exchange
+		vhosts[exchange.Vhost] = true
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		actual := map[string]Exchange{}
+		err := listExchanges(opt, value, func(exchange Exchange) error {
+			if vhosts[exchange.Vhost] && builtinExchange(exchange.Name) == false {
+				actual[exchange.Vhost+"/"+exchange.Name] = exchange
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processExchanges(expected, actual)
+		return
+	}
+	printLine("UNKNOWN could not list the exchanges from any host")
+}
+
+/*
+processExchanges prints a summary line followed by a line per difference between the expected and actual exchanges
+*/
+func processExchanges(expected, actual map[string]Exchange) {
+	criticals, warnings := []string{}, []string{}
+	for key, exchange := range expected {
+		found, ok := actual[key]
+		if ok == false {
+			criticals = append(criticals, "CRITICAL exchange "+key+" is missing")
+		} else if found.Type != exchange.Type {
+			criticals = append(criticals, "CRITICAL exchange "+key+" is of type "+found.Type+" instead of "+exchange.Type)
+		}
+	}
+	for key := range actual {
+		if _, ok := expected[key]; ok == false {
+			warnings = append(warnings, "WARNING exchange "+key+" is not in the inventory")
+		}
+	}
+	sort.Strings(criticals)
+	sort.Strings(warnings)
+
+	state := "OK"
+	if len(criticals) > 0 {
+		state = "CRITICAL"
+	} else if len(warnings) > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("exchanges", len(expected), len(warnings), len(criticals)))
+	for _, line := range append(criticals, warnings...) {
+		printLine(line)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+metricsExporter serves the values collected by the checks of the latest round in the prometheus text format.
+A round collects into its own series, the scrapes keep reading the previous round until it is complete.
+*/
+type metricsExporter struct {
+	mutex      sync.Mutex
+	collecting map[string]map[string]float64
+	served     []byte
+}
+
+// exporter is the exporter of --listen, nil when the check runs once or in watch mode
+var exporter *metricsExporter
+
+/*
+recordExportedValue keeps a collected value for the round of the exporter, the last value recorded for a label
+set wins as prometheus refuses duplicate series
+*/
+func recordExportedValue(name string, value float64, pairs ...string) {
+	if exporter == nil {
+		return
+	}
+
+	labels := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, metricName(pairs[i])+"=\""+labelValue(pairs[i+1])+"\"")
+	}
+	sort.Strings(labels)
+
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	name = metricName(name)
+	if exporter.collecting[name] == nil {
+		exporter.collecting[name] = map[string]float64{}
+	}
+	exporter.collecting[name][strings.Join(labels, ",")] = value
+}
+
+/*
+metricName replaces the characters prometheus does not allow in metric and label names, e.g. the dots of the
+gauge names, with underscores
+*/
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+/*
+labelValue escapes a label value for the prometheus text format
+*/
+func labelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+/*
+publish makes the values collected by the round the ones served and starts collecting the next round
+*/
+func (e *metricsExporter) publish() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	names := []string{}
+	for name := range e.collecting {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text := []string{}
+	for _, name := range names {
+		text = append(text, "# TYPE "+name+" gauge")
+		series := []string{}
+		for labels := range e.collecting[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			value := strconv.FormatFloat(e.collecting[name][labels], 'g', -1, 64)
+			if labels == "" {
+				text = append(text, name+" "+value)
+			} else {
+				text = append(text, name+"{"+labels+"} "+value)
+			}
+		}
+	}
+	e.served = []byte(strings.Join(text, "\n") + "\n")
+	e.collecting = map[string]map[string]float64{}
+}
+
+/*
+ServeHTTP answers the scrapes of /metrics
+*/
+func (e *metricsExporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/metrics" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	e.mutex.Lock()
+	served := e.served
+	e.mutex.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writer.Write(served)
+}
+
+/*
+runExporter stays resident as a prometheus exporter: every --poll-interval it runs the --export-modes checks,
+reusing the values they collect for nagios as the metrics served on --listen, along with the state and
+duration of every check. Changed hosts, thresholds and filters of the --config file are picked up between rounds.
+*/
+func runExporter(opt *options, hosts []string, args []string) {
+	if opt.PollInterval <= 0 {
+		printUnknown("--poll-interval must be positive")
+		return
+	}
+	listener, err := net.Listen("tcp", opt.Listen)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	exporter = &metricsExporter{collecting: map[string]map[string]float64{}}
+	go func() {
+		err := http.Serve(listener, exporter)
+		log.Fatalln("Serving the metrics on " + opt.Listen + " failed: " + err.Error())
+	}()
+
+	watcher := newConfigWatcher(opt.Config)
+	for {
+		start := time.Now()
+		if watcher.changed() {
+			opt, hosts, args = reloadOptions(opt, hosts, args)
+			watcher.path = opt.Config
+		}
+
+		for _, mode := range strings.Split(opt.ExportModes, ",") {
+			opt.Mode = strings.TrimSpace(mode)
+			modeStart := time.Now()
+			resetOutput()
+			runChecks(opt, hosts, args)
+
+			// nobody reads the output of a resident exporter, failures have to reach its log
+			state := outputState()
+			if state == "UNKNOWN" {
+				outputMutex.Lock()
+				log.Println(opt.Mode + ": " + strings.Join(outputLines, "; "))
+				outputMutex.Unlock()
+			}
+			recordExportedValue("check_rabbitmq_state", float64(exitCode(state)), "mode", opt.Mode)
+			recordExportedValue("check_rabbitmq_duration_seconds", time.Since(modeStart).Seconds(), "mode", opt.Mode)
+		}
+		exporter.publish()
+
+		// the signal stopping a probe was meant for the process, which ends once the round is published
+		if interrupted {
+			return
+		}
+		time.Sleep(opt.PollInterval - time.Since(start))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sort"
+)
+
+/*
+federationOptions are the options of federation mode
+*/
+type federationOptions struct {
+	FederationUpstreams []string `long:"federation-upstream" description:"Check only the links of this upstream in federation mode, critical when it has none. Repeat for every upstream, every link of the --vhost (all vhosts when empty) by default."`
+}
+
+/*
+FederationLink representation from /api/federation-links
+*/
+type FederationLink struct {
+	Node     string `json:"node"`
+	Vhost    string `json:"vhost" required:"true"`
+	Upstream string `json:"upstream" required:"true"`
+	Type     string `json:"type"`
+	Exchange string `json:"exchange"`
+	Queue    string `json:"queue"`
+	Status   string `json:"status" required:"true"`
+	Error    string `json:"error"`
+}
+
+/*
+name describes the link by its upstream and the exchange or queue it federates
+*/
+func (link FederationLink) name() string {
+	object := link.Exchange
+	if link.Type == "queue" {
+		object = link.Queue
+	}
+	return "upstream " + link.Upstream + " of " + link.Type + " " + link.Vhost + "/" + object
+}
+
+/*
+runFederation checks every federation link runs. A link stopping does not show anywhere else: the local side
+keeps serving its consumers, only the messages from the other data center stop arriving.
+*/
+func runFederation(opt *options, hosts []string) {
+	path := "/api/federation-links"
+	if opt.Vhost != "" {
+		path = path + "/" + url.PathEscape(opt.Vhost)
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		links := []FederationLink{}
+		err := apiRequest(opt, value, "GET", path, nil, &links)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processFederation(opt, links)
+		return
+	}
+	printLine("UNKNOWN could not read the federation links from any host")
+}
+
+/*
+processFederation prints a summary line followed by the links not running and the upstreams without links.
+A link still starting is a warning, any other status critical.
+*/
+func processFederation(opt *options, links []FederationLink) {
+	upstreams := map[string]int{}
+	for _, upstream := range opt.FederationUpstreams {
+		upstreams[upstream] = 0
+	}
+
+	checked, warnings, criticals := 0, 0, 0
+	lines := []string{}
+	for _, link := range links {
+		if _, ok := upstreams[link.Upstream]; len(opt.FederationUpstreams) > 0 && ok == false {
+			continue
+		}
+		upstreams[link.Upstream]++
+		checked++
+
+		line := ""
+		switch link.Status {
+		case "running":
+		case "starting":
+			line = "WARNING " + link.name() + " is starting on " + link.Node
+		default:
+			line = "CRITICAL " + link.name() + " is " + link.Status + " on " + link.Node
+			if link.Error != "" {
+				line = line + ": " + link.Error
+			}
+		}
+		line = downgradeLine(link.Vhost+"/"+link.Upstream, line)
+		switch lineState(line) {
+		case "CRITICAL":
+			criticals++
+		case "WARNING":
+			warnings++
+		default:
+			continue
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	missing := []string{}
+	for _, upstream := range opt.FederationUpstreams {
+		if upstreams[upstream] == 0 {
+			missing = append(missing, "CRITICAL upstream "+upstream+" has no federation link")
+			criticals++
+		}
+	}
+	lines = append(missing, lines...)
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	recordPerf("federation_links", float64(checked), "", "", "")
+	recordPerf("federation_links_down", float64(warnings+criticals), "", "", "")
+	printLine(state + " " + summaryCounts("federation links", checked, warnings, criticals))
+	for _, line := range lines {
+		printLine(line)
+	}
+}
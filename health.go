@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+)
+
+/*
+healthCheck is one of the /api/health/checks endpoints, or the node health check of the brokers before them
+which answers its status in the body
+*/
+type healthCheck struct {
+	name   string
+	path   string
+	legacy bool
+}
+
+/*
+legacyHealth is the answer of /api/healthchecks/node
+*/
+type legacyHealth struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+/*
+healthResult is the outcome of a health check against a host, err is nil when the check passed
+*/
+type healthResult struct {
+	host  string
+	check healthCheck
+	err   error
+}
+
+/*
+healthChecks returns the suite of health checks run in health-all mode against a broker of the version, the
+whole suite when the version is unknown. The endpoints appeared in 3.8.10, replacing the node health check,
+and the mirror sync one left along with the classic queue mirroring in 4.0.
+*/
+func healthChecks(version brokerVersion, known bool, expiry []int) []healthCheck {
+	if known && version.atLeast(3, 8, 10) == false {
+		return []healthCheck{{"node", "/api/healthchecks/node", true}}
+	}
+
+	checks := []healthCheck{
+		{"alarms", "/api/health/checks/alarms", false},
+		{"local-alarms", "/api/health/checks/local-alarms", false},
+		{"certificate-expiration", "/api/health/checks/certificate-expiration/" + strconv.Itoa(expiry[1]) + "/days", false},
+		{"protocol-listener amqp", "/api/health/checks/protocol-listener/amqp", false},
+		{"virtual-hosts", "/api/health/checks/virtual-hosts", false},
+	}
+	if known == false || version.atLeast(4, 0, 0) == false {
+		checks = append(checks, healthCheck{"node-is-mirror-sync-critical", "/api/health/checks/node-is-mirror-sync-critical", false})
+	}
+	return append(checks, healthCheck{"node-is-quorum-critical", "/api/health/checks/node-is-quorum-critical", false})
+}
+
+/*
+runHealthCheck runs a health check against the host, returning nil when it passed
+*/
+func runHealthCheck(opt *options, host string, check healthCheck) error {
+	if check.legacy == false {
+		return apiRequest(opt, host, "GET", check.path, nil, nil)
+	}
+
+	result := legacyHealth{}
+	err := apiRequest(opt, host, "GET", check.path, nil, &result)
+	if err == nil && result.Status != "ok" {
+		err = errors.New(result.Reason)
+	}
+	return err
+}
+
+/*
+runHealthAll runs every health check against every host concurrently and folds the results into one
+status line followed by a line per check
+*/
+func runHealthAll(opt *options, hosts []string) {
+	expiry, err := limitMap(opt.CertExpiry)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// the suite depends on the version of every host, a fleet being upgraded mixes them
+	versions := make([]brokerVersion, len(hosts))
+	errs := eachHost(opt, hosts, func(index int, host string) error {
+		var err error
+		versions[index], err = hostVersion(opt, host)
+		return err
+	})
+	results := []healthResult{}
+	for index, value := range hosts {
+		if errs[index] != nil {
+			log.Println(errs[index].Error())
+		}
+		for _, check := range healthChecks(versions[index], errs[index] == nil, expiry) {
+			results = append(results, healthResult{host: value, check: check})
+		}
+	}
+	parallel(opt.Concurrency, len(results), func(index int) error {
+		results[index].err = runHealthCheck(opt, results[index].host, results[index].check)
+		return nil
+	})
+
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		}
+	}
+
+	total := strconv.Itoa(len(results))
+	if failed > 0 {
+		printLine("CRITICAL " + strconv.Itoa(failed) + " of " + total + " health checks failed")
+	} else {
+		printLine("OK all " + total + " health checks passed")
+	}
+
+	for _, result := range results {
+		if result.err != nil {
+			printLine("CRITICAL " + result.host + " " + result.check.name + ": " + result.err.Error())
+		} else {
+			printLine("OK " + result.host + " " + result.check.name)
+		}
+	}
+}
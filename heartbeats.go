@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+/*
+tuningOptions are the options of connection-tuning mode
+*/
+type tuningOptions struct {
+	HeartbeatRange string `long:"heartbeat-range" default:"1:" description:"The heartbeat timeouts in seconds amqp clients may negotiate, as a nagios range checked in connection-tuning mode. The default flags clients disabling heartbeats."`
+	FrameMaxRange  string `long:"frame-max-range" default:"4096:131072" description:"The frame_max sizes in bytes amqp clients may negotiate, as a nagios range checked in connection-tuning mode."`
+}
+
+/*
+tuningBreach counts the connections of a client breaching one of the negotiated settings
+*/
+type tuningBreach struct {
+	client      string
+	setting     string
+	value       int
+	connections int
+}
+
+/*
+runConnectionTuning checks the heartbeat and frame_max negotiated by the amqp 0-9-1 clients against the
+--heartbeat-range and --frame-max-range policy. A client without heartbeats is only noticed as gone once
+the tcp stack gives up on it, which leaves its channels and consumers behind for hours.
+*/
+func runConnectionTuning(opt *options, hosts []string) {
+	heartbeats, err := parseRange(opt.HeartbeatRange)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	frameMax, err := parseRange(opt.FrameMaxRange)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	all := vhostScope(opt, "")
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		connections := []Connection{}
+		_, err := listConnections(all, value, func(connection Connection) error {
+			connections = append(connections, connection)
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processConnectionTuning(connections, heartbeats, frameMax)
+		return
+	}
+	printLine("UNKNOWN could not read the connections from any host")
+}
+
+/*
+processConnectionTuning prints a summary line followed by a line per client and setting out of policy,
+naming the user and the host it connects from
+*/
+func processConnectionTuning(connections []Connection, heartbeats, frameMax nagiosRange) {
+	breaches := map[string]*tuningBreach{}
+	breach := func(connection Connection, setting string, value int) {
+		client := connection.User + "@" + connection.PeerHost
+		key := client + " " + setting + " " + strconv.Itoa(value)
+		if breaches[key] == nil {
+			breaches[key] = &tuningBreach{client: client, setting: setting, value: value}
+		}
+		breaches[key].connections++
+	}
+
+	checked, breaching := 0, 0
+	for _, connection := range connections {
+		// direct connections have nothing negotiated and other protocols have their own keepalives
+		if connection.Type == "direct" || protocolFamily(connection.Protocol) != "amqp091" {
+			continue
+		}
+		checked++
+		breached := false
+		if heartbeats.alerts(float64(connection.Timeout)) {
+			breach(connection, "heartbeat", connection.Timeout)
+			breached = true
+		}
+		if frameMax.alerts(float64(connection.FrameMax)) {
+			breach(connection, "frame_max", connection.FrameMax)
+			breached = true
+		}
+		if breached {
+			breaching++
+		}
+	}
+
+	keys := []string{}
+	for key := range breaches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{}
+	for _, key := range keys {
+		found := breaches[key]
+		line := "WARNING " + found.client + " negotiated a " + found.setting + " of " + strconv.Itoa(found.value) + " on " +
+			strconv.Itoa(found.connections) + " connections"
+		if found.setting == "heartbeat" && found.value == 0 {
+			line = "WARNING " + found.client + " disabled heartbeats on " + strconv.Itoa(found.connections) + " connections"
+		}
+		if line = downgradeLine(found.client, line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	recordPerf("connections_out_of_policy", float64(breaching), "", "0", "")
+	if len(lines) == 0 {
+		printLine("OK " + strconv.Itoa(checked) + " amqp connections negotiated heartbeats and frame_max within policy")
+		return
+	}
+	printLine("WARNING " + strconv.Itoa(breaching) + " of " + strconv.Itoa(checked) + " amqp connections negotiated heartbeats or frame_max out of policy")
+	for _, line := range lines {
+		printLine(line)
+	}
+}
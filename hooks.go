@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hookTimeout bounds the hooks, a hanging remediation must not hold up the check forever
+const hookTimeout = 30 * time.Second
+
+/*
+hookState is what the hooks remember between runs
+*/
+type hookState struct {
+	State string `json:"state"`
+}
+
+/*
+runHooks executes the --on-critical, --on-warning and --on-recovery command matching the change from the state
+of the previous run to the state of this one. Nothing runs while the state stays the same.
+*/
+func runHooks(opt *options) {
+	if opt.OnCritical == "" && opt.OnWarning == "" && opt.OnRecovery == "" {
+		return
+	}
+
+	previous := hookState{State: "OK"}
+	err := loadState(opt, "hooks", &previous)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	current := hookState{State: outputState()}
+	err = saveState(opt, "hooks", current)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	if current.State == previous.State {
+		return
+	}
+
+	command := ""
+	switch current.State {
+	case "CRITICAL":
+		command = opt.OnCritical
+	case "WARNING":
+		command = opt.OnWarning
+	case "OK":
+		if previous.State == "WARNING" || previous.State == "CRITICAL" {
+			command = opt.OnRecovery
+		}
+	}
+	if command == "" {
+		return
+	}
+
+	err = runHook(opt, command, previous.State, current.State)
+	if err != nil {
+		log.Println("Hook " + command + " failed: " + err.Error())
+	}
+}
+
+/*
+runHook runs a hook command through the shell with the context of the check in CHECK_RABBITMQ_* environment
+variables. Its output goes to stderr so it never mixes with the plugin output read by nagios.
+*/
+func runHook(opt *options, command, previous, current string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	outputMutex.Lock()
+	lines := append([]string{}, outputLines...)
+	outputMutex.Unlock()
+
+	critical, warning := []string{}, []string{}
+	for _, line := range lines {
+		switch lineState(line) {
+		case "CRITICAL":
+			critical = append(critical, line)
+		case "WARNING":
+			warning = append(warning, line)
+		}
+	}
+	summary := ""
+	if len(lines) > 0 {
+		summary = lines[0]
+	}
+
+	hook := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	hook.Stdout = os.Stderr
+	hook.Stderr = os.Stderr
+	hook.Env = append(os.Environ(),
+		"CHECK_RABBITMQ_STATE="+current,
+		"CHECK_RABBITMQ_PREVIOUS_STATE="+previous,
+		"CHECK_RABBITMQ_MODE="+opt.Mode,
+		"CHECK_RABBITMQ_HOSTS="+strings.Join(opt.Host, ","),
+		"CHECK_RABBITMQ_VHOST="+opt.Vhost,
+		"CHECK_RABBITMQ_SUMMARY="+summary,
+		"CHECK_RABBITMQ_OUTPUT="+strings.Join(lines, "\n"),
+		"CHECK_RABBITMQ_CRITICAL="+strings.Join(critical, "\n"),
+		"CHECK_RABBITMQ_WARNING="+strings.Join(warning, "\n"),
+	)
+	return hook.Run()
+}
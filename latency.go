@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+/*
+latencyOptions are the options of api-latency mode
+*/
+type latencyOptions struct {
+	APILatency     string `long:"api-latency" default:"500,2000" description:"Warning and critical thresholds in milliseconds for the management api to answer in api-latency mode."`
+	APILatencyPath string `long:"api-latency-path" default:"/api/whoami" description:"The cheap endpoint timed in api-latency mode, e.g. /api/overview?columns=rabbitmq_version."`
+}
+
+/*
+runAPILatency times a cheap request to the management api of every host. A sluggish management plane
+reliably precedes the stats emission collapsing, and every node serves its own api, so each host is checked.
+*/
+func runAPILatency(opt *options, hosts []string) {
+	limits, err := limitMap(opt.APILatency)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	checkHosts(hosts, func(host string) {
+		processAPILatency(opt, host, limits)
+	})
+}
+
+/*
+processAPILatency thresholds the time taken to request --api-latency-path and read the response. The api failing
+to answer is what the mode guards against, so it is critical rather than unknown.
+*/
+func processAPILatency(opt *options, host string, limits []int) {
+	start := time.Now()
+	err := apiRequest(opt, host, "GET", opt.APILatencyPath, nil, nil)
+	latency := time.Since(start)
+	if err != nil {
+		printLine("CRITICAL requesting " + opt.APILatencyPath + " on " + host + " failed after " +
+			strconv.Itoa(int(latency/time.Millisecond)) + "ms: " + err.Error())
+		return
+	}
+
+	millis := int(latency / time.Millisecond)
+	recordGauge("check_rabbitmq.api.latency_ms", float64(millis), "host", host)
+	recordPerf(host+"_api_latency", float64(millis), "ms", perfLimit(limits, 0), perfLimit(limits, 1))
+
+	message := "api latency " + strconv.Itoa(millis) + "ms on " + host
+	if millis >= limits[1] {
+		printLine("CRITICAL " + message)
+	} else if millis >= limits[0] {
+		printLine("WARNING " + message)
+	} else {
+		printLine("OK " + message)
+	}
+}
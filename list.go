@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/*
+listKind is an object the list mode can print. The leading columns of its rows identify an object, macros
+names them for zabbix discovery.
+*/
+type listKind struct {
+	rows   func(opt *options, host string) ([][]string, error)
+	macros []string
+}
+
+// listKinds are the objects the list mode can print
+var listKinds = map[string]listKind{
+	"queues":      {listQueueRows, []string{"{#VHOST}", "{#QUEUE}"}},
+	"nodes":       {listNodeRows, []string{"{#NODE}"}},
+	"connections": {listConnectionRows, []string{"{#VHOST}", "{#CONNECTION}"}},
+	"policies":    {listPolicyRows, []string{"{#VHOST}", "{#POLICY}"}},
+}
+
+/*
+runList prints a table of the objects named by the first argument, as in check_rabbitmq list queues.
+The connections and policies of every vhost are listed, the queues of --queue-vhost filtered by --queue-pattern,
+read from the first host answering.
+With the zabbix outputs the table is printed as a discovery document or, for the object named by the remaining
+arguments, as the bare value of the --item column, with the json output as the rows of the document.
+*/
+func runList(opt *options, hosts []string, args []string) {
+	if len(args) == 0 {
+		printUnknown("The list mode requires one of queues, nodes, connections or policies")
+		return
+	}
+	kind, ok := listKinds[args[0]]
+	if ok == false {
+		printUnknown("Unknown list " + args[0] + ", expected one of queues, nodes, connections or policies")
+		return
+	}
+	if opt.Output == "zabbix-value" && opt.Item == "" {
+		printUnknown("The zabbix-value output requires --item")
+		return
+	}
+
+	for _, value := range hosts {
+		table, err := kind.rows(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		switch opt.Output {
+		case "zabbix-lld":
+			err = printDiscovery(table, kind.macros)
+		case "zabbix-value":
+			err = printItemValue(table, len(kind.macros), args[1:], opt.Item)
+		case "json":
+			recordReportRows(table)
+			printLine("OK " + strconv.Itoa(len(table)-1) + " " + args[0] + " listed")
+		default:
+			printTable(table)
+		}
+		if err != nil {
+			printUnknown(err.Error())
+		}
+		return
+	}
+	printUnknown("Could not list the " + args[0] + " from any host")
+}
+
+/*
+printTable prints the rows aligned in columns, the first row being the header. The aligned lines go through
+printLine like the lines of the checks, so the output limits apply and the run exits OK.
+*/
+func printTable(rows [][]string) {
+	aligned := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(aligned, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		writer.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+	writer.Flush()
+	for _, line := range strings.Split(strings.TrimSuffix(aligned.String(), "\n"), "\n") {
+		printLine(line)
+	}
+}
+
+/*
+sortRows sorts the rows below the header on their leading columns
+*/
+func sortRows(rows [][]string) [][]string {
+	body := rows[1:]
+	sort.Slice(body, func(i, j int) bool {
+		for column := range body[i] {
+			if body[i][column] != body[j][column] {
+				return body[i][column] < body[j][column]
+			}
+		}
+		return false
+	})
+	return rows
+}
+
+/*
+listQueueRows returns the queue table
+*/
+func listQueueRows(opt *options, host string) ([][]string, error) {
+	rows := [][]string{{"VHOST", "NAME", "MESSAGES", "READY", "UNACKED", "CONSUMERS"}}
+	_, err := listQueues(queueScope(opt), host, opt.QueuePattern, queueColumns, func(queue Queue) error {
+		rows = append(rows, []string{
+			queue.Vhost,
+			queue.Name,
+			strconv.Itoa(queue.Messages),
+			strconv.Itoa(queue.MessagesReady),
+			strconv.Itoa(queue.MessagesUnack),
+			strconv.Itoa(queue.Consumers),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortRows(rows), nil
+}
+
+/*
+listNodeRows returns the node table
+*/
+func listNodeRows(opt *options, host string) ([][]string, error) {
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// raw numbers like the api returns them, so the columns can be collected as zabbix items
+	rows := [][]string{{"NAME", "RUNNING", "MEM USED", "MEM LIMIT", "DISK FREE", "FD USED", "FD TOTAL", "UPTIME"}}
+	for _, node := range nodes {
+		rows = append(rows, []string{
+			node.Name,
+			strconv.FormatBool(node.Running),
+			strconv.Itoa(node.MemUsed),
+			strconv.Itoa(node.MemLimit),
+			strconv.Itoa(node.DiskFree),
+			strconv.Itoa(node.FdUsed),
+			strconv.Itoa(node.FdTotal),
+			strconv.Itoa(node.Uptime / 1000),
+		})
+	}
+	return rows, nil
+}
+
+/*
+listConnectionRows returns the connection table of every vhost
+*/
+func listConnectionRows(opt *options, host string) ([][]string, error) {
+	rows := [][]string{{"VHOST", "NAME", "USER", "PROTOCOL", "STATE", "CHANNELS"}}
+	_, err := listConnections(vhostScope(opt, ""), host, func(connection Connection) error {
+		rows = append(rows, []string{
+			connection.Vhost,
+			connection.Name,
+			connection.User,
+			connection.Protocol,
+			connection.State,
+			strconv.Itoa(connection.Channels),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortRows(rows), nil
+}
+
+/*
+listPolicyRows returns the policy table of every vhost
+*/
+func listPolicyRows(opt *options, host string) ([][]string, error) {
+	policies, err := fetchPolicies(vhostScope(opt, ""), host)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{{"VHOST", "NAME", "PATTERN", "APPLY TO", "PRIORITY", "DEFINITION"}}
+	for _, policy := range policies {
+		rows = append(rows, []string{
+			policy.Vhost,
+			policy.Name,
+			policy.Pattern,
+			policy.ApplyTo,
+			strconv.Itoa(policy.Priority),
+			policy.definitionString(),
+		})
+	}
+	return sortRows(rows), nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintTableExitsOK(t *testing.T) {
+	resetOutput()
+	defer resetOutput()
+
+	printTable([][]string{{"VHOST", "NAME"}, {"/", "orders"}, {"tenant-a", "invoices"}})
+	if len(outputLines) != 3 {
+		t.Fatalf("expected the header and 2 rows, got %q", outputLines)
+	}
+	if strings.HasPrefix(outputLines[2], "tenant-a  invoices") == false {
+		t.Errorf("rows are not aligned: %q", outputLines)
+	}
+	if state := outputState(); state != "OK" {
+		t.Errorf("a listed table exits %s", state)
+	}
+}
+
+func TestListEveryVhost(t *testing.T) {
+	port := testHosts(t, map[string]map[string]string{"127.0.0.1": {
+		"/api/connections": `{"items": [
+			{"name": "c1", "vhost": "/", "user": "guest"},
+			{"name": "c2", "vhost": "orders", "user": "guest"}
+		], "page_count": 1}`,
+		"/api/policies": `[{"name": "ha", "vhost": "orders", "pattern": ".*"}]`,
+	}})
+
+	cases := map[string]int{"connections": 3, "policies": 2}
+	for kind, expected := range cases {
+		lines := runCheck(t, "list", kind, "-h", "127.0.0.1", "--port", port)
+		if len(lines) != expected || strings.HasPrefix(lines[len(lines)-1], "orders") == false {
+			t.Errorf("list %s did not cover every vhost: %q", kind, lines)
+		}
+	}
+}
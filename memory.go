@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// memoryCheckInterval is the number of streamed objects between two looks at the heap
+const memoryCheckInterval = 1000
+
+// maxMemory is the working set budget in bytes from --max-memory, 0 means unlimited
+var maxMemory int64
+
+// errMemoryBudget is returned for responses too large to be decoded whole within the budget
+var errMemoryBudget = errors.New("Response exceeds the memory budget set by --max-memory")
+
+// sizeSuffixes maps the accepted unit suffixes of --max-memory to their multiplier
+var sizeSuffixes = map[string]int64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+}
+
+/*
+parseSize parses a size in bytes with an optional K, M or G suffix
+*/
+func parseSize(str string) (int64, error) {
+	str = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(str)), "B")
+	multiplier := int64(1)
+	for suffix, value := range sizeSuffixes {
+		if strings.HasSuffix(str, suffix) {
+			multiplier = value
+			str = strings.TrimSuffix(str, suffix)
+		}
+	}
+
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, errors.New("A memory size can not be negative")
+	}
+	return size * multiplier, nil
+}
+
+/*
+setMemoryBudget applies --max-memory, also handing it to the garbage collector as a soft limit so it collects
+harder before the budget is reached
+*/
+func setMemoryBudget(str string) error {
+	if str == "" {
+		return nil
+	}
+
+	size, err := parseSize(str)
+	if err != nil {
+		return err
+	}
+	maxMemory = size
+	if maxMemory > 0 {
+		debug.SetMemoryLimit(maxMemory)
+	}
+	return nil
+}
+
+/*
+overMemoryBudget tells whether the live heap has outgrown the budget
+*/
+func overMemoryBudget() bool {
+	if maxMemory == 0 {
+		return false
+	}
+
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return int64(sample[0].Value.Uint64()) > maxMemory
+}
+
+/*
+budgetReader fails with errMemoryBudget once more than the budget has been read from the wrapped reader
+*/
+type budgetReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (budget *budgetReader) Read(buffer []byte) (int, error) {
+	if budget.remaining <= 0 {
+		return 0, errMemoryBudget
+	}
+	if int64(len(buffer)) > budget.remaining {
+		buffer = buffer[:budget.remaining]
+	}
+
+	read, err := budget.reader.Read(buffer)
+	budget.remaining = budget.remaining - int64(read)
+	return read, err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"1024":  1024,
+		"512B":  512,
+		"64K":   64 << 10,
+		"64M":   64 << 20,
+		"64mb":  64 << 20,
+		"64Mb":  64 << 20,
+		"2g":    2 << 30,
+		" 1G ":  1 << 30,
+		"0":     0,
+		"100kb": 100 << 10,
+	}
+	for value, expected := range cases {
+		size, err := parseSize(value)
+		if err != nil || size != expected {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d", value, size, err, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "M", "64X", "-1M", "1.5G"} {
+		if _, err := parseSize(invalid); err == nil {
+			t.Errorf("parseSize(%q) accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// metadataStorePath is the health check of the metadata store, Mnesia or Khepri, on the node answering
+const metadataStorePath = "/api/health/checks/metadata-store/initialized"
+
+/*
+runMetadataStore checks the metadata store of every host. Trouble there blocks declarations cluster wide while
+the message rates and queue lengths still look fine, so it is not caught by the other checks.
+*/
+func runMetadataStore(opt *options, hosts []string) {
+	checkHosts(hosts, func(host string) {
+		processMetadataStore(opt, host)
+	})
+}
+
+/*
+processMetadataStore runs the metadata store health check of a host. Brokers without the check answer 404,
+their store is Mnesia and is checked by listing the nodes, which reads it: every node has to be running.
+*/
+func processMetadataStore(opt *options, host string) {
+	err := apiRequest(opt, host, "GET", metadataStorePath, nil, nil)
+	if err == nil {
+		printLine("OK metadata store of " + host + " is initialized")
+		return
+	}
+	if errorStatus(err) != http.StatusNotFound {
+		printLine("CRITICAL metadata store of " + host + " is unhealthy: " + err.Error())
+		return
+	}
+
+	log.Println(host + " has no metadata store health check, checking the nodes instead")
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		printLine("CRITICAL metadata store of " + host + " could not be read: " + err.Error())
+		return
+	}
+	stopped := 0
+	for _, node := range nodes {
+		if node.Running == false {
+			printLine("CRITICAL metadata store of " + host + " is missing node " + node.Name + ", which is not running")
+			stopped++
+		}
+	}
+	if stopped == 0 {
+		printLine("OK metadata store of " + host + " answers with all " + strconv.Itoa(len(nodes)) + " nodes running")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+expectedMirrors returns the mirrors the ha policy of a classic queue asks for, bounded by the running nodes
+but the one of the leader
+*/
+func expectedMirrors(queue Queue, running int) int {
+	expected := 0
+	switch queue.EffectivePolicyDefinition["ha-mode"] {
+	case "all":
+		expected = running - 1
+	case "exactly":
+		if count, ok := queue.EffectivePolicyDefinition["ha-params"].(float64); ok {
+			expected = int(count) - 1
+		}
+	case "nodes":
+		if nodes, ok := queue.EffectivePolicyDefinition["ha-params"].([]interface{}); ok {
+			expected = len(nodes) - 1
+		}
+	}
+	if expected > running-1 {
+		expected = running - 1
+	}
+	return expected
+}
+
+/*
+runMirrorSync checks the classic queues mirrored by their policy have as many synchronised mirrors as the
+policy asks for. An unsynchronised mirror does not hold the messages of its leader, so a queue without a
+synchronised one loses them when its node fails, whatever the policy says.
+*/
+func runMirrorSync(opt *options, hosts []string) {
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err := processMirrorSync(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the nodes and queues from any host")
+}
+
+/*
+processMirrorSync prints a summary line followed by the mirrored queues short of synchronised mirrors, critical
+without any
+*/
+func processMirrorSync(opt *options, host string) error {
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		return err
+	}
+	running := 0
+	for _, node := range nodes {
+		if node.Running {
+			running++
+		}
+	}
+
+	offending := []queueBreach{}
+	checked, warnings, criticals := 0, 0, 0
+	all := vhostScope(opt, "")
+	columns := "name,vhost,type,node,slave_nodes,synchronised_slave_nodes,effective_policy_definition"
+	_, err = listQueues(all, host, opt.QueuePattern, columns, func(queue Queue) error {
+		if queue.Type != "classic" || mirrored(queue) == false {
+			return nil
+		}
+		checked++
+
+		name := queue.Vhost + "/" + queue.Name
+		expected := expectedMirrors(queue, running)
+		synchronised := len(queue.SynchronisedSlaveNodes)
+		line := ""
+		if len(queue.SlaveNodes) == 0 {
+			line = "CRITICAL mirrored queue " + name + " has no mirror, its messages are only on " + queue.Node
+		} else if synchronised == 0 {
+			line = "CRITICAL mirrored queue " + name + " has none of its " +
+				strconv.Itoa(len(queue.SlaveNodes)) + " mirrors synchronised, its messages are only on " + queue.Node
+		} else if synchronised < expected {
+			synced := map[string]bool{}
+			for _, mirror := range queue.SynchronisedSlaveNodes {
+				synced[mirror] = true
+			}
+			unsynchronised := []string{}
+			for _, mirror := range queue.SlaveNodes {
+				if synced[mirror] == false {
+					unsynchronised = append(unsynchronised, mirror)
+				}
+			}
+			line = "WARNING mirrored queue " + name + " has " + strconv.Itoa(synchronised) + " of " +
+				strconv.Itoa(expected) + " mirrors synchronised, unsynchronised: " + strings.Join(unsynchronised, ",")
+		}
+
+		line = downgradeLine(name, line)
+		switch lineState(line) {
+		case "CRITICAL":
+			criticals++
+		case "WARNING":
+			warnings++
+		default:
+			return nil
+		}
+		offending = append(offending, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(offending, func(i, j int) bool {
+		if offending[i].vhost != offending[j].vhost {
+			return offending[i].vhost < offending[j].vhost
+		}
+		return offending[i].name < offending[j].name
+	})
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	recordPerf("mirrored_queues", float64(checked), "", "", "")
+	recordPerf("mirrored_queues_unsynchronised", float64(warnings+criticals), "", "", "")
+	printLine(state + " " + summaryCounts("mirrored queues", checked, warnings, criticals))
+	for _, breach := range offending {
+		printLine(breach.line)
+	}
+	return nil
+}
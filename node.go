@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"strconv"
+)
+
+/*
+nodeOptions are the options of node mode
+*/
+type nodeOptions struct {
+	NodeWarning  string `long:"node-warning" default:"mem=80,disk=80,fd=80,sockets=80,proc=80" description:"Warning ranges in percent per node in node mode as resource=range pairs over mem, disk, fd, sockets and proc, the erlang processes. The disk is the free disk limit as a share of the free space. The rates per second of context_switches, gc, io_read and io_write take ranges too, e.g. context_switches=200000, and so does run_queue, the processes waiting for a scheduler."`
+	NodeCritical string `long:"node-critical" default:"mem=90,disk=95,fd=90,sockets=90,proc=90" description:"Critical ranges in percent per node in node mode, or per second for the rates and in processes for run_queue."`
+}
+
+// nodeResources are the resources of a node thresholded in node mode, as percentages of their limit
+var nodeResources = []string{"mem", "disk", "fd", "sockets", "proc"}
+
+// nodeRates are the operating system rates of a node, per second, thresholded in node mode when given a range
+var nodeRates = []string{"context_switches", "gc", "io_read", "io_write"}
+
+/*
+usage returns how close a node is to the limit of each resource in percent: memory, descriptors and erlang
+processes against their totals, and the disk as the share of the free space the free disk limit takes, reaching 100 when the
+disk alarm goes off
+*/
+func (node Node) usage() map[string]float64 {
+	percent := func(used, total int) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return float64(used) * 100 / float64(total)
+	}
+
+	disk := 0.0
+	if node.DiskFree > 0 {
+		disk = percent(node.DiskFreeLimit, node.DiskFree)
+	} else if node.DiskFreeLimit > 0 {
+		disk = 100
+	}
+	return map[string]float64{
+		"mem":     percent(node.MemUsed, node.MemLimit),
+		"disk":    disk,
+		"fd":      percent(node.FdUsed, node.FdTotal),
+		"sockets": percent(node.SocketsUsed, node.SocketsTotal),
+		"proc":    percent(node.ProcUsed, node.ProcTotal),
+	}
+}
+
+/*
+rates returns the rates of the node keyed like nodeRates. A context switch rate far above the usual one of the
+node points at scheduler contention on the broker machine, which no queue metric shows.
+*/
+func (node Node) rates() map[string]float64 {
+	return map[string]float64{
+		"context_switches": node.ContextSwitchesDetails.value(),
+		"gc":               node.GCNumDetails.value(),
+		"io_read":          node.IOReadCountDetails.value(),
+		"io_write":         node.IOWriteCountDetails.value(),
+	}
+}
+
+/*
+runNode checks the memory, disk, file descriptors, sockets and processes of every node, and the rates and run
+queue given a range, against --node-warning and --node-critical, printing a line per node
+*/
+func runNode(opt *options, hosts []string) {
+	known := append(append(append([]string{}, nodeResources...), nodeRates...), "run_queue")
+	warning, err := parseRanges(opt.NodeWarning, known)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := parseRanges(opt.NodeCritical, known)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processNode(nodes, warning, critical)
+		return
+	}
+	printLine("UNKNOWN could not read the nodes from any host")
+}
+
+/*
+processNode prints a line per node carrying the worst state of its resources, a stopped node being critical
+*/
+func processNode(nodes []Node, warning, critical map[string]nagiosRange) {
+	for _, node := range nodes {
+		if node.Running == false {
+			line := downgradeLine(node.Name, "CRITICAL node "+node.Name+" is not running")
+			if line != "" {
+				printLine(line)
+			}
+			continue
+		}
+
+		state := "OK"
+		usage := node.usage()
+		details := ""
+		for _, resource := range nodeResources {
+			percent := usage[resource]
+			recordGauge("rabbitmq.node."+resource+"_percent", percent, "node", node.Name)
+			recordPerf(node.Name+"_"+resource, percent, "%", perfRange(warning, resource), perfRange(critical, resource))
+
+			resourceState := rangeState(percent, warning, critical, resource)
+			if resourceState == "CRITICAL" || (resourceState == "WARNING" && state == "OK") {
+				state = resourceState
+			}
+			if details != "" {
+				details = details + ", "
+			}
+			details = details + resource + " " + strconv.FormatFloat(percent, 'f', 1, 64) + "%"
+			if resourceState != "OK" {
+				details = details + " (" + resourceState + ")"
+			}
+		}
+
+		// the rates and the run queue only make the line when thresholded, they are collected and graphed either way
+		optional := func(name string, value float64, shown string) {
+			recordPerf(node.Name+"_"+name, value, "", perfRange(warning, name), perfRange(critical, name))
+			_, hasWarning := warning[name]
+			_, hasCritical := critical[name]
+			if hasWarning == false && hasCritical == false {
+				return
+			}
+			optionalState := rangeState(value, warning, critical, name)
+			if optionalState == "CRITICAL" || (optionalState == "WARNING" && state == "OK") {
+				state = optionalState
+			}
+			details = details + ", " + name + " " + shown
+			if optionalState != "OK" {
+				details = details + " (" + optionalState + ")"
+			}
+		}
+		rates := node.rates()
+		for _, rate := range nodeRates {
+			value := rates[rate]
+			recordGauge("rabbitmq.node."+rate+"_rate", value, "node", node.Name)
+			optional(rate, value, strconv.FormatFloat(value, 'f', 1, 64)+"/s")
+		}
+		recordGauge("rabbitmq.node.run_queue", float64(node.RunQueue), "node", node.Name)
+		optional("run_queue", float64(node.RunQueue), strconv.Itoa(node.RunQueue))
+		if node.Processors > 0 {
+			recordGauge("rabbitmq.node.processors", float64(node.Processors), "node", node.Name)
+			details = details + ", " + strconv.Itoa(node.Processors) + " processors"
+		}
+		if node.OsPid != "" {
+			details = details + ", pid " + node.OsPid
+		}
+
+		line := downgradeLine(node.Name, state+" node "+node.Name+" "+details)
+		if line != "" {
+			printLine(line)
+		}
+	}
+}
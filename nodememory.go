@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+nodeMemoryOptions are the options of node-memory mode
+*/
+type nodeMemoryOptions struct {
+	MemoryCategories string `long:"memory-categories" default:"binary,atom,queue_procs,mgmt_db" description:"The categories of the node memory breakdown reported in node-memory mode."`
+	MemoryWarning    string `long:"memory-warning" description:"Warning sizes per category in node-memory mode as category=size pairs, e.g. binary=2G,atom=64M."`
+	MemoryCritical   string `long:"memory-critical" description:"Critical sizes per category in node-memory mode."`
+	MemoryGrowth     string `long:"memory-growth" description:"Warning and critical thresholds in percent for a category growing since the previous run in node-memory mode, e.g. 50,100."`
+}
+
+// memoryCategories are the categories of /api/nodes/{node}/memory that can be thresholded
+var memoryCategories = []string{"binary", "atom", "code", "queue_procs", "queue_slave_procs", "quorum_queue_procs",
+	"stream_queue_procs", "mgmt_db", "connection_readers", "connection_writers", "connection_channels",
+	"connection_other", "other_ets", "plugins", "metrics", "msg_index", "allocated_unused"}
+
+/*
+NodeMemory is the memory breakdown of a node, in bytes per category
+*/
+type NodeMemory struct {
+	Memory map[string]interface{} `json:"memory"`
+}
+
+/*
+category returns the bytes of a category, 0 when the node does not report it
+*/
+func (memory NodeMemory) category(name string) int64 {
+	value, ok := memory.Memory[name].(float64)
+	if ok == false {
+		return 0
+	}
+	return int64(value)
+}
+
+/*
+sizeRanges parses a comma separated list of category=size pairs such as binary=2G,atom=64M, accepting only
+the given names
+*/
+func sizeRanges(str string, names []string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	if str == "" {
+		return sizes, nil
+	}
+
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, pair := range strings.Split(str, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Expected category=size in " + pair)
+		}
+		name := strings.TrimSpace(parts[0])
+		if known[name] == false {
+			return nil, errors.New("Unknown memory category " + name + ", expected one of " + strings.Join(names, ", "))
+		}
+		size, err := parseSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = size
+	}
+	return sizes, nil
+}
+
+/*
+runNodeMemory checks the memory breakdown of every running node, so an alert names the subsystem eating the
+memory instead of only saying it is high. Categories are thresholded on their size with --memory-warning and
+--memory-critical, and on their growth since the previous run with --memory-growth.
+*/
+func runNodeMemory(opt *options, hosts []string) {
+	categories := strings.Split(opt.MemoryCategories, ",")
+	known := strings.Join(memoryCategories, ",")
+	for _, category := range categories {
+		if strings.Contains(","+known+",", ","+category+",") == false {
+			printUnknown("Unknown memory category " + category + ", expected one of " + strings.Join(memoryCategories, ", "))
+			return
+		}
+	}
+	warning, err := sizeRanges(opt.MemoryWarning, memoryCategories)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := sizeRanges(opt.MemoryCritical, memoryCategories)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	growth, err := optionalLimits(opt.MemoryGrowth)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		breakdowns, err := nodeMemories(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		previous := map[string]map[string]int64{}
+		if growth != nil {
+			err = loadState(opt, "node-memory", &previous)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+		current := processNodeMemory(breakdowns, categories, warning, critical, growth, previous)
+		if growth != nil {
+			err = saveState(opt, "node-memory", current)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the node memory from any host")
+}
+
+/*
+nodeMemories returns the memory breakdown of every running node, keyed by node name. Stopped nodes do not
+answer for their memory and are left to the checks of the node state.
+*/
+func nodeMemories(opt *options, host string) (map[string]NodeMemory, error) {
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdowns := map[string]NodeMemory{}
+	for _, node := range nodes {
+		if node.Running == false {
+			continue
+		}
+		memory := NodeMemory{}
+		err = apiRequest(opt, host, "GET", "/api/nodes/"+url.PathEscape(node.Name)+"/memory", nil, &memory)
+		if err != nil {
+			return nil, err
+		}
+		breakdowns[node.Name] = memory
+	}
+	return breakdowns, nil
+}
+
+/*
+processNodeMemory prints the breaches followed by a line per node within its limits, returning the sizes of
+the categories for the growth check of the next run
+*/
+func processNodeMemory(breakdowns map[string]NodeMemory, categories []string, warning, critical map[string]int64,
+	growth []int, previous map[string]map[string]int64) map[string]map[string]int64 {
+	names := []string{}
+	for name := range breakdowns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	current := map[string]map[string]int64{}
+	lines := []string{}
+	breaches := []string{}
+	for _, name := range names {
+		current[name] = map[string]int64{}
+		nodeBreaches := []string{}
+		sizes := []string{}
+		for _, category := range categories {
+			size := breakdowns[name].category(category)
+			current[name][category] = size
+			recordGauge("rabbitmq.node.memory."+category, float64(size), "node", name)
+			recordPerf(name+"_"+category, float64(size), "B", sizeThreshold(warning, category), sizeThreshold(critical, category))
+			sizes = append(sizes, category+" "+formatBytes(size))
+
+			message := "node " + name + " uses " + formatBytes(size) + " of " + category + " memory"
+			if limit, ok := critical[category]; ok && size >= limit {
+				nodeBreaches = append(nodeBreaches, "CRITICAL "+message)
+			} else if limit, ok := warning[category]; ok && size >= limit {
+				nodeBreaches = append(nodeBreaches, "WARNING "+message)
+			}
+
+			// nodes and categories new since the previous run have nothing to grow from
+			before := previous[name][category]
+			if growth == nil || before <= 0 {
+				continue
+			}
+			percent := int((size - before) * 100 / before)
+			message = "node " + name + " " + category + " memory grew " + strconv.Itoa(percent) + "% since the previous run, from " +
+				formatBytes(before) + " to " + formatBytes(size)
+			if percent >= growth[1] {
+				nodeBreaches = append(nodeBreaches, "CRITICAL "+message)
+			} else if percent >= growth[0] {
+				nodeBreaches = append(nodeBreaches, "WARNING "+message)
+			}
+		}
+
+		nodeBreaches, _, _ = downgradeLines(name, nodeBreaches)
+		breaches = append(breaches, nodeBreaches...)
+		if len(nodeBreaches) == 0 {
+			lines = append(lines, "OK node "+name+" memory "+strings.Join(sizes, ", "))
+		}
+	}
+
+	for _, line := range append(breaches, lines...) {
+		printLine(line)
+	}
+	return current
+}
+
+/*
+sizeThreshold returns the size threshold of a category as perfdata, "" when it has none
+*/
+func sizeThreshold(sizes map[string]int64, category string) string {
+	if size, ok := sizes[category]; ok {
+		return strconv.FormatInt(size, 10)
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+/*
+Node representation from /api/nodes
+*/
+type Node struct {
+	Name         string        `json:"name" required:"true"`
+	Running      bool          `json:"running" required:"true"`
+	ClusterLinks []ClusterLink `json:"cluster_links"`
+	Partitions   []string      `json:"partitions"`
+
+	// MetricsGCQueueLength counts per kind the closed objects whose metrics wait to be garbage collected
+	MetricsGCQueueLength map[string]int `json:"metrics_gc_queue_length"`
+
+	MemUsed       int `json:"mem_used"`
+	MemLimit      int `json:"mem_limit"`
+	DiskFree      int `json:"disk_free"`
+	DiskFreeLimit int `json:"disk_free_limit"`
+	FdUsed        int `json:"fd_used"`
+	FdTotal       int `json:"fd_total"`
+	SocketsUsed   int `json:"sockets_used"`
+	SocketsTotal  int `json:"sockets_total"`
+	ProcUsed      int `json:"proc_used"`
+	ProcTotal     int `json:"proc_total"`
+	Uptime        int `json:"uptime"`
+
+	// RunQueue counts the erlang processes ready to run but waiting for a scheduler
+	RunQueue int `json:"run_queue"`
+
+	// the operating system process of the node and the rates of its scheduler and io
+	OsPid                  string      `json:"os_pid"`
+	Processors             int         `json:"processors"`
+	ContextSwitchesDetails RateDetails `json:"context_switches_details"`
+	GCNumDetails           RateDetails `json:"gc_num_details"`
+	IOReadCountDetails     RateDetails `json:"io_read_count_details"`
+	IOWriteCountDetails    RateDetails `json:"io_write_count_details"`
+
+	// MemAlarm and DiskFreeAlarm are set while the node blocks the publishers over its memory or disk
+	MemAlarm      bool `json:"mem_alarm"`
+	DiskFreeAlarm bool `json:"disk_free_alarm"`
+}
+
+/*
+ClusterLink represents a distribution link from a node to one of its peers
+*/
+type ClusterLink struct {
+	Name     string           `json:"name"`
+	PeerAddr string           `json:"peer_addr"`
+	PeerPort int              `json:"peer_port"`
+	Stats    ClusterLinkStats `json:"stats"`
+}
+
+/*
+ClusterLinkStats are the socket statistics of a distribution link
+*/
+type ClusterLinkStats struct {
+	SendBytes        int         `json:"send_bytes"`
+	SendBytesDetails RateDetails `json:"send_bytes_details"`
+	RecvBytes        int         `json:"recv_bytes"`
+	RecvBytesDetails RateDetails `json:"recv_bytes_details"`
+	SendPend         int         `json:"send_pend"`
+}
+
+/*
+RateDetails represents the *_details substructures carrying the rate of a counter.
+AvgRate and Samples are only sent when a msg_rates_age or lengths_age was requested.
+*/
+type RateDetails struct {
+	Rate    float64           `json:"rate"`
+	AvgRate float64           `json:"avg_rate"`
+	Samples []json.RawMessage `json:"samples"`
+}
+
+/*
+value returns the averaged rate when the api computed one over a requested age, the instant rate otherwise
+*/
+func (details RateDetails) value() float64 {
+	if len(details.Samples) > 0 {
+		return details.AvgRate
+	}
+	return details.Rate
+}
+
+/*
+fetchNodes returns the nodes of the cluster as seen by the host
+*/
+func fetchNodes(opt *options, host string) ([]Node, error) {
+	nodes := []Node{}
+	err := apiRequest(opt, host, "GET", statsPath(opt, "/api/nodes"), nil, &nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		links := node.ClusterLinks
+		sort.Slice(links, func(a, b int) bool { return links[a].Name < links[b].Name })
+	}
+
+	return nodes, nil
+}
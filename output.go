@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// truncationReserve keeps room within --max-output-bytes for the truncation marker
+const truncationReserve = 32
+
+// the exit codes of a nagios plugin
+const (
+	exitOK       = 0
+	exitWarning  = 1
+	exitCritical = 2
+	exitUnknown  = 3
+)
+
+// maxOutputLines and maxOutputBytes are the output limits from the command line, 0 means unlimited
+var maxOutputLines, maxOutputBytes int
+
+// printedLines and printedBytes count what was printed so far, droppedLines what was cut
+var printedLines, printedBytes, droppedLines int
+
+// outputLines keeps every line of the run, including the cut ones, for the hooks
+var outputLines []string
+
+// heldFrom are the indexes of the first lines held back by holdOutput, innermost last, empty when the lines
+// are printed
+var heldFrom []int
+
+var outputMutex sync.Mutex
+
+/*
+printLine prints a line of the plugin output unless the output limits are reached, in which case the line
+is only counted so flushOutput can tell how many were cut. Once a line is cut all the following ones are too,
+keeping the output a prefix of the full one.
+*/
+func printLine(line string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	outputLines = append(outputLines, line)
+	if jsonOutput || len(heldFrom) > 0 || exporter != nil {
+		return
+	}
+
+	size := len(line) + 1
+	if droppedLines > 0 ||
+		(maxOutputLines > 0 && printedLines >= maxOutputLines) ||
+		(maxOutputBytes > 0 && printedBytes+size > maxOutputBytes-truncationReserve) {
+		droppedLines++
+		return
+	}
+
+	fmt.Println(line)
+	printedLines++
+	printedBytes = printedBytes + size
+}
+
+/*
+flushOutput ends the output with a marker telling how many lines were cut by the limits, followed by the perfdata.
+With --output json the whole run is printed as a json document instead.
+*/
+func flushOutput() {
+	if jsonOutput {
+		printReport()
+		return
+	}
+
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	if droppedLines > 0 {
+		fmt.Println("… and " + strconv.Itoa(droppedLines) + " more")
+		droppedLines = 0
+	}
+	if line := perfLine(); line != "" {
+		fmt.Println(line)
+	}
+	perfValues = nil
+}
+
+/*
+resetOutput starts the output of a new round in watch mode, with fresh limits and no recorded lines
+*/
+func resetOutput() {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	printedLines, printedBytes, droppedLines = 0, 0, 0
+	outputLines = nil
+	perfValues = nil
+	perfLabels.seen = map[string]int{}
+}
+
+/*
+holdOutput records the following lines without printing them, until releaseOutput hands them back. The holds
+nest, e.g. the hosts of a cluster are held within the cluster.
+*/
+func holdOutput() {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	heldFrom = append(heldFrom, len(outputLines))
+}
+
+/*
+releaseOutput returns the lines recorded since the latest holdOutput and forgets them, printing the following
+lines again once no hold is left
+*/
+func releaseOutput() []string {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	from := heldFrom[len(heldFrom)-1]
+	held := append([]string{}, outputLines[from:]...)
+	outputLines = outputLines[:from]
+	heldFrom = heldFrom[:len(heldFrom)-1]
+	return held
+}
+
+/*
+printSections prints a summary line over the sections, with the worst state and how many of them are in each
+state, followed by every section headed by a line with its state. Nagios takes the first line as the status of
+the service and the rest as its long output, so the sections read as one result instead of competing lines.
+*/
+func printSections(kind, label string, names []string, sections [][]string) {
+	states := []string{}
+	warnings, criticals, unknowns := 0, 0, 0
+	for _, lines := range sections {
+		state := worstState(lines)
+		states = append(states, state)
+		switch state {
+		case "CRITICAL":
+			criticals++
+		case "WARNING":
+			warnings++
+		case "UNKNOWN":
+			unknowns++
+		}
+	}
+	summary := worstState(states) + " " + summaryCounts(kind, len(sections), warnings, criticals)
+	if unknowns > 0 {
+		summary = summary + ", " + strconv.Itoa(unknowns) + " unknown"
+	}
+	printLine(summary)
+	for index, lines := range sections {
+		printLine(states[index] + " " + label + " " + names[index])
+		for _, line := range lines {
+			printLine(line)
+		}
+	}
+}
+
+/*
+checkHosts runs the check of a mode probing every host on its own, e.g. aliveness or amqps. With several hosts
+their lines are gathered into a section per host under one summary, see printSections.
+*/
+func checkHosts(hosts []string, check func(host string)) {
+	if len(hosts) < 2 {
+		for _, value := range hosts {
+			check(value)
+		}
+		return
+	}
+
+	sections := [][]string{}
+	for _, value := range hosts {
+		holdOutput()
+		check(value)
+		sections = append(sections, releaseOutput())
+	}
+	printSections("hosts", "host", hosts, sections)
+}
+
+/*
+lineState returns the nagios state a line starts with, "" for lines without one
+*/
+func lineState(line string) string {
+	for _, state := range []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"} {
+		if strings.HasPrefix(line, state+" ") || line == state {
+			return state
+		}
+	}
+	return ""
+}
+
+/*
+outputState returns the worst state of the lines printed so far, critical over warning over unknown over ok.
+A run that printed nothing failed before checking anything and is UNKNOWN.
+*/
+func outputState() string {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	return worstState(outputLines)
+}
+
+/*
+worstState returns the worst state of the lines, UNKNOWN when there are none
+*/
+func worstState(lines []string) string {
+	worst := "UNKNOWN"
+	rank := map[string]int{"": -1, "OK": 0, "UNKNOWN": 1, "WARNING": 2, "CRITICAL": 3}
+	if len(lines) > 0 {
+		worst = "OK"
+	}
+	for _, line := range lines {
+		if state := lineState(line); rank[state] > rank[worst] {
+			worst = state
+		}
+	}
+	return worst
+}
+
+/*
+printUnknown reports an error that kept the check from running, e.g. an unreachable api, a response that
+does not parse or an invalid threshold, so the run exits UNKNOWN instead of OK
+*/
+func printUnknown(message string) {
+	printLine("UNKNOWN " + message)
+}
+
+/*
+exitCode maps a nagios state to the exit code of the plugin
+*/
+func exitCode(state string) int {
+	switch state {
+	case "OK":
+		return exitOK
+	case "WARNING":
+		return exitWarning
+	case "CRITICAL":
+		return exitCritical
+	}
+	return exitUnknown
+}
+
+/*
+summaryCounts formats the epilogue of a summary line, e.g. "42 queues checked, 2 warning, 1 critical", so the
+blast radius shows in the notification itself. Modes leaving objects out follow it with the excluded ones.
+*/
+func summaryCounts(kind string, checked, warnings, criticals int) string {
+	return strconv.Itoa(checked) + " " + kind + " checked, " + strconv.Itoa(warnings) + " warning, " +
+		strconv.Itoa(criticals) + " critical"
+}
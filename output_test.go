@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLineState(t *testing.T) {
+	cases := map[string]string{
+		"OK 3 queues checked":           "OK",
+		"WARNING //orders has 10 ready": "WARNING",
+		"CRITICAL node down":            "CRITICAL",
+		"UNKNOWN":                       "UNKNOWN",
+		"OKAY is not a state":           "",
+		"VHOST  NAME":                   "",
+		"":                              "",
+	}
+	for line, expected := range cases {
+		if state := lineState(line); state != expected {
+			t.Errorf("lineState(%q) = %q, expected %q", line, state, expected)
+		}
+	}
+}
+
+func TestWorstState(t *testing.T) {
+	cases := []struct {
+		lines    []string
+		expected string
+	}{
+		{nil, "UNKNOWN"},
+		{[]string{"OK a"}, "OK"},
+		{[]string{"VHOST  NAME", "/      orders"}, "OK"},
+		{[]string{"OK a", "UNKNOWN b"}, "UNKNOWN"},
+		{[]string{"UNKNOWN a", "WARNING b"}, "WARNING"},
+		{[]string{"WARNING a", "CRITICAL b", "OK c"}, "CRITICAL"},
+	}
+	for _, c := range cases {
+		if state := worstState(c.lines); state != c.expected {
+			t.Errorf("worstState(%q) = %q, expected %q", c.lines, state, c.expected)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := map[string]int{"OK": 0, "WARNING": 1, "CRITICAL": 2, "UNKNOWN": 3, "": 3}
+	for state, expected := range cases {
+		if code := exitCode(state); code != expected {
+			t.Errorf("exitCode(%q) = %d, expected %d", state, code, expected)
+		}
+	}
+}
+
+func TestSummaryCounts(t *testing.T) {
+	summary := summaryCounts("vhosts", 4, 1, 2)
+	if summary != "4 vhosts checked, 1 warning, 2 critical" {
+		t.Errorf("summaryCounts = %q", summary)
+	}
+}
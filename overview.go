@@ -1,41 +1,199 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 )
 
+/*
+connectionOptions are the options reaching the management api
+*/
+type connectionOptions struct {
+	Host          []string      `short:"h" long:"host" description:"The host of the rabbitmq server being monitored. For clusters please use all the hostnames in a comma separated list, or repeat the flag: they are treated as one cluster, the first one answering is used and the check only fails when none does." default:"localhost"`
+	Port          string        `short:"P" long:"port" description:"The port on which the server can be accessed." default:"15672"`
+	Username      string        `short:"u" long:"username" env:"RABBITMQ_USER" description:"The username used for accessing rabbitmq web api." default:"guest"`
+	Password      string        `short:"p" long:"password" env:"RABBITMQ_PASSWORD" description:"The password for the account used to access the web api. Prefer the environment variable or --password-file, the command line shows in the process listing." default:"guest"`
+	PasswordFile  string        `long:"password-file" description:"A file holding the password of --username, e.g. a mounted secret, read again when a long running process reloads. It takes precedence over --password."`
+	Secure        bool          `short:"s" long:"secure" description:"Use http or https when accessing the api."`
+	Vhost         string        `long:"vhost" default:"/" description:"The virtual host used when connecting."`
+	Timeout       time.Duration `long:"timeout" default:"10s" description:"How long a request to the management api may take, answer included, before the check gives up on it and reports UNKNOWN."`
+	TLSMinVersion string        `long:"tls-min-version" default:"1.2" description:"The lowest tls version: 1.0, 1.1, 1.2 or 1.3. The amqps listener must not accept older ones in amqps mode, and the connections to the api with --secure do not negotiate them."`
+	TLSInsecure   bool          `long:"tls-insecure" description:"Do not verify the certificate of the api with --secure, e.g. for a self signed one. Prefer --tls-ca-file."`
+	TLSCAFile     string        `long:"tls-ca-file" description:"A pem bundle of the internal ca certificates trusted on top of the system ones."`
+	TLSCertFile   string        `long:"tls-cert-file" description:"A pem client certificate presented to brokers requiring one, with --tls-key-file."`
+	TLSKeyFile    string        `long:"tls-key-file" description:"The pem private key of --tls-cert-file."`
+}
+
+/*
+thresholdOptions are the thresholds and filters shared by the checks
+*/
+type thresholdOptions struct {
+	Warning         string        `short:"w" long:"warning" default:"10000,10000" description:"Threshold for warnings."`
+	Critical        string        `short:"c" long:"critical" default:"50000,50000" description:"Threshold for critical."`
+	BytesWarning    string        `long:"bytes-warning" description:"Warning thresholds for the message bytes in total, ready and unacknowledged, e.g. 1G,800M,200M, in overview and queues mode."`
+	BytesCritical   string        `long:"bytes-critical" description:"Critical thresholds for the message bytes in total, ready and unacknowledged."`
+	RatesWarning    string        `long:"rates-warning" description:"Warning ranges for the cluster wide message rates in rates mode as rate=range pairs, e.g. publish=10:,ack=10: to alert when traffic drops."`
+	RatesCritical   string        `long:"rates-critical" description:"Critical ranges for the message rates in rates mode, the rates are publish, deliver_get, ack and confirm in msgs/sec, and publish_in and publish_out for the exchanges."`
+	CertExpiry      string        `long:"cert-expiry" default:"30,7" description:"Warning and critical thresholds in days before the certificate expires."`
+	QueuePattern    string        `long:"queue-pattern" description:"A regular expression, evaluated by the server, selecting the queues checked in queues, drain-time, throughput, ttl-audit, quorum and mirror-sync mode."`
+	QueueVhost      string        `long:"queue-vhost" description:"The vhost whose queues are checked in queues, drain-time and throughput mode and listed by list queues, all vhosts when empty as by default. --vhost, the vhost the other checks use, does not narrow them."`
+	IncludeQueues   []string      `long:"include-queues" description:"Check only the queues whose vhost/name matches one of these regular expressions in queues mode, or shell globs when prefixed with glob:, e.g. glob://orders.*. Repeatable."`
+	ExcludePolicy   []string      `long:"exclude-policy" description:"Leave out the queues the policy of this name applies to in queues mode, e.g. transient-ok. Repeatable."`
+	ExcludeArgument []string      `long:"exclude-argument" description:"Leave out the queues declared with this argument in queues mode, either a key like x-queue-mode or a key and its value like x-queue-mode=lazy. Repeatable."`
+	ExcludeQueues   []string      `long:"exclude-queues" description:"Leave out the queues whose vhost/name matches one of these patterns in queues mode, counted as excluded in the summary. Repeatable."`
+	MaxSeverity     []string      `long:"max-severity" description:"The most severe state the breaches of a metric reach, e.g. unacked=warning to never go critical over unacknowledged messages, in overview, queue and queues mode, metric=critical lifting the cap. Repeat for every metric: ready, unacked, bytes, bytes-ready or bytes-unacked."`
+	QueueThreshold  []string      `long:"queue-threshold" description:"Warning and critical limits for the ready and unacknowledged messages of the queues matching a pattern in queues mode, e.g. orders.*:1000,5000. Repeatable, the first matching one applies; the thresholds queues declare themselves with --queue-thresholds take precedence."`
+	QueueThresholds bool          `long:"queue-thresholds" description:"Let queues override --warning and --critical with x-monitoring-warning and x-monitoring-critical arguments, or monitoring-warning and monitoring-critical keys of their policy."`
+	DowngradeFile   string        `long:"downgrade-file" description:"A file of known-noisy queues and nodes whose breaches are capped at WARNING or suppressed until a date, one pattern, action (warning or suppress) and expiry date per line."`
+	MaxStatsAge     time.Duration `long:"max-stats-age" description:"Report UNKNOWN instead of checking the statistics when their newest sample is older than this, e.g. 2m."`
+}
+
+/*
+collectionOptions are how the statistics are read from the api
+*/
+type collectionOptions struct {
+	Source         string        `long:"source" default:"management" description:"Where the overview is read from: management (the web api) or prometheus (the rabbitmq_prometheus endpoints of every node given with --host, summed)."`
+	PrometheusPort string        `long:"prometheus-port" default:"15692" description:"The port of the rabbitmq_prometheus endpoint used with --source=prometheus."`
+	PageSize       int           `long:"page-size" default:"500" description:"The number of objects requested per page when listing large collections such as queues."`
+	Concurrency    int           `long:"concurrency" default:"4" description:"The number of requests sent to the api at the same time, e.g. when fetching pages of a listing or polling every host of --host."`
+	MaxMemory      string        `long:"max-memory" description:"Budget for the working set, e.g. 64M. Listings beyond it are evaluated as aggregates only and larger responses are refused."`
+	CacheTTL       time.Duration `long:"cache-ttl" description:"How long a parsed response is reused when the same payload is fetched again in watch mode or with --listen, e.g. 10s. Off by default, a single run reads every response once."`
+	MsgRatesAge    int           `long:"msg-rates-age" description:"Seconds of message rate history the api averages rates over, instead of the instant rate."`
+	MsgRatesIncr   int           `long:"msg-rates-incr" default:"10" description:"Seconds between the message rate samples used with --msg-rates-age."`
+	LengthsAge     int           `long:"lengths-age" description:"Seconds of queue length history the api averages over."`
+	LengthsIncr    int           `long:"lengths-incr" default:"10" description:"Seconds between the queue length samples used with --lengths-age."`
+	Strict         bool          `long:"strict" description:"Report UNKNOWN when the api leaves out a field the checks read, as a much older or newer rabbitmq may, instead of evaluating it as zero."`
+}
+
+/*
+outputOptions are how the result of a check is printed
+*/
+type outputOptions struct {
+	Output         string `long:"output" default:"nagios" description:"The output format: nagios text, or json for automation with the state, the lines, the perfdata with its thresholds and the values collected per host. List mode also prints zabbix-lld, a low-level discovery document, and zabbix-value, the --item value of one object."`
+	Item           string `long:"item" description:"The column printed with --output=zabbix-value, e.g. ready or mem_used."`
+	MaxOutputLines int    `long:"max-output-lines" description:"Cut the output after this many lines, ending it with a marker telling how many more there were."`
+	MaxOutputBytes int    `long:"max-output-bytes" description:"Cut the output before it grows beyond this many bytes, e.g. to stay within the NRPE limits."`
+	PerfLabels     string `long:"perf-labels" default:"safe" description:"How names become perfdata labels: safe replaces the characters graphing tools choke on, quote keeps them quoted."`
+	PerfLabelMax   int    `long:"perf-label-max" default:"64" description:"Longer perfdata labels are shortened and suffixed with a hash of the name, 0 keeps them whole."`
+}
+
+/*
+integrationOptions are what a run hands its result to besides nagios
+*/
+type integrationOptions struct {
+	StateDir        string `long:"state-dir" description:"The directory keeping the state of previous runs, e.g. for the hooks. Defaults to the system temporary directory."`
+	OnCritical      string `long:"on-critical" description:"A shell command run when the state changes to CRITICAL, with the check context in CHECK_RABBITMQ_* environment variables."`
+	OnWarning       string `long:"on-warning" description:"A shell command run when the state changes to WARNING."`
+	OnRecovery      string `long:"on-recovery" description:"A shell command run when the state changes from WARNING or CRITICAL back to OK."`
+	ResultLog       string `long:"result-log" description:"A file every run appends a json line to, with the time, mode, state and collected values, e.g. /var/log/check_rabbitmq.jsonl."`
+	OtlpEndpoint    string `long:"otlp-endpoint" description:"Base url of an otlp/http collector, e.g. http://localhost:4318, receiving a span per api call and gauges of the collected values."`
+	OtlpServiceName string `long:"otlp-service-name" default:"check_rabbitmq" description:"The service.name of the exported telemetry."`
+}
+
+/*
+residentOptions are the options of a process repeating the checks
+*/
+type residentOptions struct {
+	Watch           time.Duration `long:"watch" description:"Keep running and repeat the check at this interval, e.g. 1m, printing the output of every round."`
+	Webhook         string        `long:"webhook" description:"In watch mode, a url receiving a json POST whenever the state of the check changes."`
+	WebhookDebounce time.Duration `long:"webhook-debounce" description:"How long a new state has to hold before the webhook is told, so flapping checks do not flood it."`
+	Listen          string        `long:"listen" description:"Stay resident as a prometheus exporter serving the values collected by the --export-modes checks on /metrics at this address, e.g. :9419, instead of printing a check result."`
+	ExportModes     string        `long:"export-modes" default:"overview,queues,node" description:"The comma separated checks run every --poll-interval with --listen."`
+	PollInterval    time.Duration `long:"poll-interval" default:"30s" description:"How often the api is polled with --listen."`
+}
+
+/*
+options are the options of a run. The shared ones are grouped by topic under their own heading of the help, the
+ones of a single check only show in the help of its command.
+*/
 type options struct {
-	Host     string `short:"h" long:"host" description:"The host of the rabbitmq server being monitored. For clusters please use all the hostnames in a comma separated list." default:"localhost"`
-	Port     string `short:"P" long:"port" description:"The port on which the server can be accessed." default:"15672"`
-	Username string `short:"u" long:"username" description:"The username used for accessing rabbitmq web api." default:"guest"`
-	Password string `short:"p" long:"password" description:"The password for the account used to access the web api." default:"guest"`
-	Warning  string `short:"w" long:"warning" default:"10000,10000" description:"Threshold for warnings."`
-	Critical string `short:"c" long:"critical" default:"50000,50000" description:"Threshold for critical."`
-	Secure   bool   `short:"s" long:"secure" default:"false" description:"Use http or https when accessing the api."`
+	Config    string `long:"config" env:"CHECK_RABBITMQ_CONFIG" no-ini:"true" description:"An ini file, or a yaml one ending in .yaml or .yml, of options keyed by their long names, e.g. to keep the password off the command line. Flags given on the command line override it; in watch mode it is reloaded when it changes or on SIGHUP. Sections named cluster:<name>, or a clusters mapping in yaml, hold the hosts and credentials of independent clusters all checked in one run."`
+	Profile   string `long:"profile" env:"CHECK_RABBITMQ_PROFILE" no-ini:"true" description:"A profile of the --config file, a section named profile:<name> or an entry of the profiles mapping in yaml, whose hosts, credentials, thresholds and filters apply over the rest of the file, e.g. prod-eu."`
+	Mode      string `short:"m" long:"mode" default:"overview" description:"The check to run, one of the commands listed below. Giving it as the command is preferred, e.g. check_rabbitmq queue --queue orders, as the options of a check follow its command."`
+	Help      bool   `long:"help" description:"Show this help, or the options and an example of a check when given after its command."`
+	PprofCPU  string `long:"pprof-cpu" hidden:"true" description:"Write a cpu profile of the run to this file."`
+	PprofHeap string `long:"pprof-heap" hidden:"true" description:"Write a heap profile at the end of the run to this file."`
+
+	// the options shared by every check, grouped for the help, see sharedGroups
+	connectionOptions  `no-flag:"true"`
+	thresholdOptions   `no-flag:"true"`
+	collectionOptions  `no-flag:"true"`
+	outputOptions      `no-flag:"true"`
+	integrationOptions `no-flag:"true"`
+	residentOptions    `no-flag:"true"`
+
+	// the options read by a single check, given after its command, see checkCommands
+	totalsOptions       `no-flag:"true"`
+	protocolOptions     `no-flag:"true"`
+	tuningOptions       `no-flag:"true"`
+	clientOptions       `no-flag:"true"`
+	rateOptions         `no-flag:"true"`
+	latencyOptions      `no-flag:"true"`
+	queueOptions        `no-flag:"true"`
+	nodeOptions         `no-flag:"true"`
+	statsDbOptions      `no-flag:"true"`
+	nodeMemoryOptions   `no-flag:"true"`
+	topicBindingOptions `no-flag:"true"`
+	drainOptions        `no-flag:"true"`
+	throughputOptions   `no-flag:"true"`
+	exchangeOptions     `no-flag:"true"`
+	channelOptions      `no-flag:"true"`
+	transactionOptions  `no-flag:"true"`
+	prefetchOptions     `no-flag:"true"`
+	federationOptions   `no-flag:"true"`
+	storeOptions        `no-flag:"true"`
+	alarmsOptions       `no-flag:"true"`
+	quorumOptions       `no-flag:"true"`
+	clusterSizeOptions  `no-flag:"true"`
+	queueTypeOptions    `no-flag:"true"`
+	capacityOptions     `no-flag:"true"`
+	consumerOptions     `no-flag:"true"`
+	vhostOptions        `no-flag:"true"`
+	websocketOptions    `no-flag:"true"`
+	amqpsOptions        `no-flag:"true"`
+	canaryOptions       `no-flag:"true"`
+	probeOptions        `no-flag:"true"`
+	benchOptions        `no-flag:"true"`
+	distributionOptions `no-flag:"true"`
+	epmdOptions         `no-flag:"true"`
+	clusterLinkOptions  `no-flag:"true"`
+	upgradeOptions      `no-flag:"true"`
+	ttlOptions          `no-flag:"true"`
+	wiringOptions       `no-flag:"true"`
+
+	// clusters are the cluster sections of the --config file, cluster the one these options were read for
+	clusters []string
+	cluster  string
 }
 
 /*
 Overview representation from the api
 */
 type Overview struct {
-	QueueTotals QueueTotals `json:"queue_totals"`
+	QueueTotals       QueueTotals  `json:"queue_totals"`
+	ObjectTotals      ObjectTotals `json:"object_totals"`
+	MessageStats      MessageStats `json:"message_stats"`
+	DisableStats      bool         `json:"disable_stats"`
+	EnableQueueTotals bool         `json:"enable_queue_totals"`
+
+	// StatisticsDbEventQueue counts the stats events waiting to be processed by the management database
+	StatisticsDbEventQueue int    `json:"statistics_db_event_queue"`
+	StatisticsDbNode       string `json:"statistics_db_node"`
 }
 
 /*
 QueueTotals represents the queue_totals substructure
 */
 type QueueTotals struct {
-	MessagesUnack int `json:"messages_unacknowledged"`
-	MessagesReady int `json:"messages_ready"`
+	MessagesUnack int `json:"messages_unacknowledged" required:"true"`
+	MessagesReady int `json:"messages_ready" required:"true"`
 }
 
 /*
@@ -61,38 +219,63 @@ func limitMap(str string) ([]int, error) {
 }
 
 /*
-processHost processes the host and returns the overview from it
+splitHosts flattens the --host values into a list of hosts. Every value may be a comma separated list,
+commas inside brackets are kept and the brackets of IPv6 addresses are removed.
 */
-func processHost(opt *options, host string) (*Overview, error) {
-	prefix := "http"
-	if opt.Secure == true {
-		prefix = "https"
-	}
-	uri := prefix + "://" + host + ":" + opt.Port + "/api/overview"
-	client := http.Client{}
+func splitHosts(values []string) []string {
+	hosts := []string{}
+	for _, value := range values {
+		depth := 0
+		start := 0
+		for i := 0; i <= len(value); i++ {
+			if i < len(value) {
+				switch value[i] {
+				case '[':
+					depth++
+					continue
+				case ']':
+					depth--
+					continue
+				case ',':
+					if depth > 0 {
+						continue
+					}
+				default:
+					continue
+				}
+			}
 
-	request, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		return nil, err
-	}
-	request.SetBasicAuth(opt.Username, opt.Password)
-	response, err := client.Do(request)
-	if err != nil {
-		return nil, err
+			host := strings.TrimSpace(value[start:i])
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+			start = i + 1
+		}
 	}
 
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+	return hosts
+}
+
+/*
+processHost processes the host and returns the overview from it
+*/
+func processHost(opt *options, host string) (*Overview, error) {
+	if opt.Source == "prometheus" {
+		return prometheusOverview(opt, host)
 	}
 
 	over := &Overview{}
-	err = json.Unmarshal(body, &over)
+	err := apiRequest(opt, host, "GET", statsPath(opt, "/api/overview"), nil, over)
 	if err != nil {
 		return nil, err
 	}
 
+	// with disable_stats the queue totals are simply left out, reading them would report zeros
+	if over.DisableStats && over.EnableQueueTotals == false {
+		return statslessOverview(opt, host)
+	}
+
 	return over, nil
 }
 
@@ -104,50 +287,365 @@ func processOverview(over *Overview, warning, critical []int) {
 
 	// check errors first
 	if over.QueueTotals.MessagesReady >= critical[0] {
-		fmt.Println("CRITICAL " + rdy + " messages ready")
+		printLine(capSeverity("ready", "CRITICAL "+rdy+" messages ready"))
 	} else if over.QueueTotals.MessagesReady >= warning[0] {
-		fmt.Println("WARNING " + rdy + " messages ready")
+		printLine("WARNING " + rdy + " messages ready")
 	} else {
-		fmt.Println("OK " + rdy + " messages ready")
+		printLine("OK " + rdy + " messages ready")
 	}
 
 	if over.QueueTotals.MessagesUnack >= critical[1] {
-		fmt.Println("CRITICAL " + unack + " messages unacknowledged")
+		printLine(capSeverity("unacked", "CRITICAL "+unack+" messages unacknowledged"))
 	} else if over.QueueTotals.MessagesUnack >= warning[1] {
-		fmt.Println("WARNING " + unack + " messages unacknowledged")
+		printLine("WARNING " + unack + " messages unacknowledged")
 	} else {
-		fmt.Println("OK " + unack + " messages unacknowledged")
+		printLine("OK " + unack + " messages unacknowledged")
 	}
 
+	recordPerf("messages_ready", float64(over.QueueTotals.MessagesReady), "", perfLimit(warning, 0), perfLimit(critical, 0))
+	recordPerf("messages_unacknowledged", float64(over.QueueTotals.MessagesUnack), "", perfLimit(warning, 1), perfLimit(critical, 1))
 }
 
-func main() {
-	opt := &options{}
-	_, err := flags.Parse(opt)
-	if err != nil {
+/*
+runOverview checks the queue totals of the cluster from the overview of the first host answering, a host being
+down does not fail the check while another one answers. With --source=prometheus the hosts are the nodes of the
+cluster, its totals the sum over all of them, and every one has to answer.
+*/
+func runOverview(opt *options, hosts []string) {
+	if opt.Source != "management" && opt.Source != "prometheus" {
+		printUnknown("Unknown source " + opt.Source)
 		return
 	}
 
 	warningLimits, err := limitMap(opt.Warning)
 	if err != nil {
-		log.Println(err.Error())
+		printUnknown(err.Error())
 		return
 	}
 
 	criticalLimits, err := limitMap(opt.Critical)
 	if err != nil {
-		log.Println(err.Error())
+		printUnknown(err.Error())
 		return
 	}
-	hosts := strings.Split(opt.Host, ",")
 
-	// loop through all hosts and check if we can access the overview page
-	for _, value := range hosts {
-		over, err := processHost(opt, value)
+	bytesWarning, bytesCritical, err := bytesOptions(opt)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// the hosts are asked at the same time, so the unreachable ones cost a single timeout rather than one each
+	overviews := make([]*Overview, len(hosts))
+	sizes := make([]MessageBytes, len(hosts))
+	errs := eachHost(opt, hosts, func(index int, host string) error {
+		over, err := processHost(opt, host)
 		if err != nil {
-			log.Println(err.Error())
+			return err
+		}
+		overviews[index] = over
+		if bytesWarning != nil {
+			sizes[index], err = overviewBytes(opt, host)
+		}
+		return err
+	})
+
+	// rabbitmq_prometheus only reports the queues of its own node, the thresholds hold for the sum over every
+	// node and a node not answering would leave it short
+	if opt.Source == "prometheus" {
+		over, size := &Overview{}, MessageBytes{}
+		failed := []string{}
+		for index, value := range hosts {
+			if errs[index] != nil {
+				log.Println(errs[index].Error())
+				failed = append(failed, value)
+				continue
+			}
+			recordTotals(value, overviews[index], sizes[index], bytesWarning != nil)
+			over.QueueTotals.MessagesReady = over.QueueTotals.MessagesReady + overviews[index].QueueTotals.MessagesReady
+			over.QueueTotals.MessagesUnack = over.QueueTotals.MessagesUnack + overviews[index].QueueTotals.MessagesUnack
+			size.Total, size.Ready, size.Unack = size.Total+sizes[index].Total, size.Ready+sizes[index].Ready, size.Unack+sizes[index].Unack
+		}
+		if len(failed) > 0 {
+			printLine("UNKNOWN could not read the prometheus metrics of " + strings.Join(failed, ", ") + ", the cluster totals would fall short")
 			return
 		}
-		processOverview(over, warningLimits, criticalLimits)
+		processTotals(over, size, warningLimits, criticalLimits, bytesWarning, bytesCritical)
+		return
+	}
+
+	// every host of the management api reports the same cluster, the first one answering is enough
+	for index, value := range hosts {
+		if errs[index] != nil {
+			log.Println(errs[index].Error())
+			continue
+		}
+		recordTotals(value, overviews[index], sizes[index], bytesWarning != nil)
+		processTotals(overviews[index], sizes[index], warningLimits, criticalLimits, bytesWarning, bytesCritical)
+		return
+	}
+	printLine("UNKNOWN could not read the overview from any host")
+}
+
+/*
+recordTotals records the queue totals read from the host as telemetry gauges, the message bytes when read
+*/
+func recordTotals(host string, over *Overview, size MessageBytes, bytes bool) {
+	recordGauge("rabbitmq.queue_totals.messages_ready", float64(over.QueueTotals.MessagesReady), "host", host)
+	recordGauge("rabbitmq.queue_totals.messages_unacknowledged", float64(over.QueueTotals.MessagesUnack), "host", host)
+	if bytes {
+		recordGauge("rabbitmq.queue_totals.message_bytes", float64(size.Total), "host", host)
+		recordGauge("rabbitmq.queue_totals.message_bytes_ready", float64(size.Ready), "host", host)
+		recordGauge("rabbitmq.queue_totals.message_bytes_unacknowledged", float64(size.Unack), "host", host)
 	}
 }
+
+/*
+processTotals thresholds the message counts of the cluster and, with --bytes-warning, its message bytes
+*/
+func processTotals(over *Overview, size MessageBytes, warning, critical []int, bytesWarning, bytesCritical []int64) {
+	processOverview(over, warning, critical)
+	if bytesWarning == nil {
+		return
+	}
+
+	for _, line := range evaluateBytes("", size, bytesWarning, bytesCritical) {
+		printLine(line)
+	}
+	for position, value := range []int64{size.Total, size.Ready, size.Unack} {
+		recordPerf(bytesPerfNames[position], float64(value), "B",
+			strconv.FormatInt(bytesWarning[position], 10), strconv.FormatInt(bytesCritical[position], 10))
+	}
+}
+
+/*
+runChecks runs the check once, against every cluster of the config file when it lists several
+*/
+func runChecks(opt *options, hosts []string, args []string) {
+	if len(opt.clusters) > 0 {
+		runClusters(opt, args)
+		return
+	}
+	runMode(opt, hosts, args)
+}
+
+/*
+runMode runs the check selected by --mode once against the hosts
+*/
+func runMode(opt *options, hosts []string, args []string) {
+	defer reportFailures()
+
+	if opt.MaxStatsAge > 0 && statsModes[opt.Mode] {
+		if reason := staleStats(opt, hosts); reason != "" {
+			printUnknown(reason)
+			return
+		}
+	}
+
+	switch opt.Mode {
+	case "overview":
+		runOverview(opt, hosts)
+	case "queues":
+		runQueues(opt, hosts)
+	case "queue":
+		runQueue(opt, hosts)
+	case "object-totals":
+		runObjectTotals(opt, hosts)
+	case "protocols":
+		runProtocols(opt, hosts)
+	case "connection-tuning":
+		runConnectionTuning(opt, hosts)
+	case "clients":
+		runClients(opt, hosts)
+	case "rates":
+		runRates(opt, hosts)
+	case "vhost":
+		runVhosts(opt, hosts)
+	case "exchanges":
+		runExchanges(opt, hosts)
+	case "exchange-wiring":
+		runExchangeWiring(opt, hosts)
+	case "topic-bindings":
+		runTopicBindings(opt, hosts)
+	case "consumers":
+		runConsumers(opt, hosts)
+	case "channels":
+		runChannels(opt, hosts)
+	case "transactions":
+		runTransactions(opt, hosts)
+	case "prefetch":
+		runPrefetch(opt, hosts)
+	case "queue-types":
+		runQueueTypes(opt, hosts)
+	case "ttl-audit":
+		runTTLAudit(opt, hosts)
+	case "drain-time":
+		runDrainTime(opt, hosts)
+	case "throughput":
+		runThroughput(opt, hosts)
+	case "node":
+		runNode(opt, hosts)
+	case "federation":
+		runFederation(opt, hosts)
+	case "partitions":
+		runPartitions(opt, hosts)
+	case "stats-db":
+		runStatsDb(opt, hosts)
+	case "alarms":
+		runAlarms(opt, hosts)
+	case "cluster-size":
+		runClusterSize(opt, hosts)
+	case "mirror-sync":
+		runMirrorSync(opt, hosts)
+	case "quorum":
+		runQuorum(opt, hosts)
+	case "store-growth":
+		runStoreGrowth(opt, hosts)
+	case "node-capacity":
+		runNodeCapacity(opt, hosts)
+	case "node-memory":
+		runNodeMemory(opt, hosts)
+	case "api-latency":
+		runAPILatency(opt, hosts)
+	case "aliveness":
+		runAliveness(opt, hosts)
+	case "metadata-store":
+		runMetadataStore(opt, hosts)
+	case "websocket":
+		checkHosts(hosts, func(host string) {
+			processWebSocket(opt, host)
+		})
+	case "amqps":
+		runAmqps(opt, hosts)
+	case "canary":
+		runCanary(opt, hosts)
+	case "probe":
+		runProbe(opt, hosts)
+	case "bench":
+		runBench(opt, hosts)
+	case "distribution":
+		runDistribution(opt, hosts)
+	case "cluster-links":
+		runClusterLinks(opt, hosts)
+	case "health-all":
+		runHealthAll(opt, hosts)
+	case "upgrade-ready":
+		runUpgradeReady(opt, hosts)
+	case "list":
+		runList(opt, hosts, args)
+	case "doctor":
+		runDoctor(opt, hosts)
+	case "epmd":
+		checkHosts(hosts, func(host string) {
+			processEpmd(opt, host)
+		})
+	default:
+		printUnknown("Unknown mode " + opt.Mode)
+	}
+}
+
+/*
+applyOptions puts parsed options into effect, returning the hosts and the arguments left for the mode. It runs
+again when a long running process reloads its configuration.
+*/
+func applyOptions(opt *options, args []string) ([]string, []string, error) {
+	hosts := splitHosts(opt.Host)
+
+	if opt.Output != "nagios" && opt.Output != "json" && opt.Output != "zabbix-lld" && opt.Output != "zabbix-value" {
+		return nil, nil, errors.New("Unknown output " + opt.Output + ", expected nagios, json, zabbix-lld or zabbix-value")
+	}
+	jsonOutput, reportMode, reportHosts = opt.Output == "json", opt.Mode, hosts
+
+	maxOutputLines, maxOutputBytes = opt.MaxOutputLines, opt.MaxOutputBytes
+	strictDecoding = opt.Strict
+	perfLabels = newPerfLabeler(opt)
+	httpClient.Timeout = opt.Timeout
+	probeTimeout = opt.Timeout
+
+	err := readPasswordFile(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = configureTLS(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = loadDowngrades(opt.DowngradeFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = loadMaxSeverities(opt.MaxSeverity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hosts, args, nil
+}
+
+/*
+run runs the check and returns the nagios exit code of the worst state it reported, the deferred flushes and
+exports still running before the process exits
+*/
+func run() int {
+	opt, args, parser, err := parseOptions(os.Args[1:])
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			fmt.Println(err.Error())
+			return exitOK
+		}
+
+		// nagios only reads stdout and the exit code, a bare failure would be recorded as OK
+		fmt.Println("UNKNOWN invalid arguments: " + strings.SplitN(err.Error(), "\n", 2)[0])
+		parser.WriteHelp(os.Stderr)
+		return exitUnknown
+	}
+
+	hosts, args, err := applyOptions(opt, args)
+	if err != nil {
+		printUnknown(err.Error())
+		return exitCode(outputState())
+	}
+	defer flushOutput()
+
+	err = setMemoryBudget(opt.MaxMemory)
+	if err != nil {
+		printUnknown(err.Error())
+		return exitCode(outputState())
+	}
+
+	stopProfiles, err := startProfiles(opt)
+	if err != nil {
+		printUnknown(err.Error())
+		return exitCode(outputState())
+	}
+	defer stopProfiles()
+
+	if opt.Listen != "" {
+		runExporter(opt, hosts, args)
+		return exitCode(outputState())
+	}
+	if opt.Watch > 0 {
+		runWatch(opt, hosts, args)
+		return exitCode(outputState())
+	}
+
+	start := time.Now()
+	startTelemetry(opt)
+	defer exportTelemetry(opt)
+	startResultLog(opt)
+
+	runChecks(opt, hosts, args)
+	runHooks(opt)
+
+	err = writeResultLog(opt, start)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return exitCode(outputState())
+}
+
+func main() {
+	os.Exit(run())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLimitMap(t *testing.T) {
+	limits, err := limitMap("10000,500")
+	if err != nil || reflect.DeepEqual(limits, []int{10000, 500}) == false {
+		t.Errorf("limitMap(10000,500) = %v, %v", limits, err)
+	}
+
+	for _, invalid := range []string{"", "10", "1,2,3", "a,1", "1,"} {
+		if _, err := limitMap(invalid); err == nil {
+			t.Errorf("limitMap(%q) accepted", invalid)
+		}
+	}
+}
+
+func TestPrometheusOverviewSumsTheNodes(t *testing.T) {
+	metrics := "# TYPE rabbitmq_queue_messages_ready gauge\nrabbitmq_queue_messages_ready 20000\nrabbitmq_queue_messages_unacked 5\n"
+	port := testHosts(t, map[string]map[string]string{
+		"127.0.0.1": {"/metrics": metrics},
+		"127.0.0.2": {"/metrics": metrics},
+		"127.0.0.3": {"/metrics": metrics},
+	})
+	arguments := []string{"overview", "--source", "prometheus", "--port", port, "--prometheus-port", port, "--warning", "30000,100", "--critical", "50000,200"}
+
+	lines := runCheck(t, append(arguments, "-h", "127.0.0.1,127.0.0.2,127.0.0.3")...)
+	expected := []string{"CRITICAL 60000 messages ready", "OK 15 messages unacknowledged"}
+	if reflect.DeepEqual(lines, expected) == false {
+		t.Errorf("three nodes of 20000 ready read %q, expected %q", lines, expected)
+	}
+
+	lines = runCheck(t, append(arguments, "-h", "127.0.0.1,127.0.0.2,127.0.0.4")...)
+	if len(lines) != 1 || lines[0] != "UNKNOWN could not read the prometheus metrics of 127.0.0.4, the cluster totals would fall short" {
+		t.Errorf("a node not answering reads %q", lines)
+	}
+}
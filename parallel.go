@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+)
+
+/*
+parallel runs task for every index in [0, count) on at most limit goroutines and waits for all of them.
+It returns the first error reported by a task, the remaining tasks still run to completion.
+*/
+func parallel(limit, count int, task func(index int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	indexes := make(chan int)
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	var first error
+
+	for worker := 0; worker < limit && worker < count; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range indexes {
+				err := task(index)
+				if err != nil {
+					mutex.Lock()
+					if first == nil {
+						first = err
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for index := 0; index < count; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wait.Wait()
+
+	return first
+}
+
+/*
+eachHost runs fetch for every host on at most --concurrency goroutines and returns the error of every host, in
+the order of the hosts. Printing the results afterwards in that order keeps the output the same as polling the
+hosts one after the other, while a long host list takes about as long as its slowest host.
+*/
+func eachHost(opt *options, hosts []string, fetch func(index int, host string) error) []error {
+	errs := make([]error, len(hosts))
+	parallel(opt.Concurrency, len(hosts), func(index int) error {
+		errs[index] = fetch(index, hosts[index])
+		return nil
+	})
+	return errs
+}
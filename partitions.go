@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+runPartitions goes critical as soon as a node reports a network partition, listing the peers it is cut off
+from. A partitioned cluster does not report the same thing from every host, the side a host is on may not
+even know the other side is partitioned, so the reports of all the hosts answering are merged.
+*/
+func runPartitions(opt *options, hosts []string) {
+	partitions := map[string]map[string]bool{}
+	nodes := map[string]bool{}
+	answered := 0
+	reports := make([][]Node, len(hosts))
+	errs := eachHost(opt, hosts, func(index int, host string) error {
+		var err error
+		reports[index], err = fetchNodes(opt, host)
+		return err
+	})
+	for index := range hosts {
+		if errs[index] != nil {
+			log.Println(errs[index].Error())
+			continue
+		}
+		answered++
+
+		for _, node := range reports[index] {
+			nodes[node.Name] = true
+			for _, peer := range node.Partitions {
+				if partitions[node.Name] == nil {
+					partitions[node.Name] = map[string]bool{}
+				}
+				partitions[node.Name][peer] = true
+			}
+		}
+	}
+	if answered == 0 {
+		printLine("UNKNOWN could not read the nodes from any host")
+		return
+	}
+
+	processPartitions(partitions, len(nodes), answered)
+}
+
+/*
+processPartitions prints a critical line per node reporting a partition, or a single ok line
+*/
+func processPartitions(partitions map[string]map[string]bool, nodes, answered int) {
+	names := []string{}
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		peers := []string{}
+		for peer := range partitions[name] {
+			peers = append(peers, peer)
+		}
+		sort.Strings(peers)
+		printLine("CRITICAL node " + name + " is partitioned from " + strings.Join(peers, ", "))
+	}
+	recordGauge("rabbitmq.cluster.partitioned_nodes", float64(len(names)))
+	recordPerf("partitioned_nodes", float64(len(names)), "", "", "0")
+
+	if len(names) == 0 {
+		printLine("OK no partitions reported by the " + strconv.Itoa(nodes) + " nodes seen from " + strconv.Itoa(answered) + " hosts")
+	}
+}
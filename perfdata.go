@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// perfValues holds the perfdata of the run, printed after the output by flushOutput
+var perfValues []string
+
+// perfLabels labels the perfdata of the run, following the options once applyOptions ran
+var perfLabels = &perfLabeler{style: "safe", max: 64, seen: map[string]int{}}
+
+/*
+perfLabeler turns queue and node names into perfdata labels graphing tools accept. Labels handed out are
+remembered so two names normalizing to the same label do not end up on the same graph.
+*/
+type perfLabeler struct {
+	style string
+	max   int
+	seen  map[string]int
+}
+
+/*
+newPerfLabeler returns a labeler following --perf-labels and --perf-label-max
+*/
+func newPerfLabeler(opt *options) *perfLabeler {
+	return &perfLabeler{style: opt.PerfLabels, max: opt.PerfLabelMax, seen: map[string]int{}}
+}
+
+/*
+label normalizes a name into a unique perfdata label.
+With the safe style everything but ascii letters, digits, '_', '-' and '.' becomes '_', which keeps
+PNP4Nagios file names and Graphite paths intact. With the quote style the name is kept and quoted
+as the plugin guidelines require, doubling single quotes; only '=' has to be replaced.
+Names longer than the maximum are shortened and suffixed with a hash of the full name.
+*/
+func (labeler *perfLabeler) label(name string) string {
+	label := ""
+	if labeler.style == "quote" {
+		label = strings.Replace(name, "=", "_", -1)
+	} else {
+		label = strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
+				return r
+			}
+			return '_'
+		}, name)
+	}
+
+	if labeler.max > 0 && utf8.RuneCountInString(label) > labeler.max {
+		sum := sha1.Sum([]byte(name))
+		suffix := "_" + hex.EncodeToString(sum[:4])
+		runes := []rune(label)
+		keep := labeler.max - len(suffix)
+		if keep < 0 {
+			keep = 0
+		}
+		label = string(runes[:keep]) + suffix
+	}
+
+	unique := label
+	for count := 2; labeler.seen[unique] > 0; count++ {
+		unique = label + "_" + strconv.Itoa(count)
+	}
+	labeler.seen[unique]++
+	label = unique
+
+	if labeler.style == "quote" {
+		return "'" + strings.Replace(label, "'", "''", -1) + "'"
+	}
+	return label
+}
+
+/*
+recordPerf adds a value to the perfdata of the run as label=value[unit];warning;critical;0, for PNP4Nagios,
+Graphite or Grafana to graph. The unit follows the plugin guidelines, e.g. B for bytes, and thresholds left
+empty are not set.
+*/
+func recordPerf(name string, value float64, unit string, warning, critical string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	// three decimals are plenty for graphs and keep percentages from spelling out every digit
+	rounded := math.Round(value*1000) / 1000
+	if currentCluster != "" {
+		name = currentCluster + "_" + name
+	}
+	recordReportMetric(name, rounded, unit, warning, critical)
+	perfValues = append(perfValues, perfLabels.label(name)+"="+strconv.FormatFloat(rounded, 'f', -1, 64)+unit+
+		";"+warning+";"+critical+";0")
+}
+
+/*
+perfLimit returns a threshold of a warning,critical pair as perfdata, "" when the limits are not set
+*/
+func perfLimit(limits []int, index int) string {
+	if limits == nil {
+		return ""
+	}
+	return strconv.Itoa(limits[index])
+}
+
+/*
+perfRange returns the threshold range of a name as perfdata, "" when the name has none
+*/
+func perfRange(ranges map[string]nagiosRange, name string) string {
+	if limit, ok := ranges[name]; ok {
+		return limit.text
+	}
+	return ""
+}
+
+/*
+perfLine returns the perfdata line ending the output, keeping only the values that fit within
+--max-output-bytes after what was already printed. The line starts with '|' so nagios takes it
+as the perfdata of the long output.
+*/
+func perfLine() string {
+	line := ""
+	for _, value := range perfValues {
+		if maxOutputBytes > 0 && printedBytes+len(line)+len(value)+3 > maxOutputBytes {
+			break
+		}
+		line = line + " " + value
+	}
+	if line == "" {
+		return ""
+	}
+	return "|" + line
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+)
+
+/*
+Policy representation from /api/policies
+*/
+type Policy struct {
+	Name       string                 `json:"name"`
+	Vhost      string                 `json:"vhost"`
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to"`
+	Priority   int                    `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
+
+/*
+fetchPolicies returns the policies of the configured vhost, of all vhosts when it is empty
+*/
+func fetchPolicies(opt *options, host string) ([]Policy, error) {
+	path := "/api/policies"
+	if opt.Vhost != "" {
+		path = path + "/" + url.PathEscape(opt.Vhost)
+	}
+
+	policies := []Policy{}
+	err := apiRequest(opt, host, "GET", path, nil, &policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+/*
+definitionString renders a policy definition as compact json for display
+*/
+func (policy Policy) definitionString() string {
+	encoded, err := json.Marshal(policy.Definition)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+/*
+effectiveDefinition returns the definition of the policy the broker applies to the object of the vhost, the
+matching one of the highest priority among those applying to the kind, exchanges or queues. Nil when none does.
+*/
+func effectiveDefinition(policies []Policy, vhost, name, kind string) map[string]interface{} {
+	var effective *Policy
+	for index, policy := range policies {
+		if policy.Vhost != vhost || (policy.ApplyTo != kind && policy.ApplyTo != "all") {
+			continue
+		}
+		pattern, err := regexp.Compile(policy.Pattern)
+		if err != nil || pattern.MatchString(name) == false {
+			continue
+		}
+		if effective == nil || policy.Priority > effective.Priority {
+			effective = &policies[index]
+		}
+	}
+	if effective == nil {
+		return nil
+	}
+	return effective.Definition
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+/*
+prefetchOptions are the options of prefetch mode
+*/
+type prefetchOptions struct {
+	PrefetchVhosts []string `long:"prefetch-vhost" description:"A vhost whose consumers must set a per consumer prefetch, checked in prefetch mode. Repeat for every vhost."`
+}
+
+/*
+runPrefetch alerts on the consuming channels of the --prefetch-vhost vhosts without a per consumer prefetch. A
+prefetch of 0 is unlimited: after a reconnect storm the first clients back take every message of the queue into
+memory. A global qos shares one limit between the consumers of the channel, which quorum queues do not support.
+*/
+func runPrefetch(opt *options, hosts []string) {
+	if len(opt.PrefetchVhosts) == 0 {
+		printUnknown("The prefetch mode requires at least one --prefetch-vhost")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		checked, warnings, criticals := 0, 0, 0
+		lines := []string{}
+		var err error
+		for _, vhost := range opt.PrefetchVhosts {
+			_, err = listChannels(vhostScope(opt, vhost), value, func(channel Channel) error {
+				// channels only publishing have no use for a prefetch
+				if channel.ConsumerCount == 0 {
+					return nil
+				}
+				checked++
+
+				subject := "channel " + channel.Name + " of " + channel.User + " in vhost " + channel.Vhost
+				line := ""
+				if channel.GlobalPrefetchCount > 0 {
+					line = "WARNING " + subject + " uses a global prefetch of " + strconv.Itoa(channel.GlobalPrefetchCount)
+				} else if channel.PrefetchCount == 0 {
+					line = "CRITICAL " + subject + " consumes with an unlimited prefetch"
+				}
+				line = downgradeLine(channel.Vhost+"/"+channel.Name, line)
+				switch lineState(line) {
+				case "CRITICAL":
+					criticals++
+				case "WARNING":
+					warnings++
+				default:
+					return nil
+				}
+				lines = append(lines, line)
+				return nil
+			})
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		sort.Strings(lines)
+		state := "OK"
+		if criticals > 0 {
+			state = "CRITICAL"
+		} else if warnings > 0 {
+			state = "WARNING"
+		}
+		printLine(state + " " + summaryCounts("consuming channels", checked, warnings, criticals))
+		for _, line := range lines {
+			printLine(line)
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the channels from any host")
+}
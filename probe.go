@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+probeOptions are the options of probe mode
+*/
+type probeOptions struct {
+	ProbeQueueType string `long:"probe-queue-type" description:"The x-queue-type of the queue declared in probe mode, e.g. classic or quorum. Uses the vhost default when empty."`
+	ConfirmLatency string `long:"confirm-latency" default:"250,1000" description:"Warning and critical thresholds in milliseconds for the publisher confirm in probe mode."`
+	ProbeCleanup   bool   `long:"probe-cleanup" description:"Remove probe queues left over by crashed previous runs before probing."`
+	ProbeBatch     int    `long:"probe-batch" description:"Also publish this many numbered messages in probe mode and check they are consumed exactly once and in order, 0 skips it."`
+}
+
+// probeQueuePrefix starts the name of every queue declared by a probe, the sweep only touches queues with it
+const probeQueuePrefix = "nagios-probe-"
+
+// probeExpires is the x-expires of probe queues, the broker removes them by itself if every cleanup failed
+const probeExpires = 5 * time.Minute
+
+// probeOrderPrefix starts the payload of the numbered messages of --probe-batch
+const probeOrderPrefix = "nagios probe order "
+
+// probeRequests is about how many requests a probe takes, sweeping, declaring, publishing, consuming and
+// deleting, its deadline allows every one of them the --timeout
+const probeRequests = 5
+
+/*
+queueDeclaration is the body of a PUT to /api/queues/{vhost}/{name}
+*/
+type queueDeclaration struct {
+	AutoDelete bool                   `json:"auto_delete"`
+	Durable    bool                   `json:"durable"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+/*
+publishRequest is the body of a POST to /api/exchanges/{vhost}/{name}/publish
+*/
+type publishRequest struct {
+	Properties      map[string]interface{} `json:"properties"`
+	RoutingKey      string                 `json:"routing_key"`
+	Payload         string                 `json:"payload"`
+	PayloadEncoding string                 `json:"payload_encoding"`
+}
+
+/*
+publishResponse tells whether the published message was routed to a queue
+*/
+type publishResponse struct {
+	Routed bool `json:"routed"`
+}
+
+/*
+probeQueueName returns a unique queue name carrying its creation time, so the sweep can tell leftovers
+of crashed runs from queues still in use by a concurrent run
+*/
+func probeQueueName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return probeQueuePrefix + strconv.FormatInt(time.Now().Unix(), 10) + "-" + host + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(suffix)
+}
+
+/*
+declareProbeQueue declares a uniquely named probe queue and registers its removal.
+Exclusive queues need an owning amqp connection, which the management api does not keep open, so the queue
+is made auto-delete (where the queue type allows it) with an x-expires and deleted explicitly instead.
+*/
+func declareProbeQueue(opt *options, host string) (string, error) {
+	name := probeQueueName()
+	declaration := queueDeclaration{
+		Durable:   true,
+		Arguments: map[string]interface{}{"x-expires": int(probeExpires / time.Millisecond)},
+	}
+	if opt.ProbeQueueType != "" {
+		declaration.Arguments["x-queue-type"] = opt.ProbeQueueType
+	}
+	if opt.ProbeQueueType == "" || opt.ProbeQueueType == "classic" {
+		declaration.AutoDelete = true
+	}
+
+	err := apiRequest(opt, host, "PUT", queuePath(opt, name), declaration, nil)
+	if err != nil {
+		return "", err
+	}
+
+	registerCleanup(host+" "+name, func() {
+		err := apiRequest(opt, host, "DELETE", queuePath(opt, name), nil, nil)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	})
+	return name, nil
+}
+
+/*
+sweepProbeQueues deletes probe queues left over by crashed previous runs, returning how many were removed.
+Only queues older than probeExpires are removed so probes running concurrently are not disturbed.
+*/
+func sweepProbeQueues(opt *options, host string) (int, error) {
+	// collect first and delete afterwards, deleting while paging would shift the pages
+	leftovers := []string{}
+	_, err := listQueues(opt, host, "^"+probeQueuePrefix, "name", func(queue Queue) error {
+		created, err := strconv.ParseInt(strings.SplitN(strings.TrimPrefix(queue.Name, probeQueuePrefix), "-", 2)[0], 10, 64)
+		if err == nil && time.Since(time.Unix(created, 0)) >= probeExpires {
+			leftovers = append(leftovers, queue.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, name := range leftovers {
+		err = apiRequest(opt, host, "DELETE", queuePath(opt, name), nil, nil)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+/*
+sweepHost runs the leftover sweep on the host when --probe-cleanup is set
+*/
+func sweepHost(opt *options, host string) {
+	if opt.ProbeCleanup == false {
+		return
+	}
+
+	removed, err := sweepProbeQueues(opt, host)
+	if err != nil {
+		printLine("WARNING removing leftover probe queues on " + host + " failed: " + err.Error())
+		return
+	}
+	printLine("OK removed " + strconv.Itoa(removed) + " leftover probe queues on " + host)
+}
+
+/*
+runProbe runs the publisher confirm probe against every host
+*/
+func runProbe(opt *options, hosts []string) {
+	confirmLimits, err := limitMap(opt.ConfirmLatency)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	if opt.ProbeBatch < 0 {
+		printUnknown("--probe-batch can not be negative")
+		return
+	}
+
+	guardProbe(opt, func() {
+		checkHosts(hosts, func(host string) {
+			sweepHost(opt, host)
+			processProbe(opt, host, confirmLimits)
+		})
+	})
+}
+
+/*
+guardProbe runs the probe under guardCleanups, bounded by probeDeadline, and removes what it created. The output
+of a probe cut short is replaced by an UNKNOWN line telling why, the check gave up on it.
+*/
+func guardProbe(opt *options, probe func()) {
+	stopGuard := guardCleanups(probeDeadline(opt))
+	holdOutput()
+	probe()
+	lines := releaseOutput()
+	reason := stopGuard()
+	runCleanups()
+
+	if reason != "" {
+		printLine("UNKNOWN " + reason)
+		return
+	}
+	for _, line := range lines {
+		printLine(line)
+	}
+}
+
+/*
+probeDeadline bounds a whole probe run by --timeout, after it the created queues are removed and the check gives
+up. Nagios is usually given a timeout in line with the one of the requests.
+*/
+func probeDeadline(opt *options) time.Duration {
+	return probeRequests * opt.Timeout
+}
+
+/*
+processProbe declares the probe queue, publishes a persistent message to it and thresholds the time to confirm.
+The management api publishes with confirms enabled and only answers once the broker acked the message, so the
+duration of the publish request is the time it took to persist (and for quorum queues replicate) the message.
+*/
+func processProbe(opt *options, host string, confirmLimits []int) {
+	queue, err := declareProbeQueue(opt, host)
+	if err != nil {
+		printLine("CRITICAL declaring probe queue on " + host + " failed: " + err.Error())
+		return
+	}
+	defer runCleanup(host + " " + queue)
+
+	latency, err := publishConfirmed(opt, host, queue, "nagios probe "+time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		printLine("CRITICAL publishing to probe queue on " + host + " failed: " + err.Error())
+		return
+	}
+
+	millis := int(latency / time.Millisecond)
+	if millis >= confirmLimits[1] {
+		printLine("CRITICAL publisher confirm took " + strconv.Itoa(millis) + "ms on " + host)
+	} else if millis >= confirmLimits[0] {
+		printLine("WARNING publisher confirm took " + strconv.Itoa(millis) + "ms on " + host)
+	} else {
+		printLine("OK publisher confirm took " + strconv.Itoa(millis) + "ms on " + host)
+	}
+
+	if opt.ProbeBatch > 0 {
+		checkProbeOrder(opt, host, queue)
+	}
+}
+
+/*
+checkProbeOrder publishes --probe-batch numbered messages to the probe queue and consumes them back, expecting
+every one exactly once and in the order published. A single message getting through says nothing about
+messages duplicated or reordered on their way, which only show over a sequence.
+*/
+func checkProbeOrder(opt *options, host, queue string) {
+	for i := 0; i < opt.ProbeBatch; i++ {
+		_, err := publishConfirmed(opt, host, queue, probeOrderPrefix+strconv.Itoa(i))
+		if err != nil {
+			printLine("CRITICAL publishing probe message " + strconv.Itoa(i) + " on " + host + " failed: " + err.Error())
+			return
+		}
+	}
+
+	received := []int{}
+	request := getRequest{Count: canaryBatch, Ackmode: "ack_requeue_false", Encoding: "auto", Truncate: 64}
+	for i := 0; i < canaryMaxBatches; i++ {
+		messages := []getMessage{}
+		err := apiRequest(opt, host, "POST", queuePath(opt, queue)+"/get", request, &messages)
+		if err != nil {
+			printLine("CRITICAL consuming probe messages on " + host + " failed: " + err.Error())
+			return
+		}
+		for _, message := range messages {
+			// the message of the confirm probe is in the queue as well
+			if strings.HasPrefix(message.Payload, probeOrderPrefix) == false {
+				continue
+			}
+			number, err := strconv.Atoi(strings.TrimPrefix(message.Payload, probeOrderPrefix))
+			if err == nil {
+				received = append(received, number)
+			}
+		}
+		if len(messages) == 0 || messages[len(messages)-1].MessageCount == 0 {
+			break
+		}
+	}
+
+	seen := map[int]int{}
+	duplicated, reordered, missing := 0, 0, 0
+	for index, number := range received {
+		seen[number]++
+		if seen[number] == 2 {
+			duplicated++
+		}
+		if index > 0 && number < received[index-1] {
+			reordered++
+		}
+	}
+	for i := 0; i < opt.ProbeBatch; i++ {
+		if seen[i] == 0 {
+			missing++
+		}
+	}
+
+	batch := strconv.Itoa(opt.ProbeBatch)
+	problems := []string{}
+	if missing > 0 {
+		problems = append(problems, strconv.Itoa(missing)+" missing")
+	}
+	if duplicated > 0 {
+		problems = append(problems, strconv.Itoa(duplicated)+" delivered more than once")
+	}
+	if reordered > 0 {
+		problems = append(problems, strconv.Itoa(reordered)+" out of order")
+	}
+	if len(problems) > 0 {
+		printLine("CRITICAL probe batch of " + batch + " messages on " + host + ": " + strings.Join(problems, ", "))
+	} else {
+		printLine("OK probe batch of " + batch + " messages arrived exactly once and in order on " + host)
+	}
+}
+
+/*
+publishConfirmed publishes a persistent message to the queue through the default exchange and returns the time to confirm
+*/
+func publishConfirmed(opt *options, host, queue, payload string) (time.Duration, error) {
+	request := publishRequest{
+		Properties:      map[string]interface{}{"delivery_mode": 2, "timestamp": time.Now().Unix()},
+		RoutingKey:      queue,
+		Payload:         payload,
+		PayloadEncoding: "string",
+	}
+
+	start := time.Now()
+	response := &publishResponse{}
+	err := apiRequest(opt, host, "POST", "/api/exchanges/"+url.PathEscape(opt.Vhost)+"/amq.default/publish", request, response)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	if response.Routed == false {
+		return latency, errors.New("Message was not routed to " + queue)
+	}
+
+	return latency, nil
+}
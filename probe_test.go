@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeDeadline(t *testing.T) {
+	var mutex sync.Mutex
+	deleted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// a slow broker, every request answers in time but the probe as a whole does not
+		time.Sleep(50 * time.Millisecond)
+		switch {
+		case request.Method == "DELETE":
+			mutex.Lock()
+			deleted++
+			mutex.Unlock()
+		case strings.HasSuffix(request.URL.Path, "/publish"):
+			writer.Write([]byte(`{"routed": true}`))
+		case request.Method != "PUT":
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	lines := runCheck(t, "probe", "-h", host, "--port", port, "--vhost", "/", "--timeout", "100ms", "--probe-batch", "20")
+	if len(lines) != 1 || lines[0] != "UNKNOWN probe timed out after 500ms" {
+		t.Errorf("a probe past its deadline reads %q", lines)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if deleted == 0 {
+		t.Error("the probe queue was not removed")
+	}
+}
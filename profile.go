@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+/*
+startProfiles starts the cpu profile requested by --pprof-cpu and returns the function that stops it and
+writes the heap profile requested by --pprof-heap, to be deferred until the end of the run
+*/
+func startProfiles(opt *options) (func(), error) {
+	var cpu *os.File
+	if opt.PprofCPU != "" {
+		var err error
+		cpu, err = os.Create(opt.PprofCPU)
+		if err != nil {
+			return nil, err
+		}
+		err = pprof.StartCPUProfile(cpu)
+		if err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+
+		if opt.PprofHeap != "" {
+			heap, err := os.Create(opt.PprofHeap)
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+			defer heap.Close()
+
+			runtime.GC()
+			err = pprof.WriteHeapProfile(heap)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}, nil
+}
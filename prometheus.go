@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+prometheusURL builds the url of the rabbitmq_prometheus endpoint on the given host
+*/
+func prometheusURL(opt *options, host string) string {
+	prefix := "http"
+	if opt.Secure == true {
+		prefix = "https"
+	}
+	return prefix + "://" + net.JoinHostPort(host, opt.PrometheusPort) + "/metrics"
+}
+
+/*
+scrapePrometheus fetches the metrics of a host and sums every series by metric name
+*/
+func scrapePrometheus(opt *options, host string) (map[string]float64, error) {
+	response, err := httpClient.Get(prometheusURL(opt, host))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return nil, errors.New("GET /metrics returned " + response.Status)
+	}
+
+	return parsePrometheus(response.Body)
+}
+
+/*
+parsePrometheus parses the prometheus text exposition format, summing the samples of all the label sets of a metric
+*/
+func parsePrometheus(reader io.Reader) (map[string]float64, error) {
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := line
+		rest := ""
+		if brace := strings.Index(line, "{"); brace >= 0 {
+			end := strings.LastIndex(line, "}")
+			if end < brace {
+				return nil, errors.New("Malformed prometheus sample: " + line)
+			}
+			name, rest = line[:brace], line[end+1:]
+		} else if space := strings.IndexAny(line, " \t"); space >= 0 {
+			name, rest = line[:space], line[space:]
+		}
+
+		// the value may be followed by an optional timestamp
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, errors.New("Malformed prometheus sample: " + line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		metrics[name] = metrics[name] + value
+	}
+
+	return metrics, scanner.Err()
+}
+
+/*
+prometheusOverview maps the prometheus metrics of a host onto the overview used by the checks.
+rabbitmq_prometheus only reports the queues local to the scraped node, so these are node totals.
+*/
+func prometheusOverview(opt *options, host string) (*Overview, error) {
+	metrics, err := scrapePrometheus(opt, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ready, ok := metrics["rabbitmq_queue_messages_ready"]
+	if ok == false {
+		return nil, errors.New("rabbitmq_queue_messages_ready missing from the prometheus metrics of " + host)
+	}
+	unack, ok := metrics["rabbitmq_queue_messages_unacked"]
+	if ok == false {
+		return nil, errors.New("rabbitmq_queue_messages_unacked missing from the prometheus metrics of " + host)
+	}
+
+	over := &Overview{}
+	over.QueueTotals.MessagesReady = int(ready)
+	over.QueueTotals.MessagesUnack = int(unack)
+	return over, nil
+}
+
+/*
+prometheusTotals adds up the queue totals of the prometheus endpoints of the hosts, the nodes of a cluster. Each
+node only reports its local queues, so every one of them has to answer.
+*/
+func prometheusTotals(opt *options, hosts []string) (*Overview, error) {
+	overviews := make([]*Overview, len(hosts))
+	errs := eachHost(opt, hosts, func(index int, host string) error {
+		over, err := prometheusOverview(opt, host)
+		overviews[index] = over
+		return err
+	})
+
+	total := &Overview{}
+	for index := range hosts {
+		if errs[index] != nil {
+			return nil, errs[index]
+		}
+		total.QueueTotals.MessagesReady = total.QueueTotals.MessagesReady + overviews[index].QueueTotals.MessagesReady
+		total.QueueTotals.MessagesUnack = total.QueueTotals.MessagesUnack + overviews[index].QueueTotals.MessagesUnack
+	}
+	return total, nil
+}
+
+/*
+nodeHost returns the host of an erlang node name, rabbit1 for rabbit@rabbit1
+*/
+func nodeHost(name string) string {
+	return name[strings.LastIndex(name, "@")+1:]
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+protocolOptions are the options of protocols mode
+*/
+type protocolOptions struct {
+	ProtocolWarning  string `long:"protocol-warning" description:"Warning ranges for the connections per protocol in protocols mode as protocol=range pairs over amqp091, amqp10, mqtt, stomp and other, e.g. mqtt=5000."`
+	ProtocolCritical string `long:"protocol-critical" description:"Critical ranges for the connections per protocol in protocols mode."`
+}
+
+// connectionProtocols are the protocol families connections are counted under, in the order they are reported
+var connectionProtocols = []string{"amqp091", "amqp10", "mqtt", "stomp", "other"}
+
+/*
+protocolFamily maps the protocol of a connection, e.g. "AMQP 0-9-1", "MQTT 3.1.1" or "Web STOMP 1.2", to its
+family in connectionProtocols
+*/
+func protocolFamily(protocol string) string {
+	name := strings.ToUpper(protocol)
+	switch {
+	case strings.Contains(name, "AMQP 0-9"), strings.Contains(name, "AMQP 0-8"):
+		return "amqp091"
+	case strings.Contains(name, "AMQP 1"):
+		return "amqp10"
+	case strings.Contains(name, "MQTT"):
+		return "mqtt"
+	case strings.Contains(name, "STOMP"):
+		return "stomp"
+	}
+	return "other"
+}
+
+/*
+runProtocols counts the connections of the cluster per protocol family against their own ranges, so a fleet
+of mqtt devices reconnecting at once is told apart from the usual amqp traffic
+*/
+func runProtocols(opt *options, hosts []string) {
+	warning, err := parseRanges(opt.ProtocolWarning, connectionProtocols)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := parseRanges(opt.ProtocolCritical, connectionProtocols)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	all := vhostScope(opt, "")
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		counts := map[string]int{}
+		_, err := listConnections(all, value, func(connection Connection) error {
+			counts[protocolFamily(connection.Protocol)]++
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processProtocols(counts, warning, critical)
+		return
+	}
+	printLine("UNKNOWN could not read the connections from any host")
+}
+
+/*
+processProtocols prints a line per protocol family with the state of its ranges
+*/
+func processProtocols(counts map[string]int, warning, critical map[string]nagiosRange) {
+	for _, family := range connectionProtocols {
+		count := counts[family]
+		recordGauge("rabbitmq.connections", float64(count), "protocol", family)
+		recordPerf(family+"_connections", float64(count), "", perfRange(warning, family), perfRange(critical, family))
+		printLine(rangeState(float64(count), warning, critical, family) + " " + strconv.Itoa(count) + " " + family + " connections")
+	}
+}
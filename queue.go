@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+/*
+queueOptions are the options of queue mode
+*/
+type queueOptions struct {
+	Queue         string `long:"queue" description:"The queue checked in queue mode, in the --vhost."`
+	QueueWarning  string `long:"queue-warning" description:"Warning ranges for the queue in queue mode as counter=range pairs over messages and consumers, e.g. messages=100000,consumers=2:."`
+	QueueCritical string `long:"queue-critical" description:"Critical ranges for the queue in queue mode, e.g. consumers=1: for a queue nobody consumes."`
+}
+
+// queueCounters are the counters of a single queue thresholded with --queue-warning and --queue-critical
+var queueCounters = []string{"messages", "consumers"}
+
+/*
+runQueue checks the single queue named by --queue in the --vhost: the ready and unacknowledged messages against
+--warning and --critical like queues mode, the total messages and the consumers against the nagios ranges of
+--queue-warning and --queue-critical, e.g. consumers=1: to alert on a queue nobody consumes. A queue that does
+not exist is critical.
+*/
+func runQueue(opt *options, hosts []string) {
+	if opt.Queue == "" || opt.Vhost == "" {
+		printUnknown("The queue mode requires --vhost and --queue")
+		return
+	}
+
+	warning, err := limitMap(opt.Warning)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := limitMap(opt.Critical)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	rangeWarning, err := parseRanges(opt.QueueWarning, queueCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	rangeCritical, err := parseRanges(opt.QueueCritical, queueCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		queue := Queue{}
+		err := apiRequest(opt, value, "GET", statsPath(opt, queuePath(opt, opt.Queue)), nil, &queue)
+		if errorStatus(err) == http.StatusNotFound {
+			printLine("CRITICAL queue " + opt.Vhost + "/" + opt.Queue + " does not exist")
+			return
+		}
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		queueWarning, queueCritical := warning, critical
+		if opt.QueueThresholds == true {
+			queueWarning = queueLimits(queue, warningArgument, warning)
+			queueCritical = queueLimits(queue, criticalArgument, critical)
+		}
+		processQueue(queue, queueWarning, queueCritical, rangeWarning, rangeCritical)
+		return
+	}
+	printLine("UNKNOWN could not read the queue " + opt.Vhost + "/" + opt.Queue + " from any host")
+}
+
+/*
+processQueue prints the ready and unacknowledged messages of the queue, then its total messages and consumers
+*/
+func processQueue(queue Queue, warning, critical []int, rangeWarning, rangeCritical map[string]nagiosRange) {
+	name := queue.Vhost + "/" + queue.Name
+	lines := []string{}
+	for _, counter := range queueCounters {
+		count := queue.Messages
+		if counter == "consumers" {
+			count = queue.Consumers
+		}
+		recordPerf(counter, float64(count), "", perfRange(rangeWarning, counter), perfRange(rangeCritical, counter))
+		lines = append(lines, rangeState(float64(count), rangeWarning, rangeCritical, counter)+" "+name+" has "+
+			strconv.Itoa(count)+" "+counter)
+	}
+
+	breaches, _, _ := evaluateQueue(queue, warning, critical)
+	recordPerf("messages_ready", float64(queue.MessagesReady), "", perfLimit(warning, 0), perfLimit(critical, 0))
+	recordPerf("messages_unacknowledged", float64(queue.MessagesUnack), "", perfLimit(warning, 1), perfLimit(critical, 1))
+	if len(breaches) == 0 {
+		lines = append(lines, "OK "+name+" has "+strconv.Itoa(queue.MessagesReady)+" messages ready and "+
+			strconv.Itoa(queue.MessagesUnack)+" messages unacknowledged")
+	}
+	lines = append(breaches, lines...)
+
+	lines, _, _ = downgradeLines(name, lines)
+	for _, line := range lines {
+		printLine(line)
+	}
+}
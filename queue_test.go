@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueueMissing(t *testing.T) {
+	port := testHosts(t, map[string]map[string]string{"127.0.0.1": {
+		"/api/queues/%2F/orders": `{"name": "orders", "vhost": "/", "messages": 3, "consumers": 1}`,
+	}})
+
+	lines := runCheck(t, "queue", "-h", "127.0.0.1", "--port", port, "--vhost", "/", "--queue", "orders")
+	if len(lines) == 0 || strings.HasPrefix(lines[0], "OK") == false {
+		t.Errorf("an existing queue reads %q", lines)
+	}
+
+	lines = runCheck(t, "queue", "-h", "127.0.0.1", "--port", port, "--vhost", "/", "--queue", "invoices")
+	if len(lines) != 1 || lines[0] != "CRITICAL queue //invoices does not exist" {
+		t.Errorf("a deleted queue reads %q", lines)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// globPrefix marks a pattern of --include-queues or --exclude-queues as a shell glob rather than a regex
+const globPrefix = "glob:"
+
+/*
+queueFilter selects the queues checked by --include-queues and --exclude-queues, matched against vhost/name,
+and by --exclude-policy and --exclude-argument, matched against the settings of the queue
+*/
+type queueFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+
+	excludePolicies  map[string]bool
+	excludeArguments []string
+}
+
+/*
+newQueueFilter compiles the include and exclude patterns, regular expressions or, prefixed with glob:, shell
+globs where * matches any run of characters and ? a single one, e.g. glob://orders.*
+*/
+func newQueueFilter(include, exclude []string) (*queueFilter, error) {
+	filter := &queueFilter{}
+	for _, value := range include {
+		pattern, err := compileQueuePattern(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, pattern)
+	}
+	for _, value := range exclude {
+		pattern, err := compileQueuePattern(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, pattern)
+	}
+	return filter, nil
+}
+
+/*
+excludeSettings leaves out the queues whose policy is one of policies or that carry one of arguments, either a
+key like x-queue-mode or a key and its value like x-queue-mode=lazy. Teams name their queues every which way,
+while the policy they apply or the argument they declare tells what the queue is for.
+*/
+func (filter *queueFilter) excludeSettings(policies, arguments []string) {
+	filter.excludePolicies = map[string]bool{}
+	for _, policy := range policies {
+		filter.excludePolicies[policy] = true
+	}
+	filter.excludeArguments = arguments
+}
+
+/*
+columns returns the columns with the queue fields the filter reads added
+*/
+func (filter *queueFilter) columns(columns string) string {
+	needed := []string{}
+	if len(filter.excludePolicies) > 0 {
+		needed = append(needed, "policy")
+	}
+	if len(filter.excludeArguments) > 0 {
+		needed = append(needed, "arguments")
+	}
+	for _, column := range needed {
+		if strings.Contains(","+columns+",", ","+column+",") == false {
+			columns = columns + "," + column
+		}
+	}
+	return columns
+}
+
+/*
+argumentString formats the value of a queue argument the way it is written on the command line
+*/
+func argumentString(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	}
+	return ""
+}
+
+/*
+compileQueuePattern compiles a pattern of the filter, turning a glob into the regex anchored on both ends
+*/
+func compileQueuePattern(value string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(value, globPrefix) == false {
+		return regexp.Compile(value)
+	}
+
+	expression := "^"
+	for _, r := range strings.TrimPrefix(value, globPrefix) {
+		switch r {
+		case '*':
+			expression = expression + ".*"
+		case '?':
+			expression = expression + "."
+		default:
+			expression = expression + regexp.QuoteMeta(string(r))
+		}
+	}
+	return regexp.Compile(expression + "$")
+}
+
+/*
+matches tells whether the queue is checked, see matchesName, and none of its settings is excluded
+*/
+func (filter *queueFilter) matches(queue Queue) bool {
+	if queue.Policy != "" && filter.excludePolicies[queue.Policy] {
+		return false
+	}
+	for _, argument := range filter.excludeArguments {
+		parts := strings.SplitN(argument, "=", 2)
+		value, ok := queue.Arguments[parts[0]]
+		if ok && (len(parts) == 1 || argumentString(value) == parts[1]) {
+			return false
+		}
+	}
+	return filter.matchesName(queue.Vhost + "/" + queue.Name)
+}
+
+/*
+matchesName tells whether the vhost/name of an object matches one of the include patterns, or there are none,
+and none of the exclude patterns
+*/
+func (filter *queueFilter) matchesName(name string) bool {
+	included := len(filter.include) == 0
+	for _, pattern := range filter.include {
+		if pattern.MatchString(name) {
+			included = true
+			break
+		}
+	}
+	if included == false {
+		return false
+	}
+	for _, pattern := range filter.exclude {
+		if pattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQueueFilter(t *testing.T) {
+	filter, err := newQueueFilter([]string{"glob://orders.*", "^billing/"}, []string{"\\.dlq$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.excludeSettings([]string{"transient-ok"}, []string{"x-queue-mode=lazy", "x-expires"})
+
+	cases := []struct {
+		queue    Queue
+		expected bool
+	}{
+		{Queue{Vhost: "/", Name: "orders.eu"}, true},
+		{Queue{Vhost: "/", Name: "orders"}, false},
+		{Queue{Vhost: "/", Name: "orders.eu.dlq"}, false},
+		{Queue{Vhost: "billing", Name: "invoices"}, true},
+		{Queue{Vhost: "/", Name: "invoices"}, false},
+		{Queue{Vhost: "/", Name: "orders.us", Policy: "transient-ok"}, false},
+		{Queue{Vhost: "/", Name: "orders.us", Policy: "ha"}, true},
+		{Queue{Vhost: "/", Name: "orders.us", Arguments: map[string]interface{}{"x-queue-mode": "lazy"}}, false},
+		{Queue{Vhost: "/", Name: "orders.us", Arguments: map[string]interface{}{"x-queue-mode": "default"}}, true},
+		{Queue{Vhost: "/", Name: "orders.us", Arguments: map[string]interface{}{"x-expires": float64(60000)}}, false},
+	}
+	for _, c := range cases {
+		if matches := filter.matches(c.queue); matches != c.expected {
+			t.Errorf("matches(%s/%s, policy %q, arguments %v) = %v", c.queue.Vhost, c.queue.Name, c.queue.Policy, c.queue.Arguments, matches)
+		}
+	}
+
+	if _, err := newQueueFilter([]string{"("}, nil); err == nil {
+		t.Error("an invalid include pattern was accepted")
+	}
+}
+
+func TestQueueFilterColumns(t *testing.T) {
+	filter, _ := newQueueFilter(nil, nil)
+	if columns := filter.columns("name,vhost"); columns != "name,vhost" {
+		t.Errorf("columns without settings = %q", columns)
+	}
+
+	filter.excludeSettings([]string{"transient-ok"}, []string{"x-queue-mode"})
+	if columns := filter.columns("name,vhost,arguments"); columns != "name,vhost,arguments,policy" {
+		t.Errorf("columns = %q", columns)
+	}
+}
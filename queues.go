@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queueColumns are the only queue fields requested from the api, keeping pages small on large clusters
+const queueColumns = "name,vhost,messages,messages_ready,messages_unacknowledged,consumers," +
+	"message_bytes,message_bytes_ready,message_bytes_unacknowledged"
+
+/*
+Queue representation from /api/queues
+*/
+type Queue struct {
+	Name          string `json:"name"`
+	Vhost         string `json:"vhost"`
+	Messages      int    `json:"messages"`
+	MessagesReady int    `json:"messages_ready"`
+	MessagesUnack int    `json:"messages_unacknowledged"`
+	Consumers     int    `json:"consumers"`
+	Type          string `json:"type"`
+	State         string `json:"state"`
+
+	// Node is the node of the leader, Members the nodes of a quorum queue or stream and SlaveNodes the mirrors
+	// of a classic queue
+	Node       string   `json:"node"`
+	Members    []string `json:"members"`
+	SlaveNodes []string `json:"slave_nodes"`
+
+	// SynchronisedSlaveNodes are the mirrors holding every message of the leader
+	SynchronisedSlaveNodes []string `json:"synchronised_slave_nodes"`
+
+	// Online are the members of a quorum queue reachable by its leader and OpenFiles the raft log segments
+	// every member keeps open, on rabbitmq 3.8 and later
+	Online    []string       `json:"online"`
+	OpenFiles map[string]int `json:"open_files"`
+
+	MessageBytes      int64 `json:"message_bytes"`
+	MessageBytesReady int64 `json:"message_bytes_ready"`
+	MessageBytesUnack int64 `json:"message_bytes_unacknowledged"`
+
+	// MessageBytesPersistent are the bytes of the messages written to the message store
+	MessageBytesPersistent int64 `json:"message_bytes_persistent"`
+
+	MessageStats MessageStats `json:"message_stats"`
+
+	Policy                    string                 `json:"policy"`
+	Arguments                 map[string]interface{} `json:"arguments"`
+	EffectivePolicyDefinition map[string]interface{} `json:"effective_policy_definition"`
+}
+
+/*
+queueBreach is a breach line kept with the queue it is about, for sorting
+*/
+type queueBreach struct {
+	vhost string
+	name  string
+	line  string
+}
+
+/*
+vhostScope returns the options listing the objects of the vhost, of every vhost when it is empty. The modes
+checking the whole cluster list with vhostScope(opt, "") rather than --vhost.
+*/
+func vhostScope(opt *options, vhost string) *options {
+	scoped := *opt
+	scoped.Vhost = vhost
+	return &scoped
+}
+
+/*
+queueScope returns the options listing the queues of --queue-vhost, all vhosts when it is empty
+*/
+func queueScope(opt *options) *options {
+	return vhostScope(opt, opt.QueueVhost)
+}
+
+/*
+queueScopeName names the vhosts listed by queueScope for the summary lines
+*/
+func queueScopeName(opt *options) string {
+	if opt.QueueVhost == "" {
+		return "all vhosts"
+	}
+	return "vhost " + opt.QueueVhost
+}
+
+/*
+listQueues pages through the queues of the configured vhost (all vhosts when it is empty), filtered on the
+server by the pattern regex, and hands every queue to each. Only the given columns are fetched.
+*/
+func listQueues(opt *options, host, pattern, columns string, each func(queue Queue) error) (pageInfo, error) {
+	path := "/api/queues"
+	if opt.Vhost != "" {
+		path = path + "/" + url.PathEscape(opt.Vhost)
+	}
+
+	query := statsQuery(opt)
+	query.Set("columns", columns)
+	if pattern != "" {
+		query.Set("name", pattern)
+		query.Set("use_regex", "true")
+	}
+
+	return apiPages(opt, host, path, query, func(decoder *json.Decoder) error {
+		queue := Queue{}
+		err := decodeItem(decoder, path, columns, &queue)
+		if err != nil {
+			return err
+		}
+		// brokers before 3.8 only have classic queues and leave their type out
+		if queue.Type == "" && strings.Contains(","+columns+",", ",type,") {
+			queue.Type = "classic"
+		}
+		return each(queue)
+	})
+}
+
+/*
+runQueues checks the queues matching --queue-pattern against the warning and critical limits
+*/
+func runQueues(opt *options, hosts []string) {
+	warningLimits, err := limitMap(opt.Warning)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	criticalLimits, err := limitMap(opt.Critical)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	bytesWarning, bytesCritical, err := bytesOptions(opt)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	filter, err := newQueueFilter(opt.IncludeQueues, opt.ExcludeQueues)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	filter.excludeSettings(opt.ExcludePolicy, opt.ExcludeArgument)
+
+	overrides, err := parseQueueOverrides(opt.QueueThreshold)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err = processQueues(opt, value, filter, overrides, warningLimits, criticalLimits, bytesWarning, bytesCritical)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+evaluateQueue applies the ready and unacknowledged limits to a queue, capped by --max-severity, returning the
+breach lines together with the number of warning and critical breaches
+*/
+func evaluateQueue(queue Queue, warning, critical []int) ([]string, int, int) {
+	warnings, criticals := 0, 0
+	name := queue.Vhost + "/" + queue.Name
+	rdy, unack := strconv.Itoa(queue.MessagesReady), strconv.Itoa(queue.MessagesUnack)
+	breaches := []string{}
+
+	if queue.MessagesReady >= critical[0] {
+		breaches = append(breaches, capSeverity("ready", "CRITICAL "+name+" has "+rdy+" messages ready"))
+	} else if queue.MessagesReady >= warning[0] {
+		breaches = append(breaches, "WARNING "+name+" has "+rdy+" messages ready")
+	}
+
+	if queue.MessagesUnack >= critical[1] {
+		breaches = append(breaches, capSeverity("unacked", "CRITICAL "+name+" has "+unack+" messages unacknowledged"))
+	} else if queue.MessagesUnack >= warning[1] {
+		breaches = append(breaches, "WARNING "+name+" has "+unack+" messages unacknowledged")
+	}
+
+	for _, line := range breaches {
+		if lineState(line) == "CRITICAL" {
+			criticals++
+		} else {
+			warnings++
+		}
+	}
+	return breaches, warnings, criticals
+}
+
+/*
+processQueues applies the ready and unacknowledged limits, or those of the first --queue-threshold matching, and
+the byte limits when set, to every queue matching --queue-pattern and the filter, printing a summary line followed
+by the breaches naming the offending queues. Should the working set outgrow --max-memory while listing, the
+per-queue detail is dropped and only the counts are reported.
+*/
+func processQueues(opt *options, host string, filter *queueFilter, overrides []queueOverride, warning, critical []int, bytesWarning, bytesCritical []int64) error {
+	checked, excluded := 0, 0
+	warnings, criticals := 0, 0
+	details := []queueBreach{}
+	degraded := false
+	columns := queueColumns
+	if opt.QueueThresholds == true {
+		columns = columns + thresholdColumns
+	}
+	columns = filter.columns(columns)
+	info, err := listQueues(queueScope(opt), host, opt.QueuePattern, columns, func(queue Queue) error {
+		if filter.matches(queue) == false {
+			excluded++
+			return nil
+		}
+		checked++
+		if checked%memoryCheckInterval == 0 && degraded == false && overMemoryBudget() {
+			degraded = true
+			details = nil
+		}
+
+		queueWarning, queueCritical := overrideLimits(queue, overrides, warning, critical)
+		if opt.QueueThresholds == true {
+			queueWarning = queueLimits(queue, warningArgument, queueWarning)
+			queueCritical = queueLimits(queue, criticalArgument, queueCritical)
+		}
+
+		breaches, _, _ := evaluateQueue(queue, queueWarning, queueCritical)
+		if bytesWarning != nil {
+			sizes := MessageBytes{queue.MessageBytes, queue.MessageBytesReady, queue.MessageBytesUnack}
+			for _, line := range evaluateBytes(queue.Vhost+"/"+queue.Name+" has ", sizes, bytesWarning, bytesCritical) {
+				if lineState(line) != "OK" {
+					breaches = append(breaches, line)
+				}
+			}
+		}
+		breaches, queueWarnings, queueCriticals := downgradeLines(queue.Vhost+"/"+queue.Name, breaches)
+		if queueCriticals > 0 {
+			criticals++
+		} else if queueWarnings > 0 {
+			warnings++
+		}
+
+		if degraded == false {
+			recordGauge("rabbitmq.queue.messages_ready", float64(queue.MessagesReady), "queue", queue.Vhost+"/"+queue.Name)
+			recordGauge("rabbitmq.queue.messages_unacknowledged", float64(queue.MessagesUnack), "queue", queue.Vhost+"/"+queue.Name)
+			for _, line := range breaches {
+				details = append(details, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// pages arrive in any order, sort so successive runs print the same output
+	sort.SliceStable(details, func(i, j int) bool {
+		if details[i].vhost != details[j].vhost {
+			return details[i].vhost < details[j].vhost
+		}
+		return details[i].name < details[j].name
+	})
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	recordGauge("check_rabbitmq.queues.checked", float64(checked), "host", host)
+	recordGauge("check_rabbitmq.queues.warning", float64(warnings), "host", host)
+	recordGauge("check_rabbitmq.queues.critical", float64(criticals), "host", host)
+	recordPerf("queues_checked", float64(checked), "", "", "")
+	recordPerf("queues_warning", float64(warnings), "", "", "")
+	recordPerf("queues_critical", float64(criticals), "", "", "")
+
+	summary := state + " " + summaryCounts("queues of "+queueScopeName(opt), checked, warnings, criticals) +
+		", " + strconv.Itoa(info.TotalCount-info.FilteredCount+excluded) + " excluded"
+	if degraded {
+		summary = summary + " (aggregate only, per-queue detail dropped at the memory budget of " + opt.MaxMemory + ")"
+	}
+
+	printLine(summary)
+	for _, detail := range details {
+		printLine(detail.line)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+/*
+queueTypeOptions are the options of queue-types mode
+*/
+type queueTypeOptions struct {
+	QuorumVhosts []string `long:"quorum-vhost" description:"A vhost that must only hold quorum queues, checked in queue-types mode on top of the vhosts with quorum as their default queue type. Repeat for every vhost."`
+}
+
+// queueTypes are the queue types counted in queue-types mode, queues of other types are counted apart
+var queueTypes = []string{"classic", "quorum", "stream"}
+
+/*
+mirrored tells whether a classic queue is mirrored by its effective policy
+*/
+func mirrored(queue Queue) bool {
+	_, ok := queue.EffectivePolicyDefinition["ha-mode"]
+	return ok
+}
+
+/*
+quorumVhosts returns the vhosts mandated to use quorum queues: the --quorum-vhost ones and those with
+quorum as their default queue type
+*/
+func quorumVhosts(opt *options, host string) (map[string]bool, error) {
+	mandated := map[string]bool{}
+	for _, vhost := range opt.QuorumVhosts {
+		mandated[vhost] = true
+	}
+
+	vhosts := []Vhost{}
+	err := apiRequest(opt, host, "GET", "/api/vhosts", nil, &vhosts)
+	if err != nil {
+		return nil, err
+	}
+	for _, vhost := range vhosts {
+		if vhost.DefaultQueueType == "quorum" {
+			mandated[vhost.Name] = true
+		}
+	}
+	return mandated, nil
+}
+
+/*
+runQueueTypes counts the classic, quorum and stream queues of every vhost and warns about the classic queues
+without mirroring left in vhosts mandated to use quorum queues, to follow a migration through
+*/
+func runQueueTypes(opt *options, hosts []string) {
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err := processQueueTypes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+processQueueTypes prints a summary line, the counts per vhost and the offending queues
+*/
+func processQueueTypes(opt *options, host string) error {
+	mandated, err := quorumVhosts(opt, host)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]map[string]int{}
+	offending := []queueBreach{}
+	checked := 0
+	all := vhostScope(opt, "")
+	_, err = listQueues(all, host, opt.QueuePattern, "name,vhost,type,effective_policy_definition", func(queue Queue) error {
+		checked++
+		if counts[queue.Vhost] == nil {
+			counts[queue.Vhost] = map[string]int{}
+		}
+		counts[queue.Vhost][queue.Type]++
+
+		if mandated[queue.Vhost] && queue.Type == "classic" && mirrored(queue) == false {
+			line := downgradeLine(queue.Vhost+"/"+queue.Name, "WARNING classic queue "+queue.Vhost+"/"+queue.Name+" is not mirrored in the quorum vhost "+queue.Vhost)
+			if line != "" {
+				offending = append(offending, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	vhosts := []string{}
+	for vhost := range counts {
+		vhosts = append(vhosts, vhost)
+	}
+	sort.Strings(vhosts)
+	sort.SliceStable(offending, func(i, j int) bool {
+		if offending[i].vhost != offending[j].vhost {
+			return offending[i].vhost < offending[j].vhost
+		}
+		return offending[i].name < offending[j].name
+	})
+
+	state := "OK"
+	if len(offending) > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("queues", checked, len(offending), 0))
+	for _, vhost := range vhosts {
+		line := "OK vhost " + vhost + " has"
+		for index, kind := range queueTypes {
+			if index > 0 {
+				line = line + ","
+			}
+			line = line + " " + strconv.Itoa(counts[vhost][kind]) + " " + kind
+			recordGauge("rabbitmq.queues", float64(counts[vhost][kind]), "vhost", vhost, "type", kind)
+		}
+		other := 0
+		for kind, count := range counts[vhost] {
+			if kind != "classic" && kind != "quorum" && kind != "stream" {
+				other = other + count
+			}
+		}
+		if other > 0 {
+			line = line + ", " + strconv.Itoa(other) + " other"
+		}
+		printLine(line + " queues")
+	}
+	for _, breach := range offending {
+		printLine(breach.line)
+	}
+	return nil
+}
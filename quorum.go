@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+quorumOptions are the options of quorum mode
+*/
+type quorumOptions struct {
+	QuorumOpenFiles string `long:"quorum-open-files" description:"Warning and critical thresholds for the raft log segment files a member of a quorum queue keeps open in quorum mode, e.g. 50,100. Segments pile up when the log cannot be truncated, e.g. behind a consumer never acknowledging."`
+}
+
+/*
+runQuorum checks every quorum queue of rabbitmq 3.8 and later has a leader and all its members online. A
+queue missing a member still serves its clients, but one more node going down, a rolling restart say, takes
+its majority and with it the queue.
+*/
+func runQuorum(opt *options, hosts []string) {
+	openFiles, err := optionalLimits(opt.QuorumOpenFiles)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err := processQuorum(opt, value, openFiles)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+quorumLines returns the breach lines of a quorum queue: critical without a leader, warning with members
+offline and, with openFiles, thresholded on the most segment files a member keeps open
+*/
+func quorumLines(queue Queue, openFiles []int) []string {
+	name := queue.Vhost + "/" + queue.Name
+	online := map[string]bool{}
+	for _, member := range queue.Online {
+		online[member] = true
+	}
+	offline := []string{}
+	for _, member := range queue.Members {
+		if online[member] == false {
+			offline = append(offline, member)
+		}
+	}
+
+	lines := []string{}
+	if queue.State == "down" || online[queue.Node] == false {
+		lines = append(lines, "CRITICAL quorum queue "+name+" has no leader, "+strconv.Itoa(len(queue.Online))+" of "+
+			strconv.Itoa(len(queue.Members))+" members online")
+	} else if len(offline) > 0 {
+		lines = append(lines, "WARNING quorum queue "+name+" has "+strconv.Itoa(len(queue.Online))+" of "+
+			strconv.Itoa(len(queue.Members))+" members online, offline: "+strings.Join(offline, ","))
+	}
+
+	if openFiles != nil {
+		most, node := 0, ""
+		for member, files := range queue.OpenFiles {
+			if files > most || (files == most && member < node) {
+				most, node = files, member
+			}
+		}
+		message := "quorum queue " + name + " keeps " + strconv.Itoa(most) + " log segments open on " + node
+		if most >= openFiles[1] {
+			lines = append(lines, "CRITICAL "+message)
+		} else if most >= openFiles[0] {
+			lines = append(lines, "WARNING "+message)
+		}
+	}
+	return lines
+}
+
+/*
+processQuorum prints a summary line followed by the quorum queues without a leader, missing members or, with
+openFiles, keeping too many log segments open
+*/
+func processQuorum(opt *options, host string, openFiles []int) error {
+	offending := []queueBreach{}
+	checked, warnings, criticals := 0, 0, 0
+	all := vhostScope(opt, "")
+	_, err := listQueues(all, host, opt.QueuePattern, "name,vhost,type,state,node,members,online,open_files", func(queue Queue) error {
+		if queue.Type != "quorum" {
+			return nil
+		}
+		checked++
+
+		lines, queueWarnings, queueCriticals := downgradeLines(queue.Vhost+"/"+queue.Name, quorumLines(queue, openFiles))
+		if queueCriticals > 0 {
+			criticals++
+		} else if queueWarnings > 0 {
+			warnings++
+		}
+		for _, line := range lines {
+			offending = append(offending, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(offending, func(i, j int) bool {
+		if offending[i].vhost != offending[j].vhost {
+			return offending[i].vhost < offending[j].vhost
+		}
+		return offending[i].name < offending[j].name
+	})
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	recordPerf("quorum_queues", float64(checked), "", "", "")
+	recordPerf("quorum_queues_degraded", float64(warnings+criticals), "", "", "")
+	printLine(state + " " + summaryCounts("quorum queues", checked, warnings, criticals))
+	for _, breach := range offending {
+		printLine(breach.line)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+nagiosRange is a threshold range as described by the nagios plugin guidelines: "10" alerts outside 0..10,
+"10:" below 10, "~:10" above 10, "10:20" outside 10..20 and "@10:20" inside 10..20
+*/
+type nagiosRange struct {
+	low    float64
+	high   float64
+	inside bool
+	text   string
+}
+
+/*
+parseRange parses a nagios threshold range
+*/
+func parseRange(str string) (nagiosRange, error) {
+	result := nagiosRange{low: 0, high: math.Inf(1), text: str}
+	value := str
+	if strings.HasPrefix(value, "@") {
+		result.inside = true
+		value = value[1:]
+	}
+
+	bounds := strings.SplitN(value, ":", 2)
+	var err error
+	if len(bounds) == 1 {
+		result.high, err = strconv.ParseFloat(bounds[0], 64)
+		if err != nil {
+			return result, errors.New("Invalid range " + str)
+		}
+		return result, nil
+	}
+
+	if bounds[0] == "~" {
+		result.low = math.Inf(-1)
+	} else if bounds[0] != "" {
+		result.low, err = strconv.ParseFloat(bounds[0], 64)
+		if err != nil {
+			return result, errors.New("Invalid range " + str)
+		}
+	}
+	if bounds[1] != "" {
+		result.high, err = strconv.ParseFloat(bounds[1], 64)
+		if err != nil {
+			return result, errors.New("Invalid range " + str)
+		}
+	}
+	if result.low > result.high {
+		return result, errors.New("Invalid range " + str + ", the start is above the end")
+	}
+	return result, nil
+}
+
+/*
+alerts returns true when the value breaches the range
+*/
+func (r nagiosRange) alerts(value float64) bool {
+	outside := value < r.low || value > r.high
+	if r.inside {
+		return outside == false
+	}
+	return outside
+}
+
+/*
+parseRanges parses a comma separated list of name=range pairs, such as connections=5000,consumers=1:,
+accepting only the given names. Names left out have no threshold.
+*/
+func parseRanges(str string, names []string) (map[string]nagiosRange, error) {
+	ranges := map[string]nagiosRange{}
+	if str == "" {
+		return ranges, nil
+	}
+
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, pair := range strings.Split(str, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Expected name=range in " + pair)
+		}
+		name := strings.TrimSpace(parts[0])
+		if known[name] == false {
+			return nil, errors.New("Unknown counter " + name + ", expected one of " + strings.Join(names, ", "))
+		}
+		parsed, err := parseRange(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		ranges[name] = parsed
+	}
+	return ranges, nil
+}
+
+/*
+rangeState returns the state of a value against its warning and critical ranges, either of which may be missing
+*/
+func rangeState(value float64, warning, critical map[string]nagiosRange, name string) string {
+	if limit, ok := critical[name]; ok && limit.alerts(value) {
+		return "CRITICAL"
+	}
+	if limit, ok := warning[name]; ok && limit.alerts(value) {
+		return "WARNING"
+	}
+	return "OK"
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+/*
+rateOptions are the options of rates mode
+*/
+type rateOptions struct {
+	RatesExchanges []string `long:"rates-exchange" description:"An exchange of the --vhost whose publish_in and publish_out rates are checked in rates mode too. Repeat for every exchange."`
+}
+
+// rateCounters are the message_stats rates of the overview in the order they are reported
+var rateCounters = []string{"publish", "deliver_get", "ack", "confirm"}
+
+// exchangeRateCounters are the message_stats rates of an exchange, the messages published to it and routed by it
+var exchangeRateCounters = []string{"publish_in", "publish_out"}
+
+/*
+MessageStats represents the rates of the message_stats substructure
+*/
+type MessageStats struct {
+	PublishDetails    RateDetails `json:"publish_details"`
+	DeliverGetDetails RateDetails `json:"deliver_get_details"`
+	AckDetails        RateDetails `json:"ack_details"`
+	ConfirmDetails    RateDetails `json:"confirm_details"`
+	PublishInDetails  RateDetails `json:"publish_in_details"`
+	PublishOutDetails RateDetails `json:"publish_out_details"`
+}
+
+/*
+rates returns the rates keyed like rateCounters. A cluster without any traffic leaves the details out,
+which reads as a rate of 0.
+*/
+func (stats MessageStats) rates() map[string]float64 {
+	return map[string]float64{
+		"publish":     stats.PublishDetails.value(),
+		"deliver_get": stats.DeliverGetDetails.value(),
+		"ack":         stats.AckDetails.value(),
+		"confirm":     stats.ConfirmDetails.value(),
+	}
+}
+
+/*
+exchangeRates returns the rates of an exchange keyed like exchangeRateCounters
+*/
+func (stats MessageStats) exchangeRates() map[string]float64 {
+	return map[string]float64{
+		"publish_in":  stats.PublishInDetails.value(),
+		"publish_out": stats.PublishOutDetails.value(),
+	}
+}
+
+/*
+runRates checks the cluster wide message rates against their ranges, then the rates of every --rates-exchange.
+Low watermarks such as publish=10: catch a cluster that suddenly went quiet, publish_in=10: a busy exchange
+whose publishers stopped.
+*/
+func runRates(opt *options, hosts []string) {
+	counters := append(append([]string{}, rateCounters...), exchangeRateCounters...)
+	warning, err := parseRanges(opt.RatesWarning, counters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := parseRanges(opt.RatesCritical, counters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		over := &Overview{}
+		err := apiRequest(opt, value, "GET", statsPath(opt, "/api/overview"), nil, over)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		processRates(over.MessageStats, warning, critical)
+		for _, name := range opt.RatesExchanges {
+			processExchangeRates(opt, value, name, warning, critical)
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the message rates from any host")
+}
+
+/*
+processRates prints a line per rate with the state of its ranges
+*/
+func processRates(stats MessageStats, warning, critical map[string]nagiosRange) {
+	rates := stats.rates()
+	for _, name := range rateCounters {
+		rate := rates[name]
+		recordGauge("rabbitmq.message_stats."+name+"_rate", rate)
+		recordPerf(name+"_rate", rate, "", perfRange(warning, name), perfRange(critical, name))
+		printLine(rangeState(rate, warning, critical, name) + " " + name + " rate " + strconv.FormatFloat(rate, 'f', 1, 64) + " msgs/sec")
+	}
+}
+
+/*
+processExchangeRates prints a line per rate of an exchange of the --vhost with the state of its ranges,
+an exchange that does not exist is critical
+*/
+func processExchangeRates(opt *options, host, name string, warning, critical map[string]nagiosRange) {
+	exchange := Exchange{}
+	path := "/api/exchanges/" + url.PathEscape(opt.Vhost) + "/" + url.PathEscape(name)
+	err := apiRequest(opt, host, "GET", statsPath(opt, path), nil, &exchange)
+	if errorStatus(err) == http.StatusNotFound {
+		printLine("CRITICAL exchange " + opt.Vhost + "/" + name + " does not exist")
+		return
+	}
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	subject := opt.Vhost + "/" + name
+	rates := exchange.MessageStats.exchangeRates()
+	lines := []string{}
+	for _, counter := range exchangeRateCounters {
+		rate := rates[counter]
+		recordGauge("rabbitmq.exchange."+counter+"_rate", rate, "exchange", subject)
+		recordPerf(subject+"_"+counter+"_rate", rate, "", perfRange(warning, counter), perfRange(critical, counter))
+		lines = append(lines, rangeState(rate, warning, critical, counter)+" exchange "+subject+" "+counter+" rate "+
+			strconv.FormatFloat(rate, 'f', 1, 64)+" msgs/sec")
+	}
+
+	lines, _, _ = downgradeLines(subject, lines)
+	for _, line := range lines {
+		printLine(line)
+	}
+}
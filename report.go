@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// jsonOutput is set by --output json, the run is then printed as one json document by flushOutput
+var jsonOutput bool
+
+// reportMode and reportHosts describe the run in the json document
+var reportMode string
+var reportHosts []string
+
+/*
+report is the json document printed with --output json instead of the nagios text
+*/
+type report struct {
+	Mode     string         `json:"mode"`
+	Hosts    []string       `json:"hosts"`
+	State    string         `json:"state"`
+	ExitCode int            `json:"exit_code"`
+	Lines    []reportLine   `json:"lines"`
+	Metrics  []reportMetric `json:"metrics"`
+	Values   []reportValue  `json:"values"`
+
+	// Rows are the objects printed by list mode, keyed by their lowercased column headers
+	Rows []map[string]string `json:"rows,omitempty"`
+}
+
+/*
+reportLine is a line of the nagios output split into its state and message
+*/
+type reportLine struct {
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+/*
+reportMetric is a value of the perfdata with its thresholds, as nagios ranges or limits
+*/
+type reportMetric struct {
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Warning  string  `json:"warning,omitempty"`
+	Critical string  `json:"critical,omitempty"`
+}
+
+/*
+reportValue is a value collected by the check, labelled with e.g. the host, node or queue it is about
+*/
+type reportValue struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// reportMetrics, reportValues and reportRows collect the run for the json document, only with --output json
+var reportMetrics []reportMetric
+var reportValues []reportValue
+var reportRows []map[string]string
+
+var reportMutex sync.Mutex
+
+/*
+recordReportMetric keeps a perfdata value for the json document
+*/
+func recordReportMetric(name string, value float64, unit, warning, critical string) {
+	if jsonOutput == false {
+		return
+	}
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	reportMetrics = append(reportMetrics, reportMetric{Name: name, Value: value, Unit: unit, Warning: warning, Critical: critical})
+}
+
+/*
+recordReportValue keeps a collected value for the json document with its label pairs
+*/
+func recordReportValue(name string, value float64, pairs ...string) {
+	if jsonOutput == false {
+		return
+	}
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	var labels map[string]string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[pairs[i]] = pairs[i+1]
+	}
+	reportValues = append(reportValues, reportValue{Name: name, Labels: labels, Value: value})
+}
+
+/*
+recordReportRows keeps the table of list mode for the json document, the first row being the headers
+*/
+func recordReportRows(rows [][]string) {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	for _, row := range rows[1:] {
+		entry := map[string]string{}
+		for column, header := range rows[0] {
+			entry[strings.ToLower(strings.Replace(header, " ", "_", -1))] = row[column]
+		}
+		reportRows = append(reportRows, entry)
+	}
+}
+
+/*
+printReport prints the run as one json document and starts collecting the next one. The output limits are
+meant for nagios and do not apply, automation reading the document wants all of it.
+*/
+func printReport() {
+	state := outputState()
+
+	outputMutex.Lock()
+	lines := []reportLine{}
+	for _, line := range outputLines {
+		prefix := lineState(line)
+		message := strings.TrimPrefix(strings.TrimPrefix(line, prefix), " ")
+		lines = append(lines, reportLine{State: prefix, Message: message})
+	}
+	perfValues = nil
+	outputMutex.Unlock()
+
+	reportMutex.Lock()
+	document := report{Mode: reportMode, Hosts: reportHosts, State: state, ExitCode: exitCode(state), Lines: lines,
+		Metrics: reportMetrics, Values: reportValues, Rows: reportRows}
+	if document.Metrics == nil {
+		document.Metrics = []reportMetric{}
+	}
+	if document.Values == nil {
+		document.Values = []reportValue{}
+	}
+	encoded, err := json.Marshal(document)
+	reportMetrics, reportValues, reportRows = nil, nil, nil
+	reportMutex.Unlock()
+	if err != nil {
+		// nagios still gets a state when the document cannot be encoded
+		fmt.Println("UNKNOWN " + err.Error())
+		return
+	}
+	fmt.Println(string(encoded))
+}
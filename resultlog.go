@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+resultEntry is the line appended to --result-log for every run
+*/
+type resultEntry struct {
+	Timestamp string             `json:"timestamp"`
+	Mode      string             `json:"mode"`
+	Hosts     []string           `json:"hosts"`
+	State     string             `json:"state"`
+	Duration  float64            `json:"duration_seconds"`
+	Summary   string             `json:"summary"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// resultValues collects the values recorded during a run, nil unless --result-log is set
+var resultValues map[string]float64
+
+var resultMutex sync.Mutex
+
+/*
+startResultLog starts collecting the values of a run when a result log is configured
+*/
+func startResultLog(opt *options) {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+
+	resultValues = nil
+	if opt.ResultLog != "" {
+		resultValues = map[string]float64{}
+	}
+}
+
+/*
+recordResultValue keeps a value for the result log, keyed by its name and its label values,
+e.g. rabbitmq.queue_totals.messages_ready{host=rabbit1}
+*/
+func recordResultValue(name string, value float64, pairs ...string) {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+
+	if resultValues == nil {
+		return
+	}
+	labels := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+"="+pairs[i+1])
+	}
+	if len(labels) > 0 {
+		name = name + "{" + strings.Join(labels, ",") + "}"
+	}
+	resultValues[name] = value
+}
+
+/*
+writeResultLog appends the result of the run as one json line. The line is written with a single append
+so concurrent runs sharing the log do not interleave.
+*/
+func writeResultLog(opt *options, start time.Time) error {
+	if opt.ResultLog == "" {
+		return nil
+	}
+
+	outputMutex.Lock()
+	summary := ""
+	if len(outputLines) > 0 {
+		summary = outputLines[0]
+	}
+	outputMutex.Unlock()
+
+	resultMutex.Lock()
+	entry := resultEntry{
+		Timestamp: start.UTC().Format(time.RFC3339),
+		Mode:      opt.Mode,
+		Hosts:     opt.Host,
+		State:     outputState(),
+		Duration:  time.Since(start).Seconds(),
+		Summary:   summary,
+		Values:    resultValues,
+	}
+	encoded, err := json.Marshal(entry)
+	resultMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(opt.ResultLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(encoded, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
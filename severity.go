@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// severityMetrics are the metrics --max-severity caps, the message counts and the byte sizes of --bytes-warning
+var severityMetrics = []string{"ready", "unacked", "bytes", "bytes-ready", "bytes-unacked"}
+
+// maxSeverities are the states of --max-severity keyed by metric
+var maxSeverities map[string]string
+
+/*
+loadMaxSeverities parses the --max-severity values, a metric followed by an equal sign and the most severe
+state its breaches reach, e.g. unacked=warning. Critical is no cap at all, the default, so a later
+unacked=critical lifts the cap of an earlier unacked=warning.
+*/
+func loadMaxSeverities(values []string) error {
+	maxSeverities = map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("Invalid --max-severity " + value + ", expected metric=warning or metric=critical")
+		}
+		known := false
+		for _, metric := range severityMetrics {
+			if parts[0] == metric {
+				known = true
+				break
+			}
+		}
+		if known == false {
+			return errors.New("Unknown metric " + parts[0] + " in --max-severity, expected one of " + strings.Join(severityMetrics, ", "))
+		}
+		state := strings.ToUpper(parts[1])
+		if state != "WARNING" && state != "CRITICAL" {
+			return errors.New("Invalid severity " + parts[1] + " in --max-severity, expected warning or critical")
+		}
+		maxSeverities[parts[0]] = state
+	}
+	return nil
+}
+
+/*
+capSeverity lowers a CRITICAL breach line of the metric to WARNING when --max-severity caps it there
+*/
+func capSeverity(metric, line string) string {
+	if lineState(line) == "CRITICAL" && maxSeverities[metric] == "WARNING" {
+		return "WARNING" + strings.TrimPrefix(line, "CRITICAL") + " (capped)"
+	}
+	return line
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCapSeverity(t *testing.T) {
+	err := loadMaxSeverities([]string{"unacked=warning", "ready=warning", "ready=critical", "bytes=Warning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loadMaxSeverities(nil)
+
+	cases := []struct {
+		metric, line, expected string
+	}{
+		{"unacked", "CRITICAL //orders has 10 unacked", "WARNING //orders has 10 unacked (capped)"},
+		{"unacked", "WARNING //orders has 10 unacked", "WARNING //orders has 10 unacked"},
+		{"ready", "CRITICAL //orders has 10 ready", "CRITICAL //orders has 10 ready"},
+		{"bytes", "CRITICAL //orders holds 10 bytes", "WARNING //orders holds 10 bytes (capped)"},
+		{"bytes-ready", "CRITICAL //orders holds 10 bytes", "CRITICAL //orders holds 10 bytes"},
+	}
+	for _, c := range cases {
+		if line := capSeverity(c.metric, c.line); line != c.expected {
+			t.Errorf("capSeverity(%s, %q) = %q, expected %q", c.metric, c.line, line, c.expected)
+		}
+	}
+
+	for _, invalid := range []string{"unacked", "unacked=ok", "messages=warning"} {
+		if loadMaxSeverities([]string{invalid}) == nil {
+			t.Errorf("--max-severity %s accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// statsModes are the modes reading the management statistics, held back by --max-stats-age when they are stale
+var statsModes = map[string]bool{"overview": true, "queues": true, "queue": true, "object-totals": true,
+	"rates": true, "vhost": true, "channels": true, "transactions": true, "drain-time": true,
+	"throughput": true}
+
+/*
+sampledTotals is the part of the overview carrying the samples of the queue totals when a lengths_age is requested
+*/
+type sampledTotals struct {
+	QueueTotals struct {
+		MessagesDetails RateDetails `json:"messages_details"`
+	} `json:"queue_totals"`
+}
+
+/*
+newestSample returns the time of the most recent sample of the details, false when there are none
+*/
+func (details RateDetails) newestSample() (time.Time, bool) {
+	newest := int64(0)
+	for _, raw := range details.Samples {
+		sample := struct {
+			Timestamp int64 `json:"timestamp"`
+		}{}
+		if json.Unmarshal(raw, &sample) == nil && sample.Timestamp > newest {
+			newest = sample.Timestamp
+		}
+	}
+	if newest == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, newest*int64(time.Millisecond)), true
+}
+
+/*
+staleStats returns why the statistics are too old to be checked, "" when they are fresh or their age can not
+be told. The newest sample of the queue totals is compared to the wall clock: a management database falling
+behind keeps serving its last numbers, and alerting on numbers minutes out of date helps nobody.
+*/
+func staleStats(opt *options, hosts []string) string {
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		totals := sampledTotals{}
+		err := apiRequest(opt, value, "GET", "/api/overview?lengths_age=60&lengths_incr=5", nil, &totals)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		// without samples, e.g. with the stats disabled, there is nothing to tell the age from
+		newest, ok := totals.QueueTotals.MessagesDetails.newestSample()
+		if ok == false {
+			return ""
+		}
+		age := time.Since(newest)
+		if age > opt.MaxStatsAge {
+			return "statistics are " + age.Round(time.Second).String() + " old, beyond --max-stats-age " +
+				opt.MaxStatsAge.String() + ", the management database is falling behind"
+		}
+		return ""
+	}
+	return ""
+}
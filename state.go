@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+statePath returns the file keeping the state of the given kind between runs. Runs with different arguments
+check different things, so the file is keyed on the arguments too, and on the cluster of a run checking several.
+*/
+func statePath(opt *options, kind string) string {
+	dir := opt.StateDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	key := strings.Join(os.Args[1:], "\x00")
+	if opt.cluster != "" {
+		key = key + "\x00" + clusterSection + opt.cluster
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(dir, "check_rabbitmq-"+kind+"-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+/*
+loadState decodes the state of the given kind saved by a previous run into result. A missing file is not an
+error, result is left untouched.
+*/
+func loadState(opt *options, kind string, result interface{}) error {
+	content, err := ioutil.ReadFile(statePath(opt, kind))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, result)
+}
+
+/*
+saveState saves the state of the given kind for the next run, replacing the file atomically
+*/
+func saveState(opt *options, kind string, state interface{}) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	path := statePath(opt, kind)
+	temporary := path + ".tmp"
+	err = ioutil.WriteFile(temporary, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
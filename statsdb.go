@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+statsDbOptions are the options of stats-db mode
+*/
+type statsDbOptions struct {
+	StatsEventQueue string `long:"stats-event-queue" default:"500,5000" description:"Warning and critical thresholds for the stats events waiting for the management database in stats-db mode."`
+	StatsGCQueue    string `long:"stats-gc-queue" default:"1000,10000" description:"Warning and critical thresholds per node for the metrics of closed objects waiting to be collected in stats-db mode."`
+	StatsDbMemory   string `long:"stats-db-memory" description:"Warning and critical sizes per node for the memory of the management database in stats-db mode, e.g. 512M,1G."`
+}
+
+/*
+sizeLimits parses a warning,critical pair of sizes such as 1G,2G, an empty value disables the limits and returns nil
+*/
+func sizeLimits(str string) ([]int64, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	values := strings.Split(str, ",")
+	if len(values) != 2 {
+		return nil, errors.New("A list of two sizes is required for size limits.")
+	}
+	limits := []int64{}
+	for _, value := range values {
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, size)
+	}
+	return limits, nil
+}
+
+/*
+runStatsDb checks that the management database keeps up with the stats events: the events waiting in its
+queue, the metrics of closed objects waiting to be collected on every node and, with --stats-db-memory, the
+memory the database takes. A database falling behind silently makes every other check read stale data.
+*/
+func runStatsDb(opt *options, hosts []string) {
+	eventLimits, err := limitMap(opt.StatsEventQueue)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	gcLimits, err := limitMap(opt.StatsGCQueue)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	memoryLimits, err := sizeLimits(opt.StatsDbMemory)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		over := &Overview{}
+		err := apiRequest(opt, value, "GET", "/api/overview", nil, over)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		breakdowns := map[string]NodeMemory{}
+		if memoryLimits != nil {
+			breakdowns, err = nodeMemories(opt, value)
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+		}
+		processStatsDb(over, nodes, breakdowns, eventLimits, gcLimits, memoryLimits)
+		return
+	}
+	printLine("UNKNOWN could not read the management database state from any host")
+}
+
+/*
+processStatsDb prints the event queue line followed by the gc queue, and memory, lines of every node
+*/
+func processStatsDb(over *Overview, nodes []Node, breakdowns map[string]NodeMemory, eventLimits, gcLimits []int, memoryLimits []int64) {
+	events := over.StatisticsDbEventQueue
+	recordGauge("rabbitmq.statistics_db.event_queue", float64(events))
+	recordPerf("stats_event_queue", float64(events), "", perfLimit(eventLimits, 0), perfLimit(eventLimits, 1))
+	message := strconv.Itoa(events) + " stats events waiting for the management database"
+	if over.StatisticsDbNode != "" {
+		message = message + " on " + over.StatisticsDbNode
+	}
+	if events >= eventLimits[1] {
+		printLine("CRITICAL " + message)
+	} else if events >= eventLimits[0] {
+		printLine("WARNING " + message)
+	} else {
+		printLine("OK " + message)
+	}
+
+	for _, node := range nodes {
+		if node.Running == false {
+			continue
+		}
+
+		pending := 0
+		for _, count := range node.MetricsGCQueueLength {
+			pending = pending + count
+		}
+		recordGauge("rabbitmq.node.metrics_gc_queue", float64(pending), "node", node.Name)
+		recordPerf(node.Name+"_metrics_gc_queue", float64(pending), "", perfLimit(gcLimits, 0), perfLimit(gcLimits, 1))
+		lines := []string{}
+		message := "node " + node.Name + " has the metrics of " + strconv.Itoa(pending) + " closed objects waiting to be collected"
+		if pending >= gcLimits[1] {
+			lines = append(lines, "CRITICAL "+message)
+		} else if pending >= gcLimits[0] {
+			lines = append(lines, "WARNING "+message)
+		} else {
+			lines = append(lines, "OK "+message)
+		}
+
+		if memory, ok := breakdowns[node.Name]; ok {
+			size := memory.category("mgmt_db")
+			recordPerf(node.Name+"_mgmt_db", float64(size), "B", strconv.FormatInt(memoryLimits[0], 10), strconv.FormatInt(memoryLimits[1], 10))
+			message := "node " + node.Name + " uses " + formatBytes(size) + " for the management database"
+			if size >= memoryLimits[1] {
+				lines = append(lines, "CRITICAL "+message)
+			} else if size >= memoryLimits[0] {
+				lines = append(lines, "WARNING "+message)
+			} else {
+				lines = append(lines, "OK "+message)
+			}
+		}
+
+		lines, _, _ = downgradeLines(node.Name, lines)
+		for _, line := range lines {
+			printLine(line)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+)
+
+/*
+statslessOverview builds the overview of a broker running the management plugin with disable_stats from the
+rabbitmq_prometheus endpoints of its nodes, otherwise by summing the per-queue counts. The per-queue counts go
+missing with the stats as well unless enable_queue_totals is set, so they are only the last resort.
+*/
+func statslessOverview(opt *options, host string) (*Overview, error) {
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		log.Println("Management stats are disabled on " + host + " and its nodes are unknown (" + err.Error() + "), summing the queues")
+		return summedOverview(opt, host)
+	}
+
+	// a single node is the host itself, whose name may not resolve from here
+	scraped := []string{host}
+	if len(nodes) > 1 {
+		scraped = []string{}
+		for _, node := range nodes {
+			scraped = append(scraped, nodeHost(node.Name))
+		}
+	}
+
+	over, err := prometheusTotals(opt, scraped)
+	if err == nil {
+		log.Println("Management stats are disabled on " + host + ", read the queue totals of its " + strconv.Itoa(len(scraped)) + " nodes from prometheus")
+		return over, nil
+	}
+	log.Println("Management stats are disabled on " + host + " and prometheus failed (" + err.Error() + "), summing the queues")
+
+	return summedOverview(opt, host)
+}
+
+/*
+summedOverview adds up the ready and unacknowledged messages of every queue in the cluster. Queues reporting
+no counts at all make it fail instead of being counted as empty.
+*/
+func summedOverview(opt *options, host string) (*Overview, error) {
+	over := &Overview{}
+	query := url.Values{}
+	query.Set("columns", "name,messages_ready,messages_unacknowledged")
+
+	_, err := apiPages(opt, host, "/api/queues", query, func(decoder *json.Decoder) error {
+		queue := struct {
+			Name          string `json:"name"`
+			MessagesReady *int   `json:"messages_ready"`
+			MessagesUnack *int   `json:"messages_unacknowledged"`
+		}{}
+		err := decodeItem(decoder, "/api/queues", query.Get("columns"), &queue)
+		if err != nil {
+			return err
+		}
+		if queue.MessagesReady == nil || queue.MessagesUnack == nil {
+			return errors.New("Queue " + queue.Name + " on " + host + " reports no message counts")
+		}
+
+		over.QueueTotals.MessagesReady = over.QueueTotals.MessagesReady + *queue.MessagesReady
+		over.QueueTotals.MessagesUnack = over.QueueTotals.MessagesUnack + *queue.MessagesUnack
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return over, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatslessOverviewSumsTheNodes(t *testing.T) {
+	api := map[string]string{
+		"/api/overview": `{"disable_stats": true, "enable_queue_totals": false}`,
+		"/api/nodes":    `[{"name": "rabbit@127.0.0.1", "running": true}, {"name": "rabbit@127.0.0.2", "running": true}]`,
+		// without enable_queue_totals the queues carry no counts either
+		"/api/queues": `{"items": [{"name": "orders"}], "page_count": 1}`,
+		"/metrics":    "rabbitmq_queue_messages_ready 300\nrabbitmq_queue_messages_unacked 7\n",
+	}
+	port := testHosts(t, map[string]map[string]string{
+		"127.0.0.1": api,
+		"127.0.0.2": {"/metrics": "rabbitmq_queue_messages_ready 200\nrabbitmq_queue_messages_unacked 3\n"},
+	})
+	arguments := []string{"overview", "-h", "127.0.0.1", "--port", port, "--prometheus-port", port, "--warning", "400,100", "--critical", "1000,200"}
+
+	lines := runCheck(t, arguments...)
+	expected := []string{"WARNING 500 messages ready", "OK 10 messages unacknowledged"}
+	if reflect.DeepEqual(lines, expected) == false {
+		t.Errorf("two nodes without stats read %q, expected %q", lines, expected)
+	}
+
+	// a node without prometheus leaves the queues, which report no counts here
+	delete(api, "/metrics")
+	lines = runCheck(t, arguments...)
+	if len(lines) != 1 || lines[0] != "UNKNOWN could not read the overview from any host" {
+		t.Errorf("no source of the totals reads %q", lines)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+storeOptions are the options of store-growth mode
+*/
+type storeOptions struct {
+	StoreWindow  time.Duration `long:"store-window" default:"1h" description:"The history the growth of the message stores is measured over in store-growth mode, kept between runs in --state-dir."`
+	StoreHorizon string        `long:"store-horizon" default:"24,6" description:"Warning and critical thresholds in hours before the disk alarm of a node goes off at the growth of its message store in store-growth mode."`
+}
+
+/*
+storeSample is the size of the message store of a node at a run of store-growth mode, kept in the state
+*/
+type storeSample struct {
+	Time  int64 `json:"time"`
+	Bytes int64 `json:"bytes"`
+}
+
+/*
+runStoreGrowth follows the size of the message store of every node across runs and forecasts when the disk
+alarm goes off at its growth over --store-window. A consumer gone missing or a retention set too long fills the
+store for hours before the alarm blocks every publisher, the forecast gives those hours to react.
+*/
+func runStoreGrowth(opt *options, hosts []string) {
+	horizon, err := limitMap(opt.StoreHorizon)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	if opt.StoreWindow <= 0 {
+		printUnknown("--store-window must be positive")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		nodes, err := fetchNodes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		sizes, err := storeSizes(opt, value)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		history := map[string][]storeSample{}
+		err = loadState(opt, "store-growth", &history)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		history = processStoreGrowth(opt, nodes, sizes, history, horizon)
+		err = saveState(opt, "store-growth", history)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the nodes and queues from any host")
+}
+
+/*
+storeSizes returns the persistent message bytes of the queues per node, the content of its message store. The
+replicas of quorum queues and mirrors of classic queues take as much room on their nodes as on the leader.
+*/
+func storeSizes(opt *options, host string) (map[string]int64, error) {
+	all := vhostScope(opt, "")
+	sizes := map[string]int64{}
+	_, err := listQueues(all, host, "", "name,vhost,node,members,slave_nodes,message_bytes_persistent", func(queue Queue) error {
+		for _, node := range replicaNodes(queue) {
+			sizes[node] = sizes[node] + queue.MessageBytesPersistent
+		}
+		return nil
+	})
+	return sizes, err
+}
+
+/*
+processStoreGrowth adds the sizes to the history, prints a summary line followed by a line per node and returns
+the history within --store-window for the next run
+*/
+func processStoreGrowth(opt *options, nodes []Node, sizes map[string]int64, history map[string][]storeSample, horizon []int) map[string][]storeSample {
+	now := time.Now()
+	kept := map[string][]storeSample{}
+	checked, warnings, criticals := 0, 0, 0
+	breaches := []string{}
+	lines := []string{}
+	for _, node := range nodes {
+		if node.Running == false {
+			continue
+		}
+		checked++
+
+		// the oldest sample within the window is the base of the growth, older ones are dropped
+		samples := []storeSample{}
+		for _, sample := range history[node.Name] {
+			if now.Sub(time.Unix(sample.Time, 0)) <= opt.StoreWindow {
+				samples = append(samples, sample)
+			}
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Time < samples[j].Time })
+		size := sizes[node.Name]
+		kept[node.Name] = append(samples, storeSample{Time: now.Unix(), Bytes: size})
+		recordGauge("rabbitmq.node.message_store_bytes", float64(size), "node", node.Name)
+
+		message := "node " + node.Name + " message store " + formatBytes(size)
+		if len(samples) == 0 || now.Unix() <= samples[0].Time {
+			lines = append(lines, "OK "+message+", collecting the history of its growth")
+			continue
+		}
+		hours := float64(now.Unix()-samples[0].Time) / 3600
+		growth := float64(size-samples[0].Bytes) / hours
+		recordGauge("rabbitmq.node.message_store_growth_bytes_per_hour", growth, "node", node.Name)
+		recordPerf(node.Name+"_store_growth", growth, "B", "", "")
+		message = message + ", growing " + formatBytes(int64(growth)) + "/h"
+		if growth <= 0 {
+			lines = append(lines, "OK "+message)
+			continue
+		}
+
+		left := float64(node.DiskFree-node.DiskFreeLimit) / growth
+		message = message + ", disk alarm in " + strconv.FormatFloat(left, 'f', 1, 64) + "h at this growth"
+		line := "OK " + message
+		if left < float64(horizon[1]) {
+			line = "CRITICAL " + message
+		} else if left < float64(horizon[0]) {
+			line = "WARNING " + message
+		}
+		line = downgradeLine(node.Name, line)
+		switch lineState(line) {
+		case "CRITICAL":
+			criticals++
+			breaches = append(breaches, line)
+		case "WARNING":
+			warnings++
+			breaches = append(breaches, line)
+		case "OK":
+			lines = append(lines, line)
+		}
+	}
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("message stores", checked, warnings, criticals))
+	for _, line := range append(breaches, lines...) {
+		printLine(line)
+	}
+	return kept
+}
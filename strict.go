@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// strictDecoding is set by --strict, the responses are then checked for the fields the checks read
+var strictDecoding bool
+
+/*
+checkFields returns an error naming the fields the checks expect in a decoded response but the api left out,
+with --strict. Fields left out decode as zero values, which a check would evaluate as if the broker reported
+them, so a broker whose api differs from the expected one, e.g. a much older or newer rabbitmq, has to be told
+apart. columns are the comma separated fields requested from a listing.
+*/
+func checkFields(method, path string, raw []byte, result interface{}, columns string) error {
+	if strictDecoding == false {
+		return nil
+	}
+
+	requested := map[string]bool{}
+	for _, column := range strings.Split(columns, ",") {
+		if column != "" {
+			requested[strings.SplitN(column, ".", 2)[0]] = true
+		}
+	}
+	found := map[string]bool{}
+	missingFields(raw, reflect.TypeOf(result), requested, "", found)
+	if len(found) == 0 {
+		return nil
+	}
+
+	missing := []string{}
+	for name := range found {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	message := method + " " + strings.SplitN(path, "?", 2)[0] + " answered without " + strings.Join(missing, ", ") +
+		", the api of this broker differs from the one the checks expect"
+	rememberFailure(message)
+	return errors.New(message)
+}
+
+/*
+missingFields adds to found the fields of the type missing from the json object: the ones tagged required:"true"
+and the scalar ones among the requested columns. Objects and lists of objects present are checked in turn,
+while an object left out as a whole, e.g. the message_stats of an idle queue, is not an error.
+*/
+func missingFields(raw []byte, kind reflect.Type, requested map[string]bool, prefix string, found map[string]bool) {
+	for kind.Kind() == reflect.Ptr {
+		kind = kind.Elem()
+	}
+
+	if kind.Kind() == reflect.Slice {
+		items := []json.RawMessage{}
+		if json.Unmarshal(raw, &items) == nil {
+			for _, item := range items {
+				missingFields(item, kind.Elem(), requested, prefix, found)
+			}
+		}
+		return
+	}
+	if kind.Kind() != reflect.Struct {
+		return
+	}
+
+	object := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &object) != nil {
+		return
+	}
+	for index := 0; index < kind.NumField(); index++ {
+		field := kind.Field(index)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		value, ok := object[name]
+		if ok == false {
+			scalar := field.Type.Kind() != reflect.Struct && field.Type.Kind() != reflect.Slice && field.Type.Kind() != reflect.Map
+			if field.Tag.Get("required") == "true" || (requested[name] && scalar) {
+				found[prefix+name] = true
+			}
+			continue
+		}
+		missingFields(value, field.Type, nil, prefix+name+".", found)
+	}
+}
+
+/*
+decodeItem decodes an item of a listing into item, checking it holds the requested columns with --strict
+*/
+func decodeItem(decoder *json.Decoder, path, columns string, item interface{}) error {
+	if strictDecoding == false {
+		return decoder.Decode(item)
+	}
+
+	var raw json.RawMessage
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(raw, item)
+	if err != nil {
+		return err
+	}
+	return checkFields("GET", path, raw, item, columns)
+}
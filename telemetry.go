@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the numeric enums of the otlp protocol used here
+const (
+	otlpSpanKindClient   = 3
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+/*
+otlpAttribute is a key value pair in the otlp json encoding, only string values are used
+*/
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+/*
+otlpSpan is a span in the otlp json encoding
+*/
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+/*
+otlpDataPoint is a gauge data point in the otlp json encoding
+*/
+type otlpDataPoint struct {
+	AsDouble     float64         `json:"asDouble"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+}
+
+/*
+telemetry collects the spans of the api calls and the gauges of the collected values of a run, to be
+exported over otlp/http when the run ends. All the api calls of a run share one trace.
+*/
+type telemetry struct {
+	mutex   sync.Mutex
+	traceID string
+	rootID  string
+	start   time.Time
+	spans   []otlpSpan
+	gauges  map[string][]otlpDataPoint
+}
+
+// tracer is the telemetry of the run, nil unless --otlp-endpoint is set
+var tracer *telemetry
+
+/*
+randomHex returns size random bytes hex encoded, as used for trace and span ids
+*/
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/*
+attributes builds otlp attributes from key value pairs
+*/
+func attributes(pairs ...string) []otlpAttribute {
+	result := []otlpAttribute{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		attribute := otlpAttribute{Key: pairs[i]}
+		attribute.Value.StringValue = pairs[i+1]
+		result = append(result, attribute)
+	}
+	return result
+}
+
+/*
+unixNano formats a time the way otlp json expects 64 bit integers, as a string
+*/
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+/*
+startTelemetry starts collecting when an otlp endpoint is configured
+*/
+func startTelemetry(opt *options) {
+	if opt.OtlpEndpoint == "" {
+		return
+	}
+
+	tracer = &telemetry{
+		traceID: randomHex(16),
+		rootID:  randomHex(8),
+		start:   time.Now(),
+		gauges:  map[string][]otlpDataPoint{},
+	}
+}
+
+/*
+traceparent returns a new span id and the w3c traceparent header carrying it, so proxies in front of the
+api can join the trace
+*/
+func (t *telemetry) traceparent() (string, string) {
+	spanID := randomHex(8)
+	return spanID, "00-" + t.traceID + "-" + spanID + "-01"
+}
+
+/*
+recordSpan adds the span of a finished api call
+*/
+func (t *telemetry) recordSpan(spanID, name string, start time.Time, attrs []otlpAttribute, err error) {
+	span := otlpSpan{
+		TraceID:           t.traceID,
+		SpanID:            spanID,
+		ParentSpanID:      t.rootID,
+		Name:              name,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(time.Now()),
+		Attributes:        attrs,
+	}
+	span.Status.Code = otlpStatusOk
+	if err != nil {
+		span.Status.Code = otlpStatusError
+		span.Status.Message = err.Error()
+	}
+
+	t.mutex.Lock()
+	t.spans = append(t.spans, span)
+	t.mutex.Unlock()
+}
+
+/*
+recordGauge adds a collected value to the exported gauges, the result log, the json document and the metrics
+of --listen, a no-op when they are all off
+*/
+func recordGauge(name string, value float64, pairs ...string) {
+	if currentCluster != "" {
+		pairs = append(append([]string{}, pairs...), "cluster", currentCluster)
+	}
+	recordResultValue(name, value, pairs...)
+	recordReportValue(name, value, pairs...)
+	recordExportedValue(name, value, pairs...)
+	if tracer == nil {
+		return
+	}
+
+	point := otlpDataPoint{AsDouble: value, TimeUnixNano: unixNano(time.Now()), Attributes: attributes(pairs...)}
+	tracer.mutex.Lock()
+	tracer.gauges[name] = append(tracer.gauges[name], point)
+	tracer.mutex.Unlock()
+}
+
+/*
+exportTelemetry sends the spans and gauges of the run to the otlp/http endpoint. Failing to export only
+gets logged, it must not change the result of the check.
+*/
+func exportTelemetry(opt *options) {
+	if tracer == nil {
+		return
+	}
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	root := otlpSpan{
+		TraceID:           tracer.traceID,
+		SpanID:            tracer.rootID,
+		Name:              "check_rabbitmq " + opt.Mode,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: unixNano(tracer.start),
+		EndTimeUnixNano:   unixNano(time.Now()),
+		Attributes:        attributes("check.mode", opt.Mode, "check.hosts", strings.Join(opt.Host, ",")),
+	}
+	root.Status.Code = otlpStatusOk
+	resource := map[string]interface{}{"attributes": attributes("service.name", opt.OtlpServiceName)}
+	scope := map[string]string{"name": "check_rabbitmq"}
+
+	traces := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": scope, "spans": append([]otlpSpan{root}, tracer.spans...)}},
+		}},
+	}
+	err := postOtlp(opt, "/v1/traces", traces)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	if len(tracer.gauges) == 0 {
+		return
+	}
+	names := []string{}
+	for name := range tracer.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := []interface{}{}
+	for _, name := range names {
+		metrics = append(metrics, map[string]interface{}{"name": name, "gauge": map[string]interface{}{"dataPoints": tracer.gauges[name]}})
+	}
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": scope, "metrics": metrics}},
+		}},
+	}
+	err = postOtlp(opt, "/v1/metrics", payload)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+/*
+postOtlp posts an otlp json payload to a signal path of the collector
+*/
+func postOtlp(opt *options, signal string, payload interface{}) error {
+	encoded, err := jsonReader(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := httpClient.Post(strings.TrimSuffix(opt.OtlpEndpoint, "/")+signal, "application/json", encoded)
+	if err != nil {
+		return err
+	}
+	closeResponse(response)
+
+	if response.StatusCode >= 400 {
+		return errors.New("Exporting " + signal + " to " + opt.OtlpEndpoint + " returned " + response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the queue arguments carrying thresholds owned by the application declaring the queue, policies use
+// the same keys without the x- prefix
+const (
+	warningArgument  = "x-monitoring-warning"
+	criticalArgument = "x-monitoring-critical"
+)
+
+// thresholdColumns are fetched on top of queueColumns when queues may carry their own thresholds
+const thresholdColumns = ",arguments,effective_policy_definition"
+
+/*
+queueLimits returns the limits a queue declares for itself under the argument, falling back on the same key of
+its effective policy and then on the limits of the command line. A value is either "ready,unacknowledged"
+like --warning or a single number used for both.
+*/
+func queueLimits(queue Queue, argument string, limits []int) []int {
+	if value, ok := queue.Arguments[argument]; ok {
+		if own, err := parseOwnLimits(value); err == nil {
+			return own
+		}
+	}
+	if value, ok := queue.EffectivePolicyDefinition[argument[2:]]; ok {
+		if own, err := parseOwnLimits(value); err == nil {
+			return own
+		}
+	}
+	return limits
+}
+
+/*
+parseOwnLimits reads a threshold value from queue arguments or policy definitions
+*/
+func parseOwnLimits(value interface{}) ([]int, error) {
+	switch typed := value.(type) {
+	case float64:
+		return []int{int(typed), int(typed)}, nil
+	case string:
+		if single, err := strconv.Atoi(typed); err == nil {
+			return []int{single, single}, nil
+		}
+		return limitMap(typed)
+	}
+	return nil, strconv.ErrSyntax
+}
+
+/*
+queueOverride is a --queue-threshold, the limits of the queues matching its pattern
+*/
+type queueOverride struct {
+	pattern  *regexp.Regexp
+	warning  []int
+	critical []int
+}
+
+/*
+parseQueueOverrides parses the --queue-threshold values, a pattern of --include-queues followed by a colon and
+the warning and critical limits, each a single number for the ready and unacknowledged messages alike, e.g.
+orders.*:1000,5000
+*/
+func parseQueueOverrides(values []string) ([]queueOverride, error) {
+	overrides := []queueOverride{}
+	for _, value := range values {
+		// the pattern may hold colons itself, the limits never do
+		index := strings.LastIndex(value, ":")
+		if index <= 0 {
+			return nil, errors.New("Invalid --queue-threshold " + value + ", expected pattern:warning,critical")
+		}
+		pattern, err := compileQueuePattern(value[:index])
+		if err != nil {
+			return nil, err
+		}
+		limits, err := limitMap(value[index+1:])
+		if err != nil {
+			return nil, errors.New("Invalid --queue-threshold " + value + ", expected pattern:warning,critical")
+		}
+		overrides = append(overrides, queueOverride{
+			pattern:  pattern,
+			warning:  []int{limits[0], limits[0]},
+			critical: []int{limits[1], limits[1]},
+		})
+	}
+	return overrides, nil
+}
+
+/*
+overrideLimits returns the limits of the first --queue-threshold matching the vhost/name of the queue, the given
+ones when none does
+*/
+func overrideLimits(queue Queue, overrides []queueOverride, warning, critical []int) ([]int, []int) {
+	for _, override := range overrides {
+		if override.pattern.MatchString(queue.Vhost + "/" + queue.Name) {
+			return override.warning, override.critical
+		}
+	}
+	return warning, critical
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+/*
+throughputOptions are the options of throughput mode
+*/
+type throughputOptions struct {
+	HotQueues        []string `long:"hot-queue" description:"A regular expression on vhost/name selecting hot path queues checked in throughput mode, on top of the queues with the x-monitoring-hot argument or monitoring-hot policy key. Repeat for every pattern."`
+	ThroughputDrop   string   `long:"throughput-drop" default:"50,80" description:"Warning and critical thresholds in percent for the deliver rate of a hot queue dropping below its baseline while the publish rate holds in throughput mode."`
+	PublishTolerance int      `long:"publish-tolerance" default:"20" description:"How many percent the publish rate may drop below its baseline and still count as steady in throughput mode."`
+	BaselineSamples  int      `long:"baseline-samples" default:"10" description:"The number of previous runs the baseline rates of throughput mode are averaged over."`
+}
+
+// hotArgument tags a queue as a hot path, policies use the same key without the x- prefix
+const hotArgument = "x-monitoring-hot"
+
+// minBaselineSamples are the runs needed before a baseline is trusted
+const minBaselineSamples = 3
+
+/*
+throughputBaseline are the deliver and publish rates of a hot queue over the previous runs
+*/
+type throughputBaseline struct {
+	Deliver []float64 `json:"deliver"`
+	Publish []float64 `json:"publish"`
+}
+
+/*
+isHotQueue tells whether the queue is tagged as a hot path by its arguments, its policy or a --hot-queue pattern
+*/
+func isHotQueue(queue Queue, patterns []*regexp.Regexp) bool {
+	for _, value := range []interface{}{queue.Arguments[hotArgument], queue.EffectivePolicyDefinition[hotArgument[2:]]} {
+		if value == true || value == "true" {
+			return true
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(queue.Vhost + "/" + queue.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+average returns the mean of the values, 0 when there are none
+*/
+func average(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+/*
+runThroughput compares the deliver rate of every hot queue with its baseline over the previous runs. A deliver
+rate collapsing while the publish rate holds is the signature of stuck consumers, which absolute thresholds on
+the rates miss as every queue has its own normal.
+*/
+func runThroughput(opt *options, hosts []string) {
+	limits, err := limitMap(opt.ThroughputDrop)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	patterns := []*regexp.Regexp{}
+	for _, value := range opt.HotQueues {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			printUnknown(err.Error())
+			return
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		hot := []Queue{}
+		columns := queueColumns + ",message_stats" + thresholdColumns
+		_, err := listQueues(queueScope(opt), value, opt.QueuePattern, columns, func(queue Queue) error {
+			if isHotQueue(queue, patterns) {
+				hot = append(hot, queue)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		baselines := map[string]throughputBaseline{}
+		err = loadState(opt, "throughput", &baselines)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		baselines = processThroughput(opt, hot, baselines, limits)
+		err = saveState(opt, "throughput", baselines)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+processThroughput prints a summary line followed by the hot queues whose deliver rate collapsed, returning the
+baselines updated with the rates of this run. The rates of a collapsed queue are kept out of its baseline, so a
+stuck consumer keeps alerting instead of becoming the new normal.
+*/
+func processThroughput(opt *options, queues []Queue, baselines map[string]throughputBaseline, limits []int) map[string]throughputBaseline {
+	// pages arrive in any order, sort so successive runs print the same output
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Vhost+"/"+queues[i].Name < queues[j].Vhost+"/"+queues[j].Name
+	})
+
+	updated := map[string]throughputBaseline{}
+	warnings, criticals := 0, 0
+	breaches := []string{}
+	for _, queue := range queues {
+		name := queue.Vhost + "/" + queue.Name
+		deliver := queue.MessageStats.DeliverGetDetails.value()
+		publish := queue.MessageStats.PublishDetails.value()
+		recordGauge("rabbitmq.queue.deliver_get_rate", deliver, "queue", name)
+		recordPerf(name+"_deliver_rate", deliver, "", "", "")
+
+		baseline := baselines[name]
+		baseDeliver, basePublish := average(baseline.Deliver), average(baseline.Publish)
+		line := ""
+		steady := publish >= basePublish*float64(100-opt.PublishTolerance)/100
+		if len(baseline.Deliver) >= minBaselineSamples && baseDeliver > 0 && steady {
+			drop := int((baseDeliver - deliver) * 100 / baseDeliver)
+			message := name + " deliver rate " + strconv.FormatFloat(deliver, 'f', 1, 64) + " msgs/sec is " + strconv.Itoa(drop) +
+				"% below its baseline of " + strconv.FormatFloat(baseDeliver, 'f', 1, 64) + " msgs/sec while publishing holds at " +
+				strconv.FormatFloat(publish, 'f', 1, 64) + " msgs/sec, its consumers look stuck"
+			if drop >= limits[1] {
+				line = "CRITICAL " + message
+			} else if drop >= limits[0] {
+				line = "WARNING " + message
+			}
+		}
+
+		if line == "" {
+			baseline.Deliver = lastSamples(append(baseline.Deliver, deliver), opt.BaselineSamples)
+			baseline.Publish = lastSamples(append(baseline.Publish, publish), opt.BaselineSamples)
+		}
+		updated[name] = baseline
+
+		if line = downgradeLine(name, line); line == "" {
+			continue
+		}
+		if lineState(line) == "CRITICAL" {
+			criticals++
+		} else {
+			warnings++
+		}
+		breaches = append(breaches, line)
+	}
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("hot queues in "+queueScopeName(opt), len(queues), warnings, criticals))
+	for _, line := range breaches {
+		printLine(line)
+	}
+	return updated
+}
+
+/*
+lastSamples keeps the newest count samples
+*/
+func lastSamples(samples []float64, count int) []float64 {
+	if count > 0 && len(samples) > count {
+		return samples[len(samples)-count:]
+	}
+	return samples
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// tlsClient is the tls configuration of the connections to the brokers, built from the --tls-* options by configureTLS
+var tlsClient = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(64)}
+
+/*
+configureTLS builds tlsClient from the options: the ca bundle trusted on top of the system roots, the client
+certificate presented to brokers requiring one, the lowest version negotiated and whether certificates are
+verified at all. The api transport is rebuilt with it.
+*/
+func configureTLS(opt *options) error {
+	config := &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(64),
+		InsecureSkipVerify: opt.TLSInsecure,
+	}
+
+	version, ok := tlsVersions[opt.TLSMinVersion]
+	if ok == false {
+		return errors.New("Unknown tls version " + opt.TLSMinVersion)
+	}
+	config.MinVersion = version
+
+	if opt.TLSCAFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		bundle, err := ioutil.ReadFile(opt.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		if roots.AppendCertsFromPEM(bundle) == false {
+			return errors.New("No pem certificates found in " + opt.TLSCAFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if (opt.TLSCertFile == "") != (opt.TLSKeyFile == "") {
+		return errors.New("--tls-cert-file and --tls-key-file have to be given together")
+	}
+	if opt.TLSCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(opt.TLSCertFile, opt.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	tlsClient = config
+	httpClient.Transport = newTransport()
+	return nil
+}
+
+/*
+probeTLS returns the configuration of the handshakes inspecting a listener, which verify the chain by hand and
+accept any version so what the listener negotiates can be reported. The client certificate is still presented.
+*/
+func probeTLS(host string) *tls.Config {
+	config := tlsClient.Clone()
+	config.ServerName = host
+	config.InsecureSkipVerify = true
+	config.MinVersion = 0
+	return config
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+/*
+totalsOptions are the options of object-totals mode
+*/
+type totalsOptions struct {
+	TotalsWarning  string `long:"totals-warning" description:"Warning ranges for the object totals in object-totals mode as counter=range pairs, e.g. connections=5000,consumers=1: using nagios ranges."`
+	TotalsCritical string `long:"totals-critical" description:"Critical ranges for the object totals in object-totals mode, the counters are connections, channels, exchanges, queues and consumers."`
+	TotalsTop      int    `long:"totals-top" default:"5" description:"The number of clients, user and client host, listed with their connections and channels when those totals are beyond their ranges in object-totals mode, 0 lists none."`
+}
+
+// totalsCounters are the object_totals counters in the order they are reported
+var totalsCounters = []string{"connections", "channels", "exchanges", "queues", "consumers"}
+
+/*
+ObjectTotals represents the object_totals substructure of the overview
+*/
+type ObjectTotals struct {
+	Connections int `json:"connections" required:"true"`
+	Channels    int `json:"channels" required:"true"`
+	Exchanges   int `json:"exchanges" required:"true"`
+	Queues      int `json:"queues" required:"true"`
+	Consumers   int `json:"consumers" required:"true"`
+}
+
+/*
+counters returns the totals keyed like totalsCounters
+*/
+func (totals ObjectTotals) counters() map[string]int {
+	return map[string]int{
+		"connections": totals.Connections,
+		"channels":    totals.Channels,
+		"exchanges":   totals.Exchanges,
+		"queues":      totals.Queues,
+		"consumers":   totals.Consumers,
+	}
+}
+
+/*
+clientLoad is what the connections of a user from a single client host hold
+*/
+type clientLoad struct {
+	client      string
+	connections int
+	channels    int
+}
+
+/*
+runObjectTotals checks every object_totals counter of the overview against its own warning and critical range,
+a cheap sanity check of the shape of the cluster from a single api call. When the connections or channels are
+beyond their ranges the clients holding the most are listed, which points at the one leaking them.
+*/
+func runObjectTotals(opt *options, hosts []string) {
+	warning, err := parseRanges(opt.TotalsWarning, totalsCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	critical, err := parseRanges(opt.TotalsCritical, totalsCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		over := &Overview{}
+		err := apiRequest(opt, value, "GET", "/api/overview", nil, over)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		state := processObjectTotals(over.ObjectTotals, warning, critical)
+		if state != "OK" && opt.TotalsTop > 0 {
+			clients, err := topClients(opt, value)
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+			processTopClients(clients, state, opt.TotalsTop)
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the object totals from any host")
+}
+
+/*
+processObjectTotals prints a line per counter with the state of its ranges, returning the worst state of the
+connections and channels
+*/
+func processObjectTotals(totals ObjectTotals, warning, critical map[string]nagiosRange) string {
+	counters := totals.counters()
+	worst := "OK"
+	for _, name := range totalsCounters {
+		count := counters[name]
+		recordGauge("rabbitmq.object_totals."+name, float64(count))
+		recordPerf(name, float64(count), "", perfRange(warning, name), perfRange(critical, name))
+		state := rangeState(float64(count), warning, critical, name)
+		printLine(state + " " + strconv.Itoa(count) + " " + name)
+
+		if (name == "connections" || name == "channels") && (state == "CRITICAL" || worst == "OK") {
+			worst = state
+		}
+	}
+	return worst
+}
+
+/*
+topClients counts the connections and channels of every user and client host over all vhosts, most
+connections first
+*/
+func topClients(opt *options, host string) ([]clientLoad, error) {
+	loads := map[string]*clientLoad{}
+	all := vhostScope(opt, "")
+	_, err := listConnections(all, host, func(connection Connection) error {
+		client := connection.User + "@" + connection.PeerHost
+		if loads[client] == nil {
+			loads[client] = &clientLoad{client: client}
+		}
+		loads[client].connections++
+		loads[client].channels = loads[client].channels + connection.Channels
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []clientLoad{}
+	for _, load := range loads {
+		clients = append(clients, *load)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].connections != clients[j].connections {
+			return clients[i].connections > clients[j].connections
+		}
+		return clients[i].client < clients[j].client
+	})
+	return clients, nil
+}
+
+/*
+processTopClients prints the clients holding the most connections with the state of the breached totals
+*/
+func processTopClients(clients []clientLoad, state string, top int) {
+	for index, load := range clients {
+		if index == top {
+			break
+		}
+		printLine(state + " client " + load.client + " holds " + strconv.Itoa(load.connections) + " connections and " +
+			strconv.Itoa(load.channels) + " channels")
+	}
+}
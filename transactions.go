@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+/*
+transactionOptions are the options of transactions mode
+*/
+type transactionOptions struct {
+	TxVhosts []string `long:"tx-vhost" description:"A high throughput vhost where channels must not use transactions, checked in transactions mode. Repeat for every vhost."`
+}
+
+/*
+runTransactions alerts on the channels using amqp transactions in the vhosts given with --tx-vhosts. A
+transaction per publish costs a disk sync, on a high throughput vhost an accidental tx.select is a common
+throughput killer that the counts and rates do not explain.
+*/
+func runTransactions(opt *options, hosts []string) {
+	if len(opt.TxVhosts) == 0 {
+		printUnknown("The transactions mode requires at least one --tx-vhost")
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		checked := 0
+		lines := []string{}
+		var err error
+		for _, vhost := range opt.TxVhosts {
+			_, err = listChannels(vhostScope(opt, vhost), value, func(channel Channel) error {
+				checked++
+				if channel.Transactional {
+					line := downgradeLine(channel.Vhost+"/"+channel.Name, "WARNING channel "+channel.Name+" of "+channel.User+" in vhost "+channel.Vhost+" uses transactions")
+					if line != "" {
+						lines = append(lines, line)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		sort.Strings(lines)
+		state := "OK"
+		if len(lines) > 0 {
+			state = "WARNING"
+		}
+		printLine(state + " " + summaryCounts("channels", checked, len(lines), 0))
+		for _, line := range lines {
+			printLine(line)
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the channels from any host")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+/*
+ttlOptions are the options of ttl-audit mode
+*/
+type ttlOptions struct {
+	DurableVhosts []string `long:"durable-vhost" description:"A regular expression selecting the vhosts where losing messages is unacceptable, audited in ttl-audit mode, e.g. ^payments. Repeatable."`
+	MessageTTL    string   `long:"message-ttl" default:"86400,3600" description:"Warning and critical thresholds in seconds, a message-ttl below them is reported in ttl-audit mode."`
+}
+
+/*
+queueSetting returns the number a queue sets under the argument, falling back on its effective policy which
+uses the key without the x- prefix, e.g. x-expires and expires
+*/
+func queueSetting(queue Queue, argument string) (float64, bool) {
+	if value, ok := queue.Arguments[argument].(float64); ok {
+		return value, true
+	}
+	value, ok := queue.EffectivePolicyDefinition[argument[2:]].(float64)
+	return value, ok
+}
+
+/*
+runTTLAudit reports the queues of the --durable-vhost vhosts that expire or drop their messages early. An
+expiring queue takes its durable messages with it and a short message-ttl discards them without anybody
+consuming, both silently, so they are audited rather than waited for.
+*/
+func runTTLAudit(opt *options, hosts []string) {
+	if len(opt.DurableVhosts) == 0 {
+		printUnknown("The ttl-audit mode requires at least one --durable-vhost")
+		return
+	}
+	patterns := []*regexp.Regexp{}
+	for _, value := range opt.DurableVhosts {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			printUnknown(err.Error())
+			return
+		}
+		patterns = append(patterns, pattern)
+	}
+	limits, err := limitMap(opt.MessageTTL)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err := processTTLAudit(opt, value, patterns, limits)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the queues from any host")
+}
+
+/*
+processTTLAudit prints a summary line followed by the offending queues
+*/
+func processTTLAudit(opt *options, host string, patterns []*regexp.Regexp, limits []int) error {
+	offending := []queueBreach{}
+	checked, warnings, criticals := 0, 0, 0
+	all := vhostScope(opt, "")
+	_, err := listQueues(all, host, opt.QueuePattern, "name,vhost,arguments,effective_policy_definition", func(queue Queue) error {
+		durable := false
+		for _, pattern := range patterns {
+			if pattern.MatchString(queue.Vhost) {
+				durable = true
+				break
+			}
+		}
+		if durable == false {
+			return nil
+		}
+		checked++
+
+		name := queue.Vhost + "/" + queue.Name
+		lines := []string{}
+		if expires, ok := queueSetting(queue, "x-expires"); ok {
+			lines = append(lines, "CRITICAL queue "+name+" expires after "+(time.Duration(expires)*time.Millisecond).String()+" unused")
+		}
+		if ttl, ok := queueSetting(queue, "x-message-ttl"); ok {
+			message := "queue " + name + " drops messages after " + (time.Duration(ttl) * time.Millisecond).String()
+			if ttl < float64(limits[1])*1000 {
+				lines = append(lines, "CRITICAL "+message)
+			} else if ttl < float64(limits[0])*1000 {
+				lines = append(lines, "WARNING "+message)
+			}
+		}
+
+		lines, queueWarnings, queueCriticals := downgradeLines(name, lines)
+		if queueCriticals > 0 {
+			criticals++
+		} else if queueWarnings > 0 {
+			warnings++
+		}
+		for _, line := range lines {
+			offending = append(offending, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(offending, func(i, j int) bool {
+		if offending[i].vhost != offending[j].vhost {
+			return offending[i].vhost < offending[j].vhost
+		}
+		return offending[i].name < offending[j].name
+	})
+
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	recordPerf("ttl_offending", float64(warnings+criticals), "", "", "")
+	printLine(state + " " + summaryCounts("queues of durable vhosts", checked, warnings, criticals))
+	for _, breach := range offending {
+		printLine(breach.line)
+	}
+	return nil
+}
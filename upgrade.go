@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+upgradeOptions are the options of upgrade-ready mode
+*/
+type upgradeOptions struct {
+	UpgradeDiskHeadroom string `long:"upgrade-disk-headroom" default:"2G" description:"The free disk every node needs beyond its free disk limit in upgrade-ready mode, room for the new release and for the queues to recover, e.g. 5G."`
+}
+
+/*
+FeatureFlag representation from /api/feature-flags
+*/
+type FeatureFlag struct {
+	Name      string `json:"name" required:"true"`
+	State     string `json:"state" required:"true"`
+	Stability string `json:"stability"`
+}
+
+/*
+DeprecatedFeature representation from /api/deprecated-features/used
+*/
+type DeprecatedFeature struct {
+	Name  string `json:"name" required:"true"`
+	Phase string `json:"deprecation_phase"`
+}
+
+/*
+runUpgradeReady answers whether the cluster can take a rolling upgrade: every stable feature flag enabled, no
+deprecated feature in use, enough free disk on every node and no node whose restart would cost a quorum queue
+its majority or a mirrored queue its last synchronised mirror. Any blocker makes it a CRITICAL NO-GO.
+*/
+func runUpgradeReady(opt *options, hosts []string) {
+	headroom, err := parseSize(opt.UpgradeDiskHeadroom)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	lines := []string{}
+	checked := false
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		clusterLines, err := upgradeClusterChecks(opt, value, headroom)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		lines = append(lines, clusterLines...)
+		checked = true
+		break
+	}
+	if checked == false {
+		lines = append(lines, "UNKNOWN could not read the feature flags and nodes from any host")
+	}
+
+	// whether a restart is safe is told by every node about itself
+	for _, value := range hosts {
+		lines = append(lines, upgradeNodeChecks(opt, value)...)
+	}
+
+	failed := 0
+	for _, line := range lines {
+		if lineState(line) != "OK" {
+			failed++
+		}
+	}
+	total := strconv.Itoa(len(lines))
+	switch worstState(lines) {
+	case "OK":
+		printLine("OK GO: all " + total + " upgrade checks passed")
+	case "UNKNOWN":
+		printLine("UNKNOWN " + strconv.Itoa(failed) + " of " + total + " upgrade checks could not run")
+	default:
+		printLine("CRITICAL NO-GO: " + strconv.Itoa(failed) + " of " + total + " upgrade checks failed")
+	}
+	for _, line := range lines {
+		printLine(line)
+	}
+}
+
+/*
+upgradeClusterChecks checks the feature flags, the deprecated features and the free disk of every node through
+the host, returning a line per check
+*/
+func upgradeClusterChecks(opt *options, host string, headroom int64) ([]string, error) {
+	version, err := hostVersion(opt, host)
+	if err != nil {
+		return nil, err
+	}
+	featureFlags := []FeatureFlag{}
+	err = apiRequest(opt, host, "GET", "/api/feature-flags", nil, &featureFlags)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := fetchNodes(opt, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// the deprecated features are only tracked from 3.13 on
+	deprecated := []DeprecatedFeature{}
+	if version.atLeast(3, 13, 0) {
+		err = apiRequest(opt, host, "GET", "/api/deprecated-features/used", nil, &deprecated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lines := []string{}
+	disabled := []string{}
+	for _, flag := range featureFlags {
+		if flag.Stability != "experimental" && flag.State != "enabled" {
+			disabled = append(disabled, flag.Name)
+		}
+	}
+	if len(disabled) > 0 {
+		lines = append(lines, "CRITICAL feature flags not enabled: "+strings.Join(disabled, ", ")+
+			" - the next release may require them, enable them before upgrading")
+	} else {
+		lines = append(lines, "OK all "+strconv.Itoa(len(featureFlags))+" stable feature flags are enabled")
+	}
+
+	if version.atLeast(3, 13, 0) == false {
+		lines = append(lines, "OK deprecated features are not tracked by rabbitmq "+version.String())
+	} else if len(deprecated) > 0 {
+		used := []string{}
+		for _, feature := range deprecated {
+			used = append(used, feature.Name+" ("+feature.Phase+")")
+		}
+		lines = append(lines, "CRITICAL deprecated features in use: "+strings.Join(used, ", "))
+	} else {
+		lines = append(lines, "OK no deprecated feature is in use")
+	}
+
+	for _, node := range nodes {
+		if node.Running == false {
+			lines = append(lines, "CRITICAL node "+node.Name+" is not running")
+			continue
+		}
+		free := int64(node.DiskFree) - int64(node.DiskFreeLimit)
+		message := "node " + node.Name + " has " + formatBytes(free) + " free beyond its disk limit"
+		if free < headroom {
+			lines = append(lines, "CRITICAL "+message+", below --upgrade-disk-headroom "+formatBytes(headroom))
+		} else {
+			lines = append(lines, "OK "+message)
+		}
+	}
+	return lines, nil
+}
+
+/*
+upgradeNodeChecks asks the host whether restarting its node would cost a quorum queue its majority or, before
+4.0 removed the mirroring, a classic queue its last synchronised mirror
+*/
+func upgradeNodeChecks(opt *options, host string) []string {
+	checks := []healthCheck{{"quorum critical", "/api/health/checks/node-is-quorum-critical", false}}
+	version, err := hostVersion(opt, host)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	if err != nil || version.atLeast(4, 0, 0) == false {
+		checks = append(checks, healthCheck{"mirror sync critical", "/api/health/checks/node-is-mirror-sync-critical", false})
+	}
+
+	lines := []string{}
+	for _, check := range checks {
+		err := runHealthCheck(opt, host, check)
+		if err != nil {
+			lines = append(lines, "CRITICAL "+host+" is "+check.name+": "+err.Error())
+		} else {
+			lines = append(lines, "OK "+host+" is not "+check.name)
+		}
+	}
+	return lines
+}
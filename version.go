@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+brokerVersion is the rabbitmq version a host reports in its overview
+*/
+type brokerVersion struct {
+	major, minor, patch int
+}
+
+/*
+cachedVersion is the version of a host with the time it is to be asked again
+*/
+type cachedVersion struct {
+	version brokerVersion
+	expires time.Time
+}
+
+// versionTTL is how long a long running process trusts the version of a host, brokers get upgraded in place
+const versionTTL = 5 * time.Minute
+
+// hostVersions caches the version of every host, a fleet being upgraded node by node mixes versions
+var hostVersions = map[string]cachedVersion{}
+
+var versionMutex sync.Mutex
+
+/*
+parseVersion parses a version such as 3.12.4, ignoring pre-release and build suffixes like -rc.1 or +2.g1234
+*/
+func parseVersion(value string) (brokerVersion, error) {
+	release := strings.FieldsFunc(value, func(r rune) bool { return r == '-' || r == '+' })
+	if len(release) == 0 {
+		return brokerVersion{}, errors.New("Unknown rabbitmq version " + value)
+	}
+	parts := strings.SplitN(release[0], ".", 3)
+	numbers := []int{0, 0, 0}
+	for index, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return brokerVersion{}, errors.New("Unknown rabbitmq version " + value)
+		}
+		numbers[index] = number
+	}
+	return brokerVersion{numbers[0], numbers[1], numbers[2]}, nil
+}
+
+/*
+atLeast tells whether the version is the given one or newer
+*/
+func (version brokerVersion) atLeast(major, minor, patch int) bool {
+	if version.major != major {
+		return version.major > major
+	}
+	if version.minor != minor {
+		return version.minor > minor
+	}
+	return version.patch >= patch
+}
+
+/*
+String formats the version as rabbitmq does
+*/
+func (version brokerVersion) String() string {
+	return strconv.Itoa(version.major) + "." + strconv.Itoa(version.minor) + "." + strconv.Itoa(version.patch)
+}
+
+/*
+hostVersion returns the rabbitmq version of the host, asking the api again once versionTTL passed
+*/
+func hostVersion(opt *options, host string) (brokerVersion, error) {
+	versionMutex.Lock()
+	cached, ok := hostVersions[host]
+	versionMutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.version, nil
+	}
+
+	version := brokerVersion{}
+	overview := struct {
+		RabbitmqVersion string `json:"rabbitmq_version"`
+	}{}
+	err := apiRequest(opt, host, "GET", "/api/overview?columns=rabbitmq_version", nil, &overview)
+	if err != nil {
+		return version, err
+	}
+	if overview.RabbitmqVersion == "" {
+		return version, errors.New(host + " does not report its rabbitmq version")
+	}
+	version, err = parseVersion(overview.RabbitmqVersion)
+	if err != nil {
+		return version, err
+	}
+
+	versionMutex.Lock()
+	hostVersions[host] = cachedVersion{version: version, expires: time.Now().Add(versionTTL)}
+	versionMutex.Unlock()
+	return version, nil
+}
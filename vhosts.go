@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+vhostOptions are the options of vhost mode
+*/
+type vhostOptions struct {
+	VhostLimits []string `long:"vhost-limits" description:"Thresholds of a vhost in vhost mode as vhost:warning:critical, e.g. tenant-a:1000,1000:5000,5000, overriding --warning and --critical. Repeat for every vhost, e.g. in the --config file."`
+}
+
+/*
+Vhost representation from /api/vhosts
+*/
+type Vhost struct {
+	Name          string       `json:"name"`
+	Messages      int          `json:"messages"`
+	MessagesReady int          `json:"messages_ready"`
+	MessagesUnack int          `json:"messages_unacknowledged"`
+	MessageStats  MessageStats `json:"message_stats"`
+
+	DefaultQueueType string `json:"default_queue_type"`
+
+	// ClusterState tells per node whether the vhost runs there, e.g. "running" or "stopped"
+	ClusterState map[string]string `json:"cluster_state"`
+}
+
+/*
+vhostLimits parses the --vhost-limits entries, vhost:warning:critical with the limits written like --warning,
+into the limits keyed by vhost. The limits are taken from the end so vhost names may contain colons.
+*/
+func vhostLimits(entries []string) (map[string][][]int, error) {
+	limits := map[string][][]int{}
+	for _, entry := range entries {
+		fields := strings.Split(entry, ":")
+		if len(fields) < 3 {
+			return nil, errors.New("Expected vhost:warning:critical in " + entry)
+		}
+		name := strings.Join(fields[:len(fields)-2], ":")
+		warning, err := limitMap(fields[len(fields)-2])
+		if err != nil {
+			return nil, err
+		}
+		critical, err := limitMap(fields[len(fields)-1])
+		if err != nil {
+			return nil, err
+		}
+		limits[name] = [][]int{warning, critical}
+	}
+	return limits, nil
+}
+
+/*
+runVhosts checks the message counts and rates aggregated per virtual host. Every vhost is held to its own
+--vhost-limits, or to --warning and --critical, and the rates to --rates-warning and --rates-critical.
+A vhost that is not running on every node is critical: clients of those nodes get their connections refused.
+*/
+func runVhosts(opt *options, hosts []string) {
+	warningLimits, err := limitMap(opt.Warning)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	criticalLimits, err := limitMap(opt.Critical)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	ownLimits, err := vhostLimits(opt.VhostLimits)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	rateWarning, err := parseRanges(opt.RatesWarning, rateCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	rateCritical, err := parseRanges(opt.RatesCritical, rateCounters)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		vhosts := []Vhost{}
+		err := apiRequest(opt, value, "GET", statsPath(opt, "/api/vhosts"), nil, &vhosts)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		checked, warnings, criticals := 0, 0, 0
+		details := []string{}
+		for _, vhost := range sortedVhosts(vhosts) {
+			warning, critical := warningLimits, criticalLimits
+			if own, ok := ownLimits[vhost.Name]; ok {
+				warning, critical = own[0], own[1]
+			}
+
+			breaches := vhostStateBreaches(vhost)
+			counts := []int{vhost.MessagesReady, vhost.MessagesUnack}
+			kinds := []string{"messages ready", "messages unacknowledged"}
+			for index, count := range counts {
+				if count >= critical[index] {
+					breaches = append(breaches, "CRITICAL vhost "+vhost.Name+" has "+strconv.Itoa(count)+" "+kinds[index])
+				} else if count >= warning[index] {
+					breaches = append(breaches, "WARNING vhost "+vhost.Name+" has "+strconv.Itoa(count)+" "+kinds[index])
+				}
+			}
+			rates := vhost.MessageStats.rates()
+			for _, name := range rateCounters {
+				state := rangeState(rates[name], rateWarning, rateCritical, name)
+				if state != "OK" {
+					breaches = append(breaches, state+" vhost "+vhost.Name+" "+name+" rate "+strconv.FormatFloat(rates[name], 'f', 1, 64)+" msgs/sec")
+				}
+			}
+			breaches, vhostWarnings, vhostCriticals := downgradeLines(vhost.Name, breaches)
+
+			checked++
+			if vhostCriticals > 0 {
+				criticals++
+			} else if vhostWarnings > 0 {
+				warnings++
+			}
+			details = append(details, breaches...)
+			recordGauge("rabbitmq.vhost.messages_ready", float64(vhost.MessagesReady), "vhost", vhost.Name)
+			recordGauge("rabbitmq.vhost.messages_unacknowledged", float64(vhost.MessagesUnack), "vhost", vhost.Name)
+			recordPerf(vhost.Name+"_messages_ready", float64(vhost.MessagesReady), "", perfLimit(warning, 0), perfLimit(critical, 0))
+			recordPerf(vhost.Name+"_messages_unacknowledged", float64(vhost.MessagesUnack), "", perfLimit(warning, 1), perfLimit(critical, 1))
+		}
+
+		state := "OK"
+		if criticals > 0 {
+			state = "CRITICAL"
+		} else if warnings > 0 {
+			state = "WARNING"
+		}
+		printLine(state + " " + summaryCounts("vhosts", checked, warnings, criticals))
+		for _, line := range details {
+			printLine(line)
+		}
+		return
+	}
+	printLine("UNKNOWN could not list the vhosts from any host")
+}
+
+/*
+vhostStateBreaches returns a critical line per node the vhost is not running on, in node order
+*/
+func vhostStateBreaches(vhost Vhost) []string {
+	nodes := []string{}
+	for node := range vhost.ClusterState {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	breaches := []string{}
+	for _, node := range nodes {
+		if state := vhost.ClusterState[node]; state != "running" {
+			breaches = append(breaches, "CRITICAL vhost "+vhost.Name+" is "+state+" on "+node)
+		}
+	}
+	return breaches
+}
+
+/*
+sortedVhosts returns the vhosts sorted by name
+*/
+func sortedVhosts(vhosts []Vhost) []Vhost {
+	sort.Slice(vhosts, func(i, j int) bool { return vhosts[i].Name < vhosts[j].Name })
+	return vhosts
+}
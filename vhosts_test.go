@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVhostLimits(t *testing.T) {
+	limits, err := vhostLimits([]string{"tenant-a:10,20:30,40", "team:b:1,1:2,2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][][]int{
+		"tenant-a": {{10, 20}, {30, 40}},
+		"team:b":   {{1, 1}, {2, 2}},
+	}
+	if reflect.DeepEqual(limits, expected) == false {
+		t.Errorf("vhostLimits = %v, expected %v", limits, expected)
+	}
+
+	for _, invalid := range []string{"tenant-a", "tenant-a:10,20", "tenant-a:10:30,40", "tenant-a:10,20:x,40"} {
+		if _, err := vhostLimits([]string{invalid}); err == nil {
+			t.Errorf("vhostLimits(%q) accepted", invalid)
+		}
+	}
+}
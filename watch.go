@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+webhookPayload is the json posted to --webhook when the state of the check changes
+*/
+type webhookPayload struct {
+	Check         string   `json:"check"`
+	Mode          string   `json:"mode"`
+	Hosts         []string `json:"hosts"`
+	Vhost         string   `json:"vhost"`
+	State         string   `json:"state"`
+	PreviousState string   `json:"previous_state"`
+	Since         string   `json:"since"`
+	Summary       string   `json:"summary"`
+	Output        []string `json:"output"`
+}
+
+/*
+transitionTracker debounces state changes: a new state is only reported once it held for the debounce period
+*/
+type transitionTracker struct {
+	debounce time.Duration
+	reported string
+	pending  string
+	since    time.Time
+}
+
+/*
+observe records the state of a round and returns true when it is a change to report
+*/
+func (tracker *transitionTracker) observe(state string, now time.Time) bool {
+	if state == tracker.reported {
+		tracker.pending = ""
+		return false
+	}
+	if state != tracker.pending {
+		tracker.pending = state
+		tracker.since = now
+	}
+	return now.Sub(tracker.since) >= tracker.debounce
+}
+
+/*
+runWatch repeats the check at the --watch interval until the process is stopped, posting state changes
+to the --webhook. Changed hosts, thresholds and filters of the --config file are picked up between rounds.
+*/
+func runWatch(opt *options, hosts []string, args []string) {
+	tracker := &transitionTracker{debounce: opt.WebhookDebounce, reported: "OK"}
+	watcher := newConfigWatcher(opt.Config)
+
+	for {
+		start := time.Now()
+		if watcher.changed() {
+			opt, hosts, args = reloadOptions(opt, hosts, args)
+			watcher.path = opt.Config
+			tracker.debounce = opt.WebhookDebounce
+		}
+		resetOutput()
+		startTelemetry(opt)
+		startResultLog(opt)
+
+		runChecks(opt, hosts, args)
+		runHooks(opt)
+		flushOutput()
+		exportTelemetry(opt)
+
+		err := writeResultLog(opt, start)
+		if err != nil {
+			log.Println(err.Error())
+		}
+
+		state := outputState()
+		if tracker.observe(state, start) {
+			previous := tracker.reported
+			tracker.reported = state
+			if opt.Webhook != "" {
+				err := postWebhook(opt, previous, state, tracker.since)
+				if err != nil {
+					log.Println(err.Error())
+				}
+			}
+		}
+
+		// the signal stopping a probe was meant for the process, which ends once the round is reported
+		if interrupted {
+			return
+		}
+		time.Sleep(opt.Watch - time.Since(start))
+	}
+}
+
+/*
+reloadOptions parses the command line and the config file again. A configuration failing to parse or apply
+is logged and the current one is kept, a typo must not stop the monitoring.
+*/
+func reloadOptions(opt *options, hosts []string, args []string) (*options, []string, []string) {
+	reloaded, reloadedArgs, _, err := parseOptions(os.Args[1:])
+	if err == nil {
+		var reloadedHosts []string
+		reloadedHosts, reloadedArgs, err = applyOptions(reloaded, reloadedArgs)
+		if err == nil {
+			log.Println("Reloaded the configuration")
+			return reloaded, reloadedHosts, reloadedArgs
+		}
+	}
+
+	log.Println("Keeping the current configuration, reloading failed: " + err.Error())
+	applyOptions(opt, nil)
+	return opt, hosts, args
+}
+
+/*
+postWebhook posts the change to the webhook together with the output of the round
+*/
+func postWebhook(opt *options, previous, state string, since time.Time) error {
+	outputMutex.Lock()
+	lines := append([]string{}, outputLines...)
+	outputMutex.Unlock()
+
+	payload := webhookPayload{
+		Check:         "check_rabbitmq",
+		Mode:          opt.Mode,
+		Hosts:         opt.Host,
+		Vhost:         opt.Vhost,
+		State:         state,
+		PreviousState: previous,
+		Since:         since.UTC().Format(time.RFC3339),
+		Output:        lines,
+	}
+	if len(lines) > 0 {
+		payload.Summary = lines[0]
+	}
+
+	encoded, err := jsonReader(payload)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Post(opt.Webhook, "application/json", encoded)
+	if err != nil {
+		return err
+	}
+	closeResponse(response)
+
+	if response.StatusCode >= 400 {
+		return errors.New("Posting the state change to " + opt.Webhook + " returned " + response.Status)
+	}
+	return nil
+}
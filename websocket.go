@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+websocketOptions are the options of websocket mode
+*/
+type websocketOptions struct {
+	WsProtocol string `long:"ws-protocol" default:"stomp" description:"The protocol spoken over the websocket in websocket mode: stomp or mqtt."`
+	WsPort     string `long:"ws-port" description:"The port of the web-stomp/web-mqtt listener. Defaults to 15674 for stomp and 15675 for mqtt."`
+	WsPath     string `long:"ws-path" default:"/ws" description:"The path of the websocket endpoint."`
+}
+
+// websocketGUID is the magic value from RFC 6455 used to compute Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+/*
+wsConn is a minimal client side websocket connection, just enough to run a protocol handshake
+*/
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+/*
+processWebSocket runs the websocket upgrade and the stomp/mqtt handshake against a host and prints the result
+*/
+func processWebSocket(opt *options, host string) {
+	start := time.Now()
+	err := probeWebSocket(opt, host)
+	elapsed := strconv.FormatInt(int64(time.Since(start)/time.Millisecond), 10)
+	name := "web-" + opt.WsProtocol
+
+	if err != nil {
+		printLine("CRITICAL " + name + " handshake with " + host + " failed: " + err.Error())
+		return
+	}
+	printLine("OK " + name + " handshake with " + host + " completed in " + elapsed + "ms")
+}
+
+/*
+probeWebSocket connects to the websocket endpoint, upgrades the connection and runs the protocol handshake
+*/
+func probeWebSocket(opt *options, host string) error {
+	port := opt.WsPort
+	subprotocol := ""
+	switch opt.WsProtocol {
+	case "stomp":
+		subprotocol = "v12.stomp"
+		if port == "" {
+			port = "15674"
+		}
+	case "mqtt":
+		subprotocol = "mqtt"
+		if port == "" {
+			port = "15675"
+		}
+	default:
+		return errors.New("Unknown websocket protocol " + opt.WsProtocol)
+	}
+
+	ws, err := dialWebSocket(opt, host, port, subprotocol)
+	if err != nil {
+		return err
+	}
+	defer ws.conn.Close()
+
+	if opt.WsProtocol == "mqtt" {
+		err = ws.mqttHandshake(opt)
+	} else {
+		err = ws.stompHandshake(opt)
+	}
+	if err != nil {
+		return err
+	}
+
+	// be polite and close the websocket, the result of the check does not depend on it
+	ws.writeFrame(0x8, []byte{0x03, 0xe8})
+	return nil
+}
+
+/*
+dialWebSocket opens the tcp (or tls) connection and performs the http upgrade
+*/
+func dialWebSocket(opt *options, host, port, subprotocol string) (*wsConn, error) {
+	address := net.JoinHostPort(host, port)
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	var conn net.Conn
+	var err error
+	if opt.Secure == true {
+		config := tlsClient.Clone()
+		config.ServerName = host
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	prefix := "ws"
+	if opt.Secure == true {
+		prefix = "wss"
+	}
+	request, err := http.NewRequest("GET", prefix+"://"+address+opt.WsPath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+	if err = request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errors.New("Upgrade refused with status " + response.Status)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, errors.New("Invalid Sec-WebSocket-Accept in upgrade response")
+	}
+
+	return &wsConn{conn: conn, reader: reader}, nil
+}
+
+/*
+writeFrame writes a single masked frame, as required for frames sent by a client
+*/
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, value := range payload {
+		frame = append(frame, value^mask[i%4])
+	}
+
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+/*
+readFrame reads a single frame from the server, skipping control frames
+*/
+func (ws *wsConn) readFrame() ([]byte, error) {
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(ws.reader, header); err != nil {
+			return nil, err
+		}
+		opcode := header[0] & 0x0f
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			extended := make([]byte, 2)
+			if _, err := io.ReadFull(ws.reader, extended); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended))
+		case 127:
+			extended := make([]byte, 8)
+			if _, err := io.ReadFull(ws.reader, extended); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(extended)
+		}
+		if length > 1<<20 {
+			return nil, errors.New("Websocket frame too large")
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(ws.reader, payload); err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case 0x8:
+			return nil, errors.New("Websocket closed by server")
+		case 0x9, 0xa:
+			continue
+		}
+		return payload, nil
+	}
+}
+
+/*
+stompHandshake sends a STOMP CONNECT frame and waits for CONNECTED
+*/
+func (ws *wsConn) stompHandshake(opt *options) error {
+	connect := "CONNECT\naccept-version:1.2\nhost:" + opt.Vhost + "\nlogin:" + opt.Username + "\npasscode:" + opt.Password + "\n\n\x00"
+	if err := ws.writeFrame(0x1, []byte(connect)); err != nil {
+		return err
+	}
+
+	payload, err := ws.readFrame()
+	if err != nil {
+		return err
+	}
+	// stomp allows heartbeat newlines before the frame itself
+	payload = bytes.TrimLeft(payload, "\r\n")
+	if bytes.HasPrefix(payload, []byte("CONNECTED")) {
+		ws.writeFrame(0x1, []byte("DISCONNECT\n\n\x00"))
+		return nil
+	}
+	if bytes.HasPrefix(payload, []byte("ERROR")) {
+		message := "Broker returned an ERROR frame"
+		for _, line := range bytes.Split(payload, []byte("\n")) {
+			if bytes.HasPrefix(line, []byte("message:")) {
+				message = message + ": " + string(line[len("message:"):])
+			}
+		}
+		return errors.New(message)
+	}
+
+	return errors.New("Unexpected STOMP frame in reply to CONNECT")
+}
+
+/*
+mqttHandshake sends an MQTT 3.1.1 CONNECT packet and waits for a successful CONNACK
+*/
+func (ws *wsConn) mqttHandshake(opt *options) error {
+	clientID := "nagios-check-" + strconv.Itoa(os.Getpid())
+	username := opt.Username
+	if opt.Vhost != "/" {
+		username = opt.Vhost + ":" + username
+	}
+
+	body := mqttString("MQTT")
+	// protocol level 4, username + password + clean session, 60s keepalive
+	body = append(body, 0x04, 0xc2, 0x00, 0x3c)
+	body = append(body, mqttString(clientID)...)
+	body = append(body, mqttString(username)...)
+	body = append(body, mqttString(opt.Password)...)
+
+	packet := []byte{0x10}
+	packet = append(packet, mqttLength(len(body))...)
+	packet = append(packet, body...)
+	if err := ws.writeFrame(0x2, packet); err != nil {
+		return err
+	}
+
+	payload, err := ws.readFrame()
+	if err != nil {
+		return err
+	}
+	if len(payload) < 4 || payload[0] != 0x20 {
+		return errors.New("Unexpected MQTT packet in reply to CONNECT")
+	}
+	if payload[3] != 0 {
+		return errors.New("Connection refused with CONNACK return code " + strconv.Itoa(int(payload[3])))
+	}
+
+	ws.writeFrame(0x2, []byte{0xe0, 0x00})
+	return nil
+}
+
+/*
+mqttString encodes a length prefixed MQTT string
+*/
+func mqttString(value string) []byte {
+	encoded := make([]byte, 2, 2+len(value))
+	binary.BigEndian.PutUint16(encoded, uint16(len(value)))
+	return append(encoded, value...)
+}
+
+/*
+mqttLength encodes the MQTT variable length remaining length field
+*/
+func mqttLength(length int) []byte {
+	encoded := []byte{}
+	for {
+		digit := byte(length % 128)
+		length = length / 128
+		if length > 0 {
+			digit = digit | 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+/*
+wiringOptions are the options of exchange-wiring mode
+*/
+type wiringOptions struct {
+	WiringQueues    []string `long:"wiring-queue" description:"Verify the dead letter exchange only of the queues whose vhost/name matches one of these patterns in exchange-wiring mode, like --include-queues. Repeatable, every queue with one by default."`
+	WiringExchanges []string `long:"wiring-exchange" description:"Verify the alternate exchange only of the exchanges whose vhost/name matches one of these patterns in exchange-wiring mode. Repeatable, every exchange with one by default."`
+}
+
+/*
+exchangeRoutes holds the exchanges of the cluster and where their bindings lead, to tell whether messages sent
+to an exchange reach a queue
+*/
+type exchangeRoutes struct {
+	exchanges map[string]bool
+	queues    map[string]bool
+	bindings  map[string][]Binding
+}
+
+/*
+reachesQueue tells whether the exchange of the vhost is bound to a queue, directly or through the exchanges it
+is bound to
+*/
+func (routes exchangeRoutes) reachesQueue(vhost, exchange string, visited map[string]bool) bool {
+	key := vhost + "/" + exchange
+	if visited[key] {
+		return false
+	}
+	visited[key] = true
+
+	for _, binding := range routes.bindings[key] {
+		if binding.DestinationType == "queue" {
+			return true
+		}
+		if binding.DestinationType == "exchange" && routes.reachesQueue(vhost, binding.Destination, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+verifyTarget returns the CRITICAL line about the subject sending its messages to an exchange that does not exist
+or routes them to no queue, "" when they reach one. The default exchange, named "", routes by the queue name.
+*/
+func (routes exchangeRoutes) verifyTarget(subject, vhost, exchange, routingKey string) string {
+	if exchange == "" {
+		if routingKey == "" || routes.queues[vhost+"/"+routingKey] {
+			return ""
+		}
+		return "CRITICAL " + subject + " to the default exchange and the queue " + routingKey + ", which does not exist"
+	}
+	if routes.exchanges[vhost+"/"+exchange] == false {
+		return "CRITICAL " + subject + " to the exchange " + exchange + ", which does not exist"
+	}
+	if routes.reachesQueue(vhost, exchange, map[string]bool{}) == false {
+		return "CRITICAL " + subject + " to the exchange " + exchange + ", which is bound to no queue"
+	}
+	return ""
+}
+
+/*
+runExchangeWiring checks the dead letter exchange of every queue and the alternate exchange of every exchange
+exist and lead to a queue. One pointing at nothing passes every other check until the day its messages are
+needed, and then they are gone.
+*/
+func runExchangeWiring(opt *options, hosts []string) {
+	queues, err := newQueueFilter(opt.WiringQueues, nil)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+	exchanges, err := newQueueFilter(opt.WiringExchanges, nil)
+	if err != nil {
+		printUnknown(err.Error())
+		return
+	}
+
+	// every host reports the same cluster, the first one answering is enough
+	for _, value := range hosts {
+		err := processExchangeWiring(opt, value, queues, exchanges)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		return
+	}
+	printLine("UNKNOWN could not read the exchanges and queues from any host")
+}
+
+/*
+processExchangeWiring prints a summary line followed by the dead letter and alternate exchanges leading nowhere
+*/
+func processExchangeWiring(opt *options, host string, queueFilter, exchangeFilter *queueFilter) error {
+	all := vhostScope(opt, "")
+	routes := exchangeRoutes{exchanges: map[string]bool{}, queues: map[string]bool{}, bindings: map[string][]Binding{}}
+
+	bindings, err := fetchBindings(all, host)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		key := binding.Vhost + "/" + binding.Source
+		routes.bindings[key] = append(routes.bindings[key], binding)
+	}
+
+	policies, err := fetchPolicies(all, host)
+	if err != nil {
+		return err
+	}
+
+	// the exchanges are listed before they are verified, their alternate exchange may come later in the listing
+	alternates := []Exchange{}
+	err = listExchanges(all, host, func(exchange Exchange) error {
+		routes.exchanges[exchange.Vhost+"/"+exchange.Name] = true
+		if exchangeFilter.matchesName(exchange.Vhost + "/" + exchange.Name) {
+			alternates = append(alternates, exchange)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deadLettered := []Queue{}
+	_, err = listQueues(all, host, "", "name,vhost,arguments,effective_policy_definition", func(queue Queue) error {
+		routes.queues[queue.Vhost+"/"+queue.Name] = true
+		if queueFilter.matches(queue) {
+			deadLettered = append(deadLettered, queue)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	offending := []queueBreach{}
+	for _, exchange := range alternates {
+		alternate, ok := exchange.Arguments["alternate-exchange"].(string)
+		if ok == false {
+			definition := effectiveDefinition(policies, exchange.Vhost, exchange.Name, "exchanges")
+			alternate, ok = definition["alternate-exchange"].(string)
+		}
+		if ok == false {
+			continue
+		}
+		checked++
+		name := exchange.Vhost + "/" + exchange.Name
+		line := downgradeLine(name, routes.verifyTarget("exchange "+name+" sends unroutable messages", exchange.Vhost, alternate, ""))
+		if line != "" {
+			offending = append(offending, queueBreach{vhost: exchange.Vhost, name: exchange.Name, line: line})
+		}
+	}
+	for _, queue := range deadLettered {
+		deadLetter, ok := queue.Arguments["x-dead-letter-exchange"].(string)
+		if ok == false {
+			deadLetter, ok = queue.EffectivePolicyDefinition["dead-letter-exchange"].(string)
+		}
+		if ok == false {
+			continue
+		}
+		routingKey, ok := queue.Arguments["x-dead-letter-routing-key"].(string)
+		if ok == false {
+			routingKey, _ = queue.EffectivePolicyDefinition["dead-letter-routing-key"].(string)
+		}
+		checked++
+		name := queue.Vhost + "/" + queue.Name
+		line := downgradeLine(name, routes.verifyTarget("queue "+name+" dead letters", queue.Vhost, deadLetter, routingKey))
+		if line != "" {
+			offending = append(offending, queueBreach{vhost: queue.Vhost, name: queue.Name, line: line})
+		}
+	}
+
+	sort.SliceStable(offending, func(i, j int) bool {
+		if offending[i].vhost != offending[j].vhost {
+			return offending[i].vhost < offending[j].vhost
+		}
+		return offending[i].name < offending[j].name
+	})
+
+	warnings, criticals := 0, 0
+	for _, breach := range offending {
+		if lineState(breach.line) == "CRITICAL" {
+			criticals++
+		} else {
+			warnings++
+		}
+	}
+	state := "OK"
+	if criticals > 0 {
+		state = "CRITICAL"
+	} else if warnings > 0 {
+		state = "WARNING"
+	}
+	printLine(state + " " + summaryCounts("dead letter and alternate exchanges", checked, warnings, criticals))
+	for _, breach := range offending {
+		printLine(breach.line)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+/*
+printDiscovery prints the rows as a zabbix low-level discovery document, one entry per object carrying
+the macros of its identifying columns
+*/
+func printDiscovery(rows [][]string, macros []string) error {
+	entries := []map[string]string{}
+	for _, row := range rows[1:] {
+		entry := map[string]string{}
+		for column, macro := range macros {
+			entry[macro] = row[column]
+		}
+		entries = append(entries, entry)
+	}
+
+	encoded, err := json.Marshal(map[string]interface{}{"data": entries})
+	if err != nil {
+		return err
+	}
+	printLine(string(encoded))
+	return nil
+}
+
+/*
+printItemValue prints the bare value of the item column for the object whose identifying columns equal keys,
+as expected from a zabbix item. Items match the column headers ignoring case, with '_' standing for spaces.
+*/
+func printItemValue(rows [][]string, identity int, keys []string, item string) error {
+	if len(keys) != identity {
+		return errors.New("The zabbix-value output requires the " + itemNames(rows[0][:identity]) + " of the object as arguments")
+	}
+
+	column := -1
+	for index, header := range rows[0] {
+		if strings.EqualFold(strings.Replace(header, " ", "_", -1), item) {
+			column = index
+		}
+	}
+	if column < 0 {
+		return errors.New("Unknown item " + item + ", expected one of " + itemNames(rows[0]))
+	}
+
+	for _, row := range rows[1:] {
+		matched := true
+		for index, key := range keys {
+			matched = matched && row[index] == key
+		}
+		if matched {
+			printLine(row[column])
+			return nil
+		}
+	}
+	return errors.New("No object " + strings.Join(keys, " ") + " found")
+}
+
+/*
+itemNames renders column headers the way they are given as items
+*/
+func itemNames(headers []string) string {
+	items := []string{}
+	for _, header := range headers {
+		items = append(items, strings.ToLower(strings.Replace(header, " ", "_", -1)))
+	}
+	return strings.Join(items, ", ")
+}